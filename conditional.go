@@ -0,0 +1,106 @@
+package rtr
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Validators identify the version of a response for conditional requests.
+// Either may be empty.
+type Validators struct {
+	// ETag is the entity tag, e.g. `"v42"` or `W/"v42"`. An unquoted value is
+	// quoted as a strong tag.
+	ETag string
+	// LastModified is when the resource last changed. It is sent with second
+	// precision.
+	LastModified time.Time
+}
+
+// CheckNotModified sets the ETag and Last-Modified headers of the response
+// from v and evaluates the request's If-None-Match and If-Modified-Since
+// preconditions. If they show the client's copy is current, it writes
+// 304 Not Modified with an empty body and returns true, and the handler
+// should return without writing a body:
+//
+//	if rtr.CheckNotModified(w, r, rtr.Validators{ETag: article.Version}) {
+//		return ""
+//	}
+//
+// Handlers declaring validators this way can skip rendering entirely, and
+// the ETag middleware keeps their ETag instead of hashing the body.
+func CheckNotModified(w http.ResponseWriter, r *http.Request, v Validators) bool {
+	etag := formatETag(v.ETag)
+	h := w.Header()
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("Last-Modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if !notModified(r, etag, v.LastModified) {
+		return false
+	}
+	writeNotModified(w)
+	return true
+}
+
+// formatETag quotes etag unless it already is a quoted or weak tag.
+func formatETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, `W/"`) {
+		return etag
+	}
+	return `"` + etag + `"`
+}
+
+// notModified reports whether the GET or HEAD request r has a current copy
+// of the response with the given validators, following RFC 9110 section
+// 13.2.2: If-None-Match takes precedence over If-Modified-Since.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && etagListMatches(inm, etag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// etagListMatches reports whether the If-None-Match list matches etag,
+// using the weak comparison.
+func etagListMatches(list, etag string) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	opaque := strings.TrimPrefix(etag, "W/")
+	for candidate := range strings.SplitSeq(list, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified writes a 304 Not Modified response, dropping the headers
+// describing a body, as http.ServeContent does.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+	if h.Get("ETag") != "" {
+		h.Del("Last-Modified")
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,66 @@
+package rtr_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	modified := time.Date(2026, 5, 1, 12, 0, 0, 500, time.UTC)
+	validators := rtr.Validators{ETag: "v2", LastModified: modified}
+
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		want   bool
+	}{
+		{name: "no preconditions", want: false},
+		{name: "matching etag", header: map[string]string{"If-None-Match": `"v2"`}, want: true},
+		{name: "weak match", header: map[string]string{"If-None-Match": `"v1", W/"v2"`}, want: true},
+		{name: "wildcard", header: map[string]string{"If-None-Match": "*"}, want: true},
+		{name: "other etag", header: map[string]string{"If-None-Match": `"v1"`}, want: false},
+		{name: "etag takes precedence", header: map[string]string{
+			"If-None-Match":     `"v1"`,
+			"If-Modified-Since": modified.Add(time.Hour).Format(http.TimeFormat),
+		}, want: false},
+		{name: "not modified since", header: map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, want: true},
+		{name: "modified since", header: map[string]string{"If-Modified-Since": modified.Add(-time.Second).Format(http.TimeFormat)}, want: false},
+		{name: "invalid date", header: map[string]string{"If-Modified-Since": "yesterday"}, want: false},
+		{name: "head", method: http.MethodHead, header: map[string]string{"If-None-Match": `"v2"`}, want: true},
+		{name: "post", method: http.MethodPost, header: map[string]string{"If-None-Match": `"v2"`}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			rr.Header().Set("Content-Type", "text/html")
+
+			if got := rtr.CheckNotModified(rr, req, validators); got != tt.want {
+				t.Fatalf("Expected %v, got %v", tt.want, got)
+			}
+			if rr.Header().Get("ETag") != `"v2"` {
+				t.Errorf("Expected the ETag to be set and quoted, got %q", rr.Header().Get("ETag"))
+			}
+			if tt.want {
+				if rr.Code != http.StatusNotModified || rr.Header().Get("Content-Type") != "" {
+					t.Errorf("Expected a bare 304, got %d %v", rr.Code, rr.Header())
+				}
+			} else if rr.Header().Get("Last-Modified") != "Fri, 01 May 2026 12:00:00 GMT" {
+				t.Errorf("Expected Last-Modified to be set, got %q", rr.Header().Get("Last-Modified"))
+			}
+		})
+	}
+}
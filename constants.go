@@ -11,3 +11,6 @@ const ParamsKey contextKey = "rtr.path.params"
 
 // ExecutionSequenceKey is used to track the execution sequence of middlewares in tests
 const ExecutionSequenceKey contextKey = "rtr.execution.sequence"
+
+// RouteKey is the key used to store the matched route in the request context
+const RouteKey contextKey = "rtr.route"
@@ -138,6 +138,11 @@ type Group struct {
 	Routes      []Route  `json:"routes,omitempty"`
 	Middlewares []string `json:"middlewares,omitempty"`
 	Name        string   `json:"name,omitempty"`
+	// MaxBodySize is the request body limit in bytes for the group's routes
+	// that do not set their own. It is only enforced when the limits are
+	// passed to a body limit middleware with middlewares.BodyLimitsFromItems,
+	// and only for named routes.
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
 }
 
 // GetName implements ItemInterface
@@ -195,6 +200,11 @@ type Route struct {
 	ErrorHandler string   `json:"errorHandler,omitempty"` // Error handler reference
 	Name         string   `json:"name,omitempty"`
 	Middlewares  []string `json:"middlewares,omitempty"`
+	// MaxBodySize is the request body limit in bytes, negative meaning
+	// unlimited. It is only enforced when the limits are passed to a body
+	// limit middleware with middlewares.BodyLimitsFromItems, which requires
+	// the route to have a Name.
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
 }
 
 // GetName implements ItemInterface
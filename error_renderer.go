@@ -0,0 +1,108 @@
+package rtr
+
+import (
+	"context"
+	"encoding/json"
+	"html"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errorRendererKey is the context key for the router's ErrorRenderer.
+const errorRendererKey contextKey = "rtr.error.renderer"
+
+// ErrorRenderer writes an error response with the given status code. The
+// error may be nil. Set one with RouterInterface.SetErrorRenderer to render
+// errors in the application's style.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// RenderError writes an error response with the renderer of the router
+// serving r, or with DefaultErrorRenderer if it has none. Middlewares use it
+// so their errors match the application's.
+func RenderError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if r != nil {
+		if renderer, ok := r.Context().Value(errorRendererKey).(ErrorRenderer); ok && renderer != nil {
+			renderer(w, r, status, err)
+			return
+		}
+	}
+	DefaultErrorRenderer(w, r, status, err)
+}
+
+// DefaultErrorRenderer writes the error as JSON, HTML or plain text,
+// whichever the request's Accept header prefers. The message is the error
+// text for 4xx statuses and the status text otherwise, so internal errors
+// are not disclosed.
+func DefaultErrorRenderer(w http.ResponseWriter, r *http.Request, status int, err error) {
+	message := http.StatusText(status)
+	if err != nil && status >= 400 && status < 500 {
+		message = err.Error()
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+
+	accept := ""
+	if r != nil {
+		accept = r.Header.Get("Accept")
+	}
+	switch preferredErrorFormat(accept) {
+	case "json":
+		h.Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"error": map[string]any{"status": status, "message": message},
+		})
+	case "html":
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		title := html.EscapeString(strconv.Itoa(status) + " " + http.StatusText(status))
+		_, _ = w.Write([]byte("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>" + title +
+			"</title></head><body><h1>" + title + "</h1><p>" + html.EscapeString(message) + "</p></body></html>\n"))
+	default:
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(message + "\n"))
+	}
+}
+
+// preferredErrorFormat returns "json", "html" or "text" for an Accept
+// header, picking the acceptable format with the highest q-value. Ties go
+// to the format listed first.
+func preferredErrorFormat(accept string) string {
+	best, bestQ := "text", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		format := ""
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			format = "json"
+		case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+			format = "html"
+		case mediaType == "text/plain":
+			format = "text"
+		}
+		if format != "" && q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	return best
+}
+
+// withErrorRenderer returns a shallow copy of req carrying renderer.
+func withErrorRenderer(req *http.Request, renderer ErrorRenderer) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), errorRendererKey, renderer))
+}
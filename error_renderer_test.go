@@ -0,0 +1,94 @@
+package rtr_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dracory/rtr"
+)
+
+func TestDefaultErrorRenderer(t *testing.T) {
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "text/plain; charset=utf-8"},
+		{"application/json", "application/json"},
+		{"application/problem+json", "application/json"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8"},
+		{"text/html;q=0.5, application/json", "application/json"},
+		{"image/png", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", tt.accept)
+		rr := httptest.NewRecorder()
+
+		rtr.DefaultErrorRenderer(rr, req, http.StatusInternalServerError, errors.New("db password leaked"))
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("%q: expected status 500, got %d", tt.accept, rr.Code)
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != tt.contentType {
+			t.Errorf("%q: expected Content-Type %q, got %q", tt.accept, tt.contentType, ct)
+		}
+		if strings.Contains(rr.Body.String(), "leaked") {
+			t.Errorf("%q: expected internal errors not to be disclosed, got %q", tt.accept, rr.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	rtr.DefaultErrorRenderer(rr, req, http.StatusBadRequest, errors.New("missing name"))
+
+	var body struct {
+		Error struct {
+			Status  int    `json:"status"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Expected JSON body, got %q", rr.Body.String())
+	}
+	if body.Error.Status != http.StatusBadRequest || body.Error.Message != "missing name" {
+		t.Errorf("Unexpected body %+v", body)
+	}
+}
+
+func TestRouter_SetErrorRenderer(t *testing.T) {
+	renderer := func(w http.ResponseWriter, r *http.Request, status int, err error) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("custom " + http.StatusText(status)))
+	}
+
+	router := rtr.NewRouter().SetErrorRenderer(renderer)
+	router.AddRoute(rtr.Get("/fail", func(w http.ResponseWriter, r *http.Request) {
+		rtr.RenderError(w, r, http.StatusConflict, nil)
+	}))
+	if router.GetErrorRenderer() == nil {
+		t.Fatal("Expected the renderer to be set")
+	}
+
+	for path, expected := range map[string]string{
+		"/fail":    "custom Conflict",
+		"/missing": "custom Not Found",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Body.String() != expected {
+			t.Errorf("%s: expected %q, got %q", path, expected, rr.Body.String())
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	rtr.NewRouter().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	if rr.Body.String() != "404 page not found\n" {
+		t.Errorf("Expected the standard 404 without a renderer, got %q", rr.Body.String())
+	}
+}
@@ -3,15 +3,16 @@ module github.com/dracory/rtr
 go 1.26
 
 require (
+	github.com/andybalholm/brotli v1.2.1
 	github.com/jedib0t/go-pretty/v6 v6.8.3
 	github.com/jellydator/ttlcache/v3 v3.4.1
+	github.com/klauspost/compress v1.18.5
 	github.com/samber/lo v1.53.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/sync v0.22.0
 )
 
-require (
-	github.com/clipperhouse/uax29/v2 v2.7.0 // indirect
-	golang.org/x/sync v0.22.0 // indirect
-)
+require github.com/clipperhouse/uax29/v2 v2.7.0 // indirect
 
 require (
 	github.com/mattn/go-runewidth v0.0.27 // indirect
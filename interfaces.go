@@ -134,6 +134,20 @@ type RouteInterface interface {
 	// SetJSHandler sets the JavaScript handler function for this route and returns the route for method chaining.
 	SetJSHandler(handler JSHandler) RouteInterface
 
+	// GetSSEHandler returns the server-sent events handler function associated with this route.
+	GetSSEHandler() SSEHandler
+	// SetSSEHandler sets the server-sent events handler function for this route and returns the route for method chaining.
+	SetSSEHandler(handler SSEHandler) RouteInterface
+
+	// GetWebSocketHandler returns the WebSocket handler function associated with this route.
+	GetWebSocketHandler() WebSocketHandler
+	// SetWebSocketHandler sets the WebSocket handler function for this route and returns the route for method chaining.
+	SetWebSocketHandler(handler WebSocketHandler) RouteInterface
+	// GetWebSocketConfig returns the WebSocket configuration of this route.
+	GetWebSocketConfig() WebSocketConfig
+	// SetWebSocketConfig sets the WebSocket configuration of this route and returns the route for method chaining.
+	SetWebSocketConfig(config WebSocketConfig) RouteInterface
+
 	// GetStaticHandler returns the static handler function associated with this route.
 	GetStaticHandler() StaticHandler
 	// SetStaticHandler sets the static handler function for this route and returns the route for method chaining.
@@ -320,6 +334,12 @@ type RouterInterface interface {
 	// GetDomains returns all domains that belong to this router
 	GetDomains() []DomainInterface
 
+	// SetErrorRenderer sets the renderer for error responses of the router, such as
+	// 404 Not Found, and of middlewares using RenderError. Returns the router for method chaining.
+	SetErrorRenderer(renderer ErrorRenderer) RouterInterface
+	// GetErrorRenderer returns the renderer set with SetErrorRenderer, or nil.
+	GetErrorRenderer() ErrorRenderer
+
 	// List displays the router's configuration in formatted tables for debugging and documentation
 	// Shows global middleware, domains, direct routes, and route groups
 	List()
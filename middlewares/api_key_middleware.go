@@ -0,0 +1,152 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// Errors passed to APIKeyConfig.OnError and RequireAPIKeyScopes failures.
+var (
+	ErrAPIKeyMissing           = errors.New("api key: missing")
+	ErrAPIKeyInvalid           = errors.New("api key: invalid")
+	ErrAPIKeyExpired           = errors.New("api key: expired")
+	ErrAPIKeyInsufficientScope = errors.New("api key: insufficient scope")
+)
+
+// apiKeyContextKey is the context key used to store the authenticated APIKey.
+type apiKeyContextKey struct{}
+
+// APIKeyConfig configures APIKeyMiddleware.
+type APIKeyConfig struct {
+	// Store looks keys up by hash. Required.
+	Store APIKeyStore
+	// HeaderName is the header carrying the key. Optional; defaults to
+	// "X-API-Key".
+	HeaderName string
+	// QueryParam, if set, also accepts the key from this query parameter
+	// (e.g. "api_key"). Query strings end up in logs, so prefer the header.
+	QueryParam string
+	// Optional lets requests without a key through unauthenticated. Requests
+	// with an invalid key are still rejected.
+	Optional bool
+	// OnUsage is called for every request authenticated with a key, e.g. to
+	// record last-used timestamps or usage metrics. It runs before the
+	// handler, so it should be quick or hand off to a goroutine.
+	OnUsage func(r *http.Request, key *APIKey)
+	// OnError is called when a request is rejected. Optional; defaults to
+	// 401 Unauthorized.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// APIKeyMiddleware returns a middleware that authenticates machine clients by
+// API key. The key is read from the configured header (or query parameter),
+// hashed with HashAPIKey and looked up in the store; the matching APIKey,
+// including its scopes, is stored in the request context (see GetAPIKey).
+// Combine it with RequireAPIKeyScopes on routes and groups to restrict
+// access by scope.
+//
+// If config has no Store, every request is answered with 500 Internal Server
+// Error describing the problem.
+func APIKeyMiddleware(config APIKeyConfig) rtr.MiddlewareInterface {
+	var configErr string
+	if isNilInterface(config.Store) {
+		configErr = "api key middleware: Store is required"
+	}
+
+	if config.HeaderName == "" {
+		config.HeaderName = "X-API-Key"
+	}
+	if config.OnError == nil {
+		config.OnError = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+	}
+
+	return rtr.NewMiddleware().
+		SetName("API Key Middleware").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if configErr != "" {
+					http.Error(w, configErr, http.StatusInternalServerError)
+					return
+				}
+
+				plaintext := r.Header.Get(config.HeaderName)
+				if plaintext == "" && config.QueryParam != "" {
+					plaintext = r.URL.Query().Get(config.QueryParam)
+				}
+				if plaintext == "" {
+					if config.Optional {
+						next.ServeHTTP(w, r)
+						return
+					}
+					config.OnError(w, r, ErrAPIKeyMissing)
+					return
+				}
+
+				key, err := config.Store.FindAPIKeyByHash(r.Context(), HashAPIKey(plaintext))
+				if err != nil {
+					slog.Default().Error("api key middleware: store lookup failed", "error", err.Error())
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				if key == nil {
+					config.OnError(w, r, ErrAPIKeyInvalid)
+					return
+				}
+				if !key.ExpiresAt.IsZero() && !time.Now().Before(key.ExpiresAt) {
+					config.OnError(w, r, ErrAPIKeyExpired)
+					return
+				}
+
+				if config.OnUsage != nil {
+					config.OnUsage(r, key)
+				}
+
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+			})
+		})
+}
+
+// RequireAPIKeyScopes returns a middleware that only lets through requests
+// authenticated by APIKeyMiddleware with a key holding all of scopes. Add it
+// to a route or group to declare the scopes it needs. Requests without a key
+// get 401 Unauthorized, keys lacking a scope 403 Forbidden.
+func RequireAPIKeyScopes(scopes ...string) rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName("Require API Key Scopes").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key := GetAPIKey(r.Context())
+				if key == nil {
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+				for _, scope := range scopes {
+					if !key.HasScope(scope) {
+						http.Error(w, "Forbidden", http.StatusForbidden)
+						return
+					}
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+}
+
+// GetAPIKey returns the API key that authenticated the request, or nil.
+func GetAPIKey(ctx context.Context) *APIKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	return key
+}
+
+// APIKeyHasScope reports whether the request was authenticated with an API
+// key holding scope.
+func APIKeyHasScope(ctx context.Context, scope string) bool {
+	key := GetAPIKey(ctx)
+	return key != nil && key.HasScope(scope)
+}
@@ -0,0 +1,161 @@
+package middlewares_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+func apiKeyRequest(router http.Handler, path, key string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if key != "" {
+		req.Header.Set("X-API-Key", key)
+	}
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestAPIKeyMiddleware_ScopesOnRoutesAndGroups(t *testing.T) {
+	store := middlewares.NewMemoryAPIKeyStore()
+	readKey, _ := store.Create(context.Background(), "partner-a", "orders:read")
+	writeKey, _ := store.Create(context.Background(), "partner-b", "orders:read", "orders:write")
+
+	var used []string
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.APIKeyMiddleware(middlewares.APIKeyConfig{
+		Store: store,
+		OnUsage: func(r *http.Request, key *middlewares.APIKey) {
+			used = append(used, key.ID)
+		},
+	})})
+	router.AddGroup(rtr.NewGroup().
+		SetPrefix("/orders").
+		AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.RequireAPIKeyScopes("orders:read")}).
+		AddRoute(rtr.Get("/list", ok)).
+		AddRoute(rtr.Post("/create", ok).AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.RequireAPIKeyScopes("orders:write")})))
+
+	if code := apiKeyRequest(router, "/orders/list", readKey); code != http.StatusOK {
+		t.Errorf("Expected status 200 for read key, got %d", code)
+	}
+	if code := apiKeyRequest(router, "/orders/list", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without key, got %d", code)
+	}
+	if code := apiKeyRequest(router, "/orders/list", "not-a-key"); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for unknown key, got %d", code)
+	}
+
+	post := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/orders/create", nil)
+		req.Header.Set("X-API-Key", key)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := post(readKey); code != http.StatusForbidden {
+		t.Errorf("Expected status 403 for missing scope, got %d", code)
+	}
+	if code := post(writeKey); code != http.StatusOK {
+		t.Errorf("Expected status 200 for write key, got %d", code)
+	}
+
+	if len(used) != 3 || used[0] != "partner-a" || used[2] != "partner-b" {
+		t.Errorf("Unexpected usage records %v", used)
+	}
+}
+
+func TestAPIKeyMiddleware_ContextAndQuery(t *testing.T) {
+	store := middlewares.NewMemoryAPIKeyStore()
+	key, _ := store.Create(context.Background(), "partner", "reports")
+
+	var hasScope bool
+	handler := middlewares.APIKeyMiddleware(middlewares.APIKeyConfig{Store: store, QueryParam: "api_key"}).
+		GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hasScope = middlewares.APIKeyHasScope(r.Context(), "reports")
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/?api_key="+key, nil))
+	if rr.Code != http.StatusOK || !hasScope {
+		t.Errorf("Expected query key to authenticate with scope, got status %d scope %v", rr.Code, hasScope)
+	}
+}
+
+func TestAPIKeyMiddleware_OptionalAndErrors(t *testing.T) {
+	store := middlewares.NewMemoryAPIKeyStore()
+	_ = store.Add(middlewares.APIKey{
+		ID:        "old",
+		Hash:      middlewares.HashAPIKey("expired-key"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	})
+
+	var gotErr error
+	handler := middlewares.APIKeyMiddleware(middlewares.APIKeyConfig{
+		Store:    store,
+		Optional: true,
+		OnError: func(w http.ResponseWriter, r *http.Request, err error) {
+			gotErr = err
+			w.WriteHeader(http.StatusUnauthorized)
+		},
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if code := apiKeyRequest(handler, "/", ""); code != http.StatusOK {
+		t.Errorf("Expected optional middleware to pass through, got %d", code)
+	}
+	if code := apiKeyRequest(handler, "/", "expired-key"); code != http.StatusUnauthorized || !errors.Is(gotErr, middlewares.ErrAPIKeyExpired) {
+		t.Errorf("Expected expired key rejection, got status %d err %v", code, gotErr)
+	}
+
+	handler = middlewares.APIKeyMiddleware(middlewares.APIKeyConfig{}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if code := apiKeyRequest(handler, "/", "x"); code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500 without store, got %d", code)
+	}
+}
+
+func TestMemoryAPIKeyStore_Rotate(t *testing.T) {
+	ctx := context.Background()
+	store := middlewares.NewMemoryAPIKeyStore()
+	oldKey, _ := store.Create(ctx, "partner", "orders:read")
+
+	newKey, err := store.Rotate(ctx, "partner", time.Hour)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	handler := middlewares.APIKeyMiddleware(middlewares.APIKeyConfig{Store: store}).
+		GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if code := apiKeyRequest(handler, "/", oldKey); code != http.StatusOK {
+		t.Errorf("Expected old key to work during grace period, got %d", code)
+	}
+	if code := apiKeyRequest(handler, "/", newKey); code != http.StatusOK {
+		t.Errorf("Expected new key to work, got %d", code)
+	}
+
+	rotated, _ := store.FindAPIKeyByHash(ctx, middlewares.HashAPIKey(newKey))
+	if rotated == nil || !rotated.HasScope("orders:read") || !rotated.ExpiresAt.IsZero() {
+		t.Errorf("Expected rotated key to keep scopes without expiry, got %+v", rotated)
+	}
+
+	if _, err := store.Rotate(ctx, "partner", 0); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if code := apiKeyRequest(handler, "/", newKey); code != http.StatusUnauthorized {
+		t.Errorf("Expected immediate rotation to revoke previous key, got %d", code)
+	}
+
+	if err := store.Revoke(ctx, "partner"); err != nil {
+		t.Errorf("Revoke failed: %v", err)
+	}
+	if _, err := store.Rotate(ctx, "partner", 0); !errors.Is(err, middlewares.ErrAPIKeyNotFound) {
+		t.Errorf("Expected ErrAPIKeyNotFound, got %v", err)
+	}
+}
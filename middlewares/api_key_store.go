@@ -0,0 +1,179 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// APIKey describes an API key as kept by an APIKeyStore. Only the hash of the
+// key is stored; the plaintext is shown to the client once, when the key is
+// created or rotated.
+type APIKey struct {
+	// ID identifies the key across rotations, e.g. the partner it was issued
+	// to.
+	ID string
+	// Hash is HashAPIKey of the plaintext key.
+	Hash string
+	// Scopes lists the permissions granted to the key.
+	Scopes []string
+	// ExpiresAt, if set, is when the key stops being accepted.
+	ExpiresAt time.Time
+	// Metadata holds application-defined data, e.g. the partner name.
+	Metadata map[string]string
+}
+
+// HasScope reports whether the key was granted scope.
+func (k *APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// APIKeyStore looks up API keys by hash, so a store never needs to see or
+// keep plaintext keys.
+type APIKeyStore interface {
+	// FindAPIKeyByHash returns the key with the given hash, or nil if there
+	// is none.
+	FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+}
+
+// ErrAPIKeyNotFound is returned by MemoryAPIKeyStore when rotating or revoking
+// an unknown key ID.
+var ErrAPIKeyNotFound = errors.New("api key: not found")
+
+// HashAPIKey returns the hex-encoded SHA-256 of key. API keys are long random
+// strings, so a fast unsalted hash is enough to make a leaked store useless
+// while keeping lookups by hash possible.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey returns a new random API key with 256 bits of entropy,
+// prefixed with prefix (e.g. "sk_live_") to make keys recognizable.
+func GenerateAPIKey(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore, intended for tests and
+// single-instance deployments. It supports rotating keys with a grace period
+// during which both the old and the new key are accepted.
+type MemoryAPIKeyStore struct {
+	keys map[string]APIKey
+	mu   sync.RWMutex
+}
+
+// NewMemoryAPIKeyStore returns an empty MemoryAPIKeyStore.
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[string]APIKey)}
+}
+
+// FindAPIKeyByHash implements APIKeyStore.
+func (s *MemoryAPIKeyStore) FindAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[hash]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+// Add stores key, which must have its Hash set.
+func (s *MemoryAPIKeyStore) Add(key APIKey) error {
+	if key.Hash == "" {
+		return errors.New("api key: Hash is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key.Hash] = key
+	return nil
+}
+
+// Create generates a new key for id with the given scopes, stores its hash
+// and returns the plaintext key.
+func (s *MemoryAPIKeyStore) Create(ctx context.Context, id string, scopes ...string) (string, error) {
+	plaintext, err := GenerateAPIKey("")
+	if err != nil {
+		return "", err
+	}
+	if err := s.Add(APIKey{ID: id, Hash: HashAPIKey(plaintext), Scopes: scopes}); err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// Rotate issues a new key for id with the same scopes and metadata and
+// returns its plaintext. The current keys for id keep working for grace, so
+// clients can switch over without downtime; a zero grace revokes them
+// immediately.
+func (s *MemoryAPIKeyStore) Rotate(ctx context.Context, id string, grace time.Duration) (string, error) {
+	plaintext, err := GenerateAPIKey("")
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current *APIKey
+	retireAt := time.Now().Add(grace)
+	for hash, key := range s.keys {
+		if key.ID != id {
+			continue
+		}
+		if current == nil || key.ExpiresAt.IsZero() {
+			k := key
+			current = &k
+		}
+		if grace <= 0 {
+			delete(s.keys, hash)
+			continue
+		}
+		if key.ExpiresAt.IsZero() || key.ExpiresAt.After(retireAt) {
+			key.ExpiresAt = retireAt
+			s.keys[hash] = key
+		}
+	}
+	if current == nil {
+		return "", ErrAPIKeyNotFound
+	}
+
+	s.keys[HashAPIKey(plaintext)] = APIKey{
+		ID:       id,
+		Hash:     HashAPIKey(plaintext),
+		Scopes:   slices.Clone(current.Scopes),
+		Metadata: maps.Clone(current.Metadata),
+	}
+	return plaintext, nil
+}
+
+// Revoke removes every key for id.
+func (s *MemoryAPIKeyStore) Revoke(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	found := false
+	for hash, key := range s.keys {
+		if key.ID == id {
+			delete(s.keys, hash)
+			found = true
+		}
+	}
+	if !found {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// basicAuthUsernameKey is the context key used to store the authenticated
+// username.
+type basicAuthUsernameKey struct{}
+
+// BasicAuthConfig configures BasicAuthMiddleware. At least one of Users,
+// HtpasswdFile, Htpasswd or Validator is required; a request is accepted if
+// any of them accepts the credentials.
+type BasicAuthConfig struct {
+	// Users maps usernames to plaintext passwords.
+	Users map[string]string
+	// HtpasswdFile is the path of an htpasswd file, loaded once when the
+	// middleware is created.
+	HtpasswdFile string
+	// Htpasswd is an already loaded htpasswd file.
+	Htpasswd *Htpasswd
+	// Validator checks credentials against a custom source, e.g. a database.
+	Validator func(ctx context.Context, username, password string) bool
+
+	// Realm is sent in the WWW-Authenticate header. Optional; defaults to
+	// "restricted".
+	Realm string
+
+	// MaxFailures is the number of failed attempts allowed from one client
+	// within FailureWindow before further attempts are refused with 429 Too
+	// Many Requests. Optional; defaults to 5. A negative value disables
+	// throttling.
+	MaxFailures int
+	// FailureWindow is how long failed attempts are remembered. Optional;
+	// defaults to 15 minutes.
+	FailureWindow time.Duration
+	// KeyFunc identifies the client for throttling. Optional; defaults to
+	// KeyByIP.
+	KeyFunc RateLimitKeyFunc
+}
+
+// BasicAuthMiddleware returns a middleware that enforces HTTP Basic
+// Authentication against multiple users. Unlike
+// BasicAuthenticationMiddleware, it supports htpasswd files, a custom
+// validator and a configurable realm. The authenticated username is stored in
+// the request context (see GetBasicAuthUsername).
+//
+// Failed attempts are counted per client; once MaxFailures is reached, the
+// client gets 429 Too Many Requests with a Retry-After header until the
+// failure window expires, which stops password brute forcing.
+//
+// If HtpasswdFile cannot be loaded, or none of Users, HtpasswdFile, Htpasswd
+// and Validator is set, every request is answered with 500 Internal Server
+// Error describing the problem.
+func BasicAuthMiddleware(config BasicAuthConfig) rtr.MiddlewareInterface {
+	var configErr string
+	if config.HtpasswdFile != "" && config.Htpasswd == nil {
+		htpasswd, err := LoadHtpasswd(config.HtpasswdFile)
+		if err != nil {
+			configErr = "basic auth middleware: " + err.Error()
+		}
+		config.Htpasswd = htpasswd
+	}
+	if configErr == "" && len(config.Users) == 0 && config.Htpasswd == nil && config.Validator == nil {
+		configErr = "basic auth middleware: Users, HtpasswdFile, Htpasswd or Validator is required"
+	}
+
+	if config.Realm == "" {
+		config.Realm = "restricted"
+	}
+	if config.MaxFailures == 0 {
+		config.MaxFailures = 5
+	}
+	if config.FailureWindow <= 0 {
+		config.FailureWindow = 15 * time.Minute
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = KeyByIP
+	}
+
+	challenge := `Basic realm=` + strconv.Quote(config.Realm) + `, charset="UTF-8"`
+	throttle := &basicAuthThrottle{
+		max:      config.MaxFailures,
+		window:   config.FailureWindow,
+		failures: make(map[string]*basicAuthFailures),
+	}
+
+	return rtr.NewMiddleware().
+		SetName("Basic Auth").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if configErr != "" {
+					http.Error(w, configErr, http.StatusInternalServerError)
+					return
+				}
+
+				clientKey, err := config.KeyFunc(r)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusPreconditionRequired)
+					return
+				}
+
+				now := time.Now()
+				if wait := throttle.blockedFor(clientKey, now); wait > 0 {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+					return
+				}
+
+				username, password, ok := r.BasicAuth()
+				if !ok {
+					w.Header().Set("WWW-Authenticate", challenge)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				if username == "" || password == "" || !basicAuthVerify(r.Context(), config, username, password) {
+					throttle.fail(clientKey, now)
+					w.Header().Set("WWW-Authenticate", challenge)
+					http.Error(w, "Unauthorized", http.StatusUnauthorized)
+					return
+				}
+
+				throttle.reset(clientKey)
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), basicAuthUsernameKey{}, username)))
+			})
+		})
+}
+
+// GetBasicAuthUsername returns the username authenticated by
+// BasicAuthMiddleware, or "" if there is none.
+func GetBasicAuthUsername(ctx context.Context) string {
+	username, _ := ctx.Value(basicAuthUsernameKey{}).(string)
+	return username
+}
+
+// basicAuthVerify checks the credentials against every configured source.
+func basicAuthVerify(ctx context.Context, config BasicAuthConfig, username, password string) bool {
+	if expected, ok := config.Users[username]; ok {
+		// Compare hashes so the comparison time does not depend on the
+		// password length.
+		expectedHash := sha256.Sum256([]byte(expected))
+		submittedHash := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(expectedHash[:], submittedHash[:]) == 1 {
+			return true
+		}
+	}
+	if config.Htpasswd != nil && config.Htpasswd.Verify(username, password) {
+		return true
+	}
+	if config.Validator != nil && config.Validator(ctx, username, password) {
+		return true
+	}
+	return false
+}
+
+// basicAuthFailures counts the failed attempts of a client within the
+// current window.
+type basicAuthFailures struct {
+	count   int
+	expires time.Time
+}
+
+// basicAuthThrottle tracks failed attempts per client for
+// BasicAuthMiddleware.
+type basicAuthThrottle struct {
+	max       int
+	window    time.Duration
+	failures  map[string]*basicAuthFailures
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// blockedFor returns how long key must wait before trying again, or zero if
+// it may try now.
+func (t *basicAuthThrottle) blockedFor(key string, now time.Time) time.Duration {
+	if t.max < 0 {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[key]
+	if !ok || !now.Before(f.expires) || f.count < t.max {
+		return 0
+	}
+	return f.expires.Sub(now)
+}
+
+// fail records a failed attempt by key. Each failure extends the window, so
+// slow guessing does not escape the limit.
+func (t *basicAuthThrottle) fail(key string, now time.Time) {
+	if t.max < 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if now.Sub(t.lastSweep) >= t.window {
+		for k, f := range t.failures {
+			if !now.Before(f.expires) {
+				delete(t.failures, k)
+			}
+		}
+		t.lastSweep = now
+	}
+
+	f, ok := t.failures[key]
+	if !ok || !now.Before(f.expires) {
+		f = &basicAuthFailures{}
+		t.failures[key] = f
+	}
+	f.count++
+	f.expires = now.Add(t.window)
+}
+
+// reset forgets the failed attempts of key after a successful login.
+func (t *basicAuthThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
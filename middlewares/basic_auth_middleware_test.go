@@ -0,0 +1,165 @@
+package middlewares_test
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dracory/rtr/middlewares"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func basicAuthRequest(handler http.Handler, username, password string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func writeHtpasswd(t *testing.T) string {
+	t.Helper()
+
+	bcryptHash, _ := bcrypt.GenerateFromPassword([]byte("bcrypt-pass"), bcrypt.MinCost)
+	salt := []byte("0123456789abcdef")
+	argonHash := "$argon2id$v=19$m=1024,t=1,p=1$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" +
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("argon-pass"), salt, 1, 1024, 1, 32))
+	shaSum := sha1.Sum([]byte("sha-pass"))
+
+	content := strings.Join([]string{
+		"# users",
+		"alice:" + string(bcryptHash),
+		"bob:" + argonHash,
+		"carol:{SHA}" + base64.StdEncoding.EncodeToString(shaSum[:]),
+		"dave:$apr1$unsupported",
+	}, "\n")
+
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBasicAuthMiddleware_Sources(t *testing.T) {
+	var username string
+	handler := middlewares.BasicAuthMiddleware(middlewares.BasicAuthConfig{
+		Users:        map[string]string{"admin": "admin-pass"},
+		HtpasswdFile: writeHtpasswd(t),
+		Validator: func(ctx context.Context, u, p string) bool {
+			return u == "service" && p == "service-pass"
+		},
+		MaxFailures: -1,
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username = middlewares.GetBasicAuthUsername(r.Context())
+	}))
+
+	tests := []struct {
+		username string
+		password string
+		expected int
+	}{
+		{"admin", "admin-pass", http.StatusOK},
+		{"alice", "bcrypt-pass", http.StatusOK},
+		{"bob", "argon-pass", http.StatusOK},
+		{"carol", "sha-pass", http.StatusOK},
+		{"service", "service-pass", http.StatusOK},
+		{"admin", "wrong", http.StatusUnauthorized},
+		{"alice", "wrong", http.StatusUnauthorized},
+		{"bob", "wrong", http.StatusUnauthorized},
+		{"dave", "anything", http.StatusUnauthorized},
+		{"nobody", "admin-pass", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.username+"/"+tt.password, func(t *testing.T) {
+			username = ""
+			rr := basicAuthRequest(handler, tt.username, tt.password)
+			if rr.Code != tt.expected {
+				t.Fatalf("Expected status %d, got %d", tt.expected, rr.Code)
+			}
+			if tt.expected == http.StatusOK && username != tt.username {
+				t.Errorf("Expected username %q in context, got %q", tt.username, username)
+			}
+		})
+	}
+}
+
+func TestBasicAuthMiddleware_Realm(t *testing.T) {
+	handler := middlewares.BasicAuthMiddleware(middlewares.BasicAuthConfig{
+		Users: map[string]string{"admin": "admin-pass"},
+		Realm: "Admin Area",
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	rr := basicAuthRequest(handler, "", "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("WWW-Authenticate"); got != `Basic realm="Admin Area", charset="UTF-8"` {
+		t.Errorf("Unexpected WWW-Authenticate header %q", got)
+	}
+}
+
+func TestBasicAuthMiddleware_ThrottlesFailures(t *testing.T) {
+	handler := middlewares.BasicAuthMiddleware(middlewares.BasicAuthConfig{
+		Users:       map[string]string{"admin": "admin-pass"},
+		MaxFailures: 3,
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if rr := basicAuthRequest(handler, "admin", "wrong"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d", rr.Code)
+	}
+	if rr := basicAuthRequest(handler, "admin", "admin-pass"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected a successful login to reset failures, got %d", rr.Code)
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := basicAuthRequest(handler, "admin", "wrong"); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Attempt %d: expected status 401, got %d", i+1, rr.Code)
+		}
+	}
+
+	rr := basicAuthRequest(handler, "admin", "admin-pass")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 after too many failures, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.SetBasicAuth("admin", "admin-pass")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected other clients to be unaffected, got %d", rr.Code)
+	}
+}
+
+func TestBasicAuthMiddleware_ConfigErrors(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for name, config := range map[string]middlewares.BasicAuthConfig{
+		"no sources":   {},
+		"missing file": {HtpasswdFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := basicAuthRequest(middlewares.BasicAuthMiddleware(config).GetHandler()(ok), "a", "b")
+			if rr.Code != http.StatusInternalServerError {
+				t.Errorf("Expected status 500, got %d", rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,380 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/dracory/rtr"
+)
+
+// defaultBodyLimit is the body limit used when BodyLimitConfig.MaxBytes is
+// zero.
+const defaultBodyLimit = 10 << 20
+
+// BodyLimitConfig configures BodyLimitMiddleware. A negative limit anywhere
+// means unlimited.
+type BodyLimitConfig struct {
+	// MaxBytes is the default body limit. Optional; defaults to 10MB.
+	MaxBytes int64
+	// ContentTypeLimits overrides MaxBytes by media type, e.g.
+	// "application/json" or "image/*".
+	ContentTypeLimits map[string]int64
+	// RouteLimits overrides MaxBytes and ContentTypeLimits for requests whose
+	// matched route has the given name. BodyLimitsFromItems builds it from
+	// declarative configuration.
+	RouteLimits map[string]int64
+	// Multipart, if set, inspects multipart/form-data requests before they
+	// reach the handler.
+	Multipart *MultipartLimits
+}
+
+// MultipartLimits restricts the files of multipart/form-data requests.
+type MultipartLimits struct {
+	// MaxFiles is the maximum number of files. Optional; 0 means no limit.
+	MaxFiles int
+	// MaxFileSize is the maximum size of a single file in bytes. Optional;
+	// 0 means no limit.
+	MaxFileSize int64
+	// AllowedTypes lists the accepted file media types, e.g. "image/png" or
+	// "image/*". Optional; all types are accepted if empty.
+	AllowedTypes []string
+	// SniffContentType checks AllowedTypes against the type detected from
+	// the file contents (http.DetectContentType) instead of the
+	// client-supplied Content-Type of each part.
+	SniffContentType bool
+	// MaxMemory is the part of the form kept in memory, the rest is stored in
+	// temporary files. It applies both to the copy kept while the parts are
+	// checked and to the parsed form. Optional; defaults to 32MB.
+	MaxMemory int64
+}
+
+// BodyLimitMiddleware returns a middleware that limits the size of request
+// bodies. Requests announcing a larger Content-Length are rejected up front
+// with 413 Request Entity Too Large; other bodies are wrapped with
+// http.MaxBytesReader, so reading past the limit fails with
+// *http.MaxBytesError. Attach it to a group to give the group its own limit;
+// when nested, the smallest limit wins.
+//
+// With Multipart set, multipart/form-data bodies are parsed by the middleware
+// (populating r.MultipartForm for the handler) and rejected with 413 when
+// they carry too many or too large files, or 415 Unsupported Media Type for
+// disallowed file types. The parts are checked as they are read, so the
+// rest of the body is not read once a file is rejected.
+func BodyLimitMiddleware(config BodyLimitConfig) rtr.MiddlewareInterface {
+	if config.MaxBytes == 0 {
+		config.MaxBytes = defaultBodyLimit
+	}
+	if config.Multipart != nil {
+		multipartLimits := *config.Multipart
+		if multipartLimits.MaxMemory <= 0 {
+			multipartLimits.MaxMemory = 32 << 20
+		}
+		config.Multipart = &multipartLimits
+	}
+
+	return rtr.NewMiddleware().
+		SetName("Body Limit").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+				limit := bodyLimitFor(config, r, mediaType)
+				if limit >= 0 {
+					if r.ContentLength > limit {
+						bodyTooLarge(w)
+						return
+					}
+					if r.Body != nil && r.Body != http.NoBody {
+						r.Body = http.MaxBytesReader(w, r.Body, limit)
+					}
+				}
+
+				if config.Multipart != nil && mediaType == "multipart/form-data" {
+					if status := checkMultipart(r, config.Multipart); status != 0 {
+						if r.MultipartForm != nil {
+							_ = r.MultipartForm.RemoveAll()
+						}
+						if status == http.StatusRequestEntityTooLarge {
+							bodyTooLarge(w)
+							return
+						}
+						http.Error(w, http.StatusText(status), status)
+						return
+					}
+				}
+
+				next.ServeHTTP(w, r)
+			})
+		})
+}
+
+// BodyLimitsFromItems collects the MaxBodySize of declarative routes, by
+// route name, for BodyLimitConfig.RouteLimits. A group's MaxBodySize applies
+// to its routes that do not set their own. Limits are matched by route name,
+// so unnamed routes with a limit are skipped and logged.
+//
+// MaxBodySize has no effect on its own: pass the result to a
+// BodyLimitMiddleware on the router, e.g.
+//
+//	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{
+//		middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{
+//			RouteLimits: middlewares.BodyLimitsFromItems(domain.Items),
+//		}),
+//	})
+func BodyLimitsFromItems(items []rtr.ItemInterface) map[string]int64 {
+	limits := make(map[string]int64)
+
+	addRoute := func(route rtr.Route, groupLimit int64) {
+		limit := route.MaxBodySize
+		if limit == 0 {
+			limit = groupLimit
+		}
+		if limit == 0 {
+			return
+		}
+		if route.Name == "" {
+			slog.Default().Warn("body limit: MaxBodySize of unnamed route ignored",
+				slog.String("method", route.Method), slog.String("path", route.Path))
+			return
+		}
+		limits[route.Name] = limit
+	}
+	addGroup := func(group rtr.Group) {
+		for _, route := range group.Routes {
+			addRoute(route, group.MaxBodySize)
+		}
+	}
+
+	for _, item := range items {
+		switch v := item.(type) {
+		case rtr.Route:
+			addRoute(v, 0)
+		case *rtr.Route:
+			addRoute(*v, 0)
+		case rtr.Group:
+			addGroup(v)
+		case *rtr.Group:
+			addGroup(*v)
+		case rtr.Domain:
+			for name, limit := range BodyLimitsFromItems(v.Items) {
+				limits[name] = limit
+			}
+		case *rtr.Domain:
+			for name, limit := range BodyLimitsFromItems(v.Items) {
+				limits[name] = limit
+			}
+		}
+	}
+
+	return limits
+}
+
+// bodyLimitFor picks the limit for r: the route limit, then the content type
+// limit, then the default.
+func bodyLimitFor(config BodyLimitConfig, r *http.Request, mediaType string) int64 {
+	if len(config.RouteLimits) > 0 {
+		if route := rtr.GetRoute(r); route != nil {
+			if limit, ok := config.RouteLimits[route.GetName()]; ok {
+				return limit
+			}
+		}
+	}
+
+	if mediaType != "" && len(config.ContentTypeLimits) > 0 {
+		if limit, ok := config.ContentTypeLimits[mediaType]; ok {
+			return limit
+		}
+		major, _, _ := strings.Cut(mediaType, "/")
+		if limit, ok := config.ContentTypeLimits[major+"/*"]; ok {
+			return limit
+		}
+	}
+
+	return config.MaxBytes
+}
+
+// checkMultipart walks the parts of the multipart form of r, checking its
+// files against limits and stopping at the first one violating them, so an
+// oversized upload is rejected without reading the rest of the body. It
+// returns 0 if the form is acceptable, after parsing it into r.MultipartForm,
+// or the status to reply with.
+func checkMultipart(r *http.Request, limits *MultipartLimits) int {
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if params["boundary"] == "" {
+		return http.StatusBadRequest
+	}
+
+	// The parts read are kept so the form can be parsed for the handler.
+	// r.MultipartReader is not used, as it forbids ParseMultipartForm.
+	body := r.Body
+	spool := &multipartSpool{max: limits.MaxMemory}
+	defer spool.remove()
+	reader := multipart.NewReader(io.TeeReader(body, spool), params["boundary"])
+
+	files := 0
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return multipartErrorStatus(err, spool)
+		}
+		if part.FileName() == "" {
+			continue
+		}
+
+		files++
+		if limits.MaxFiles > 0 && files > limits.MaxFiles {
+			return http.StatusRequestEntityTooLarge
+		}
+		if status := checkMultipartFile(part, limits, spool); status != 0 {
+			return status
+		}
+	}
+
+	spooled, err := spool.reader()
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(spooled, body), body}
+	defer func() { r.Body = body }()
+
+	if err := r.ParseMultipartForm(limits.MaxMemory); err != nil {
+		return multipartErrorStatus(err, spool)
+	}
+	return 0
+}
+
+// checkMultipartFile checks the type and size of a file part. It returns 0 if
+// the file is acceptable, or the status to reply with.
+func checkMultipartFile(part *multipart.Part, limits *MultipartLimits, spool *multipartSpool) int {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return multipartErrorStatus(err, spool)
+	}
+
+	if len(limits.AllowedTypes) > 0 {
+		declared := part.Header.Get("Content-Type")
+		if limits.SniffContentType {
+			declared = http.DetectContentType(head[:n])
+		}
+		mediaType, _, _ := mime.ParseMediaType(declared)
+		if !mediaTypeAllowed(mediaType, limits.AllowedTypes) {
+			return http.StatusUnsupportedMediaType
+		}
+	}
+
+	if limits.MaxFileSize > 0 {
+		size := int64(n)
+		if size <= limits.MaxFileSize {
+			rest, err := io.CopyN(io.Discard, part, limits.MaxFileSize+1-size)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return multipartErrorStatus(err, spool)
+			}
+			size += rest
+		}
+		if size > limits.MaxFileSize {
+			return http.StatusRequestEntityTooLarge
+		}
+	}
+
+	return 0
+}
+
+// multipartErrorStatus returns the status to reply with when reading the
+// multipart form failed with err.
+func multipartErrorStatus(err error, spool *multipartSpool) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case spool.err != nil:
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// multipartSpool keeps a copy of the body read while checking a multipart
+// form, in memory up to max bytes and in a temporary file beyond.
+type multipartSpool struct {
+	max  int64
+	buf  bytes.Buffer
+	file *os.File
+	err  error
+}
+
+// Write implements io.Writer.
+func (s *multipartSpool) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.file == nil && int64(s.buf.Len()+len(p)) > s.max {
+		s.file, s.err = os.CreateTemp("", "multipart-")
+		if s.err != nil {
+			return 0, s.err
+		}
+		if _, s.err = s.file.Write(s.buf.Bytes()); s.err != nil {
+			return 0, s.err
+		}
+		s.buf = bytes.Buffer{}
+	}
+	if s.file == nil {
+		return s.buf.Write(p)
+	}
+	n, err := s.file.Write(p)
+	s.err = err
+	return n, err
+}
+
+// reader returns the spooled bytes.
+func (s *multipartSpool) reader() (io.Reader, error) {
+	if s.file == nil {
+		return &s.buf, nil
+	}
+	_, err := s.file.Seek(0, io.SeekStart)
+	return s.file, err
+}
+
+// remove deletes the temporary file, if any.
+func (s *multipartSpool) remove() {
+	if s.file != nil {
+		_ = s.file.Close()
+		_ = os.Remove(s.file.Name())
+	}
+}
+
+// mediaTypeAllowed reports whether mediaType matches one of allowed, where
+// "type/*" matches any subtype.
+func mediaTypeAllowed(mediaType string, allowed []string) bool {
+	if mediaType == "" {
+		return false
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mediaType || a == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// bodyTooLarge replies with 413 Request Entity Too Large and closes the
+// connection, since the rest of the body will not be read.
+func bodyTooLarge(w http.ResponseWriter) {
+	w.Header().Set("Connection", "close")
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
@@ -0,0 +1,246 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// readAllHandler reads the body and replies 200, or 413 if it is too large.
+var readAllHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	if _, err := io.ReadAll(r.Body); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+})
+
+func bodyLimitRequest(handler http.Handler, path, contentType string, body []byte, chunked bool) int {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	if chunked {
+		req.ContentLength = -1
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestBodyLimitMiddleware_Limits(t *testing.T) {
+	handler := middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{
+		MaxBytes: 10,
+		ContentTypeLimits: map[string]int64{
+			"application/json": 20,
+			"image/*":          -1,
+		},
+	}).GetHandler()(readAllHandler)
+
+	tests := []struct {
+		name        string
+		contentType string
+		size        int
+		chunked     bool
+		expected    int
+	}{
+		{"within default", "text/plain", 10, false, http.StatusOK},
+		{"over default", "text/plain", 11, false, http.StatusRequestEntityTooLarge},
+		{"over default without content length", "text/plain", 11, true, http.StatusRequestEntityTooLarge},
+		{"content type limit", "application/json; charset=utf-8", 20, false, http.StatusOK},
+		{"over content type limit", "application/json", 21, true, http.StatusRequestEntityTooLarge},
+		{"wildcard unlimited", "image/png", 1000, true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := bodyLimitRequest(handler, "/", tt.contentType, bytes.Repeat([]byte("a"), tt.size), tt.chunked)
+			if code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestBodyLimitMiddleware_GroupsAndDeclarativeRoutes(t *testing.T) {
+	items := []rtr.ItemInterface{
+		rtr.Route{Name: "upload", MaxBodySize: 100},
+		rtr.Group{
+			MaxBodySize: 50,
+			Routes: []rtr.Route{
+				{Name: "import"},
+				{Name: "bulk", MaxBodySize: -1},
+			},
+		},
+	}
+	limits := middlewares.BodyLimitsFromItems(items)
+	if limits["upload"] != 100 || limits["import"] != 50 || limits["bulk"] != -1 {
+		t.Fatalf("Unexpected limits %v", limits)
+	}
+
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{
+		MaxBytes:    10,
+		RouteLimits: limits,
+	})})
+	router.AddRoute(rtr.Post("/upload", readAllHandler.ServeHTTP).SetName("upload"))
+	router.AddRoute(rtr.Post("/other", readAllHandler.ServeHTTP).SetName("other"))
+	router.AddGroup(rtr.NewGroup().
+		SetPrefix("/small").
+		AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{MaxBytes: 5})}).
+		AddRoute(rtr.Post("/echo", readAllHandler.ServeHTTP)))
+
+	tests := []struct {
+		path     string
+		size     int
+		expected int
+	}{
+		{"/upload", 100, http.StatusOK},
+		{"/upload", 101, http.StatusRequestEntityTooLarge},
+		{"/other", 11, http.StatusRequestEntityTooLarge},
+		{"/small/echo", 5, http.StatusOK},
+		{"/small/echo", 6, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		code := bodyLimitRequest(router, tt.path, "text/plain", bytes.Repeat([]byte("a"), tt.size), true)
+		if code != tt.expected {
+			t.Errorf("%s with %d bytes: expected status %d, got %d", tt.path, tt.size, tt.expected, code)
+		}
+	}
+}
+
+// multipartBody builds a multipart form with one part per file.
+func multipartBody(t *testing.T, files map[string]string) (string, []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, contentType := range files {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", `form-data; name="files"; filename="`+name+`"`)
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content := "hello"
+		if strings.HasSuffix(name, ".png") {
+			content = "\x89PNG\r\n\x1a\n" + strings.Repeat("x", 20)
+		}
+		if strings.HasPrefix(name, "big") {
+			content = strings.Repeat("x", 200)
+		}
+		_, _ = part.Write([]byte(content))
+	}
+	_ = mw.Close()
+	return mw.FormDataContentType(), buf.Bytes()
+}
+
+// countingReader counts the bytes read from it.
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestBodyLimitMiddleware_MultipartStopsAtFirstRejectedFile(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, size := range []int{200, 4 << 20} {
+		part, err := mw.CreateFormFile("files", "file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = part.Write(bytes.Repeat([]byte("x"), size))
+	}
+	_ = mw.Close()
+
+	handlerCalled := false
+	handler := middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{
+		MaxBytes:  -1,
+		Multipart: &middlewares.MultipartLimits{MaxFileSize: 100},
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+	}))
+
+	body := &countingReader{Reader: bytes.NewReader(buf.Bytes())}
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge || handlerCalled {
+		t.Errorf("Expected 413 without calling the handler, got %d", rr.Code)
+	}
+	if body.n > 64<<10 {
+		t.Errorf("Expected the rest of the body not to be read, read %d of %d bytes", body.n, buf.Len())
+	}
+}
+
+func TestBodyLimitMiddleware_Multipart(t *testing.T) {
+	var filesSeen int
+	handler := middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{
+		MaxBytes: 10 << 10,
+		Multipart: &middlewares.MultipartLimits{
+			MaxFiles:     2,
+			MaxFileSize:  100,
+			AllowedTypes: []string{"image/*", "text/plain"},
+		},
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filesSeen = len(r.MultipartForm.File["files"])
+	}))
+
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected int
+	}{
+		{"allowed files", map[string]string{"a.png": "image/png", "b.txt": "text/plain"}, http.StatusOK},
+		{"too many files", map[string]string{"a.txt": "text/plain", "b.txt": "text/plain", "c.txt": "text/plain"}, http.StatusRequestEntityTooLarge},
+		{"file too large", map[string]string{"big.txt": "text/plain"}, http.StatusRequestEntityTooLarge},
+		{"disallowed type", map[string]string{"a.exe": "application/octet-stream"}, http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			contentType, body := multipartBody(t, tt.files)
+			if code := bodyLimitRequest(handler, "/", contentType, body, false); code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, code)
+			}
+		})
+	}
+	if filesSeen != 2 {
+		t.Errorf("Expected handler to see the parsed form, got %d files", filesSeen)
+	}
+
+	sniffing := middlewares.BodyLimitMiddleware(middlewares.BodyLimitConfig{
+		Multipart: &middlewares.MultipartLimits{AllowedTypes: []string{"image/png"}, SniffContentType: true},
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	contentType, body := multipartBody(t, map[string]string{"fake.txt": "image/png"})
+	if code := bodyLimitRequest(sniffing, "/", contentType, body, false); code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected sniffing to reject a mislabelled file, got %d", code)
+	}
+	contentType, body = multipartBody(t, map[string]string{"real.png": "application/octet-stream"})
+	if code := bodyLimitRequest(sniffing, "/", contentType, body, false); code != http.StatusOK {
+		t.Errorf("Expected sniffing to accept a real PNG, got %d", code)
+	}
+}
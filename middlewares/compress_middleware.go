@@ -9,12 +9,23 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/andybalholm/brotli"
 	"github.com/dracory/rtr"
+	"github.com/klauspost/compress/zstd"
 )
 
+// defaultCompressMinSize is the default CompressConfig.MinSize. Smaller
+// responses usually grow or gain nothing when compressed.
+const defaultCompressMinSize = 1024
+
+// zstdMaxWindowSize is the largest zstd window browsers must support for the
+// zstd content coding (RFC 9659).
+const zstdMaxWindowSize = 8 << 20
+
 // defaultCompressibleContentTypes lists the content types that are compressed
 // by default when the caller does not specify a custom list.
 var defaultCompressibleContentTypes = []string{
@@ -32,18 +43,59 @@ var defaultCompressibleContentTypes = []string{
 	"image/svg+xml",
 }
 
-// CompressMiddleware returns a middleware that compresses HTTP responses.
-// It supports gzip and deflate compression based on the client's Accept-Encoding header.
-// This is a stdlib-only reimplementation of chi's middleware.Compress.
+// CompressConfig configures a Compressor.
+type CompressConfig struct {
+	// Level is the compression level, from -2 (Huffman only) and 1 (fastest)
+	// to 9 (best), with -1 meaning each encoder's default. Optional; defaults
+	// to -1. It is mapped to the nearest brotli and zstd level.
+	Level int
+	// ContentTypes lists the content types to compress; "type/*" matches a
+	// whole type. Optional; defaults to common text types.
+	ContentTypes []string
+	// MinSize is the smallest response, in bytes, that is compressed. Up to
+	// MinSize bytes are buffered to decide, unless Content-Length is set.
+	// Optional; defaults to 1024. Negative compresses every response.
+	MinSize int
+}
+
+// CompressMiddleware returns a middleware that compresses HTTP responses of
+// at least 1KB with zstd, brotli, gzip or deflate, as negotiated with the
+// client's Accept-Encoding header. Types optionally restricts the content
+// types compressed, see CompressConfig.
+//
+// It panics if the level or a content type pattern is invalid.
 func CompressMiddleware(level int, types ...string) rtr.MiddlewareInterface {
-	compressor := newCompressor(level, types...)
-	return rtr.NewMiddleware().
-		SetName("Compress").
-		SetHandler(compressor.handler)
+	compressor, err := newCompressor(CompressConfig{Level: level, ContentTypes: types}, level)
+	if err != nil {
+		panic(err.Error())
+	}
+	return compressor.Middleware()
 }
 
-// encoderFunc wraps an io.Writer with a streaming compression algorithm.
-type encoderFunc func(w io.Writer, level int) io.Writer
+// CompressMiddlewareWithConfig returns a middleware that compresses HTTP
+// responses as configured. Use NewCompressor to register custom encoders.
+//
+// If config is invalid, every request is answered with 500 Internal Server
+// Error describing the problem.
+func CompressMiddlewareWithConfig(config CompressConfig) rtr.MiddlewareInterface {
+	compressor, err := NewCompressor(config)
+	if err != nil {
+		return rtr.NewMiddleware().
+			SetName("Compress").
+			SetHandler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				})
+			})
+	}
+	return compressor.Middleware()
+}
+
+// EncoderFunc wraps w with a streaming compression algorithm at level, see
+// CompressConfig.Level. The returned writer is closed at the end of the
+// response; if it also has a Reset(io.Writer) method, writers are pooled and
+// reused. It returns nil if level is not supported.
+type EncoderFunc func(w io.Writer, level int) io.Writer
 
 // ioResetterWriter is an io.Writer that can be Reset to a new underlying writer.
 type ioResetterWriter interface {
@@ -51,33 +103,45 @@ type ioResetterWriter interface {
 	Reset(w io.Writer)
 }
 
-// compressor holds the encoding configuration for the compress middleware.
-type compressor struct {
-	encoders           map[string]encoderFunc
+// Compressor compresses responses with the encoding negotiated with each
+// client. It is created by NewCompressor with the zstd, br, gzip and deflate
+// encodings; more can be registered with SetEncoder.
+type Compressor struct {
+	encoders           map[string]EncoderFunc
 	pooledEncoders     map[string]*sync.Pool
 	allowedTypes       map[string]struct{}
 	allowedWildcards   map[string]struct{}
 	encodingPrecedence []string
 	level              int
+	minSize            int
+}
+
+// NewCompressor creates a Compressor. It returns an error if the level or a
+// content type pattern is invalid.
+func NewCompressor(config CompressConfig) (*Compressor, error) {
+	level := config.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return newCompressor(config, level)
 }
 
-// newCompressor creates a new compressor with the given compression level and
-// optional content-type filter. If no types are given, a default list is used.
-// It panics if the compression level is invalid for gzip or deflate.
-func newCompressor(level int, types ...string) *compressor {
+// newCompressor creates a Compressor using level as is, so that
+// CompressMiddleware can still select level 0 (no compression).
+func newCompressor(config CompressConfig, level int) (*Compressor, error) {
 	// Validate the level early so we fail fast instead of producing nil encoders
 	// that would cause silent data corruption (Content-Encoding set without compression).
 	if err := validateCompressionLevel(level); err != nil {
-		panic(err.Error())
+		return nil, err
 	}
 
 	allowedTypes := make(map[string]struct{})
 	allowedWildcards := make(map[string]struct{})
 
-	if len(types) > 0 {
-		for _, t := range types {
+	if len(config.ContentTypes) > 0 {
+		for _, t := range config.ContentTypes {
 			if strings.Contains(strings.TrimSuffix(t, "/*"), "*") {
-				panic("middleware/compress: Unsupported content-type wildcard pattern. Only '/*' supported")
+				return nil, errors.New("middleware/compress: Unsupported content-type wildcard pattern. Only '/*' supported")
 			}
 			if before, ok := strings.CutSuffix(t, "/*"); ok {
 				allowedWildcards[before] = struct{}{}
@@ -91,23 +155,37 @@ func newCompressor(level int, types ...string) *compressor {
 		}
 	}
 
-	c := &compressor{
+	minSize := config.MinSize
+	if minSize == 0 {
+		minSize = defaultCompressMinSize
+	}
+
+	c := &Compressor{
 		level:            level,
-		encoders:         make(map[string]encoderFunc),
+		minSize:          minSize,
+		encoders:         make(map[string]EncoderFunc),
 		pooledEncoders:   make(map[string]*sync.Pool),
 		allowedTypes:     allowedTypes,
 		allowedWildcards: allowedWildcards,
 	}
 
-	// Add deflate first, then gzip, so gzip has higher precedence.
-	c.setEncoder("deflate", encoderDeflate)
-	c.setEncoder("gzip", encoderGzip)
+	// Each encoder takes precedence over the ones added before it, for
+	// clients accepting several with the same q-value.
+	c.SetEncoder("deflate", encoderDeflate)
+	c.SetEncoder("gzip", encoderGzip)
+	c.SetEncoder("br", encoderBrotli)
+	c.SetEncoder("zstd", encoderZstd)
 
-	return c
+	return c, nil
 }
 
-// setEncoder registers an encoder for a given encoding name.
-func (c *compressor) setEncoder(encoding string, fn encoderFunc) {
+// SetEncoder registers fn as the encoder for an encoding, e.g. "gzip",
+// replacing any encoder registered for it. The encoding gets the highest
+// server preference. Call it before the compressor handles requests.
+//
+// It panics if the encoding is empty, or if fn is nil or does not support
+// the compressor's level.
+func (c *Compressor) SetEncoder(encoding string, fn EncoderFunc) {
 	encoding = strings.ToLower(encoding)
 	if encoding == "" {
 		panic("the encoding can not be empty")
@@ -115,11 +193,14 @@ func (c *compressor) setEncoder(encoding string, fn encoderFunc) {
 	if fn == nil {
 		panic("attempted to set a nil encoder function")
 	}
+	encoder := fn(io.Discard, c.level)
+	if encoder == nil {
+		panic(fmt.Sprintf("the %s encoder does not support level %d", encoding, c.level))
+	}
 
 	delete(c.pooledEncoders, encoding)
 	delete(c.encoders, encoding)
 
-	encoder := fn(io.Discard, c.level)
 	if _, ok := encoder.(ioResetterWriter); ok {
 		pool := &sync.Pool{
 			New: func() interface{} {
@@ -142,49 +223,57 @@ func (c *compressor) setEncoder(encoding string, fn encoderFunc) {
 	c.encodingPrecedence = append([]string{encoding}, c.encodingPrecedence...)
 }
 
-// handler returns the middleware handler that compresses responses.
-func (c *compressor) handler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		encoder, encoding, cleanup := c.selectEncoder(r.Header, w)
+// Middleware returns the compressor as a named middleware.
+func (c *Compressor) Middleware() rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName("Compress").
+		SetHandler(c.Handler)
+}
 
+// Handler is the middleware handler that compresses responses.
+func (c *Compressor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		cw := &compressResponseWriter{
-			ResponseWriter:   w,
-			w:                w,
-			contentTypes:     c.allowedTypes,
-			contentWildcards: c.allowedWildcards,
-			encoding:         encoding,
-			compressible:     false,
-		}
-		if encoder != nil {
-			cw.w = encoder
+			ResponseWriter: w,
+			compressor:     c,
+			encoding:       c.negotiateEncoding(r.Header),
+			head:           r.Method == http.MethodHead,
+			status:         http.StatusOK,
 		}
-		defer cleanup()
 		defer cw.close()
 
 		next.ServeHTTP(cw, r)
 	})
 }
 
-// selectEncoder picks the best encoder based on the Accept-Encoding header.
-func (c *compressor) selectEncoder(h http.Header, w io.Writer) (io.Writer, string, func()) {
-	header := h.Get("Accept-Encoding")
-	accepted := strings.Split(strings.ToLower(header), ",")
+// negotiateEncoding picks the registered encoding with the highest q-value in
+// the Accept-Encoding header, breaking ties by server preference. It returns
+// "" if the client accepts none of them.
+func (c *Compressor) negotiateEncoding(h http.Header) string {
+	accepted := parseAcceptEncoding(strings.Join(h.Values("Accept-Encoding"), ","))
 
+	best, bestQ := "", 0.0
 	for _, name := range c.encodingPrecedence {
-		if matchAcceptEncoding(accepted, name) {
-			if pool, ok := c.pooledEncoders[name]; ok {
-				encoder := pool.Get().(ioResetterWriter)
-				cleanup := func() { pool.Put(encoder) }
-				encoder.Reset(w)
-				return encoder, name, cleanup
-			}
-			if fn, ok := c.encoders[name]; ok {
-				return fn(w, c.level), name, func() {}
-			}
+		q, ok := accepted[name]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
 		}
 	}
+	return best
+}
 
-	return nil, "", func() {}
+// newEncoder returns an encoder for encoding writing to w, and a function
+// releasing it once closed.
+func (c *Compressor) newEncoder(encoding string, w io.Writer) (io.Writer, func()) {
+	if pool, ok := c.pooledEncoders[encoding]; ok {
+		encoder := pool.Get().(ioResetterWriter)
+		encoder.Reset(w)
+		return encoder, func() { pool.Put(encoder) }
+	}
+	return c.encoders[encoding](w, c.level), func() {}
 }
 
 // validateCompressionLevel checks that the level is valid for both gzip and flate.
@@ -204,49 +293,58 @@ func validateCompressionLevel(level int) error {
 	}
 }
 
-// matchAcceptEncoding checks if the encoding is in the accepted list.
-// It properly parses Accept-Encoding tokens per RFC 7231:
-//   - Each token is split on ";" to separate the encoding from q-values.
-//   - Whitespace is trimmed.
-//   - q=0 means "explicitly not acceptable" and is rejected.
-//   - Substring matches are avoided (e.g. "xgzip" does not match "gzip").
-func matchAcceptEncoding(accepted []string, encoding string) bool {
-	for _, v := range accepted {
-		// Trim whitespace
-		v = strings.TrimSpace(v)
-		// Split on ";" to separate encoding from parameters like q-values
-		token, rest, _ := strings.Cut(v, ";")
+// parseAcceptEncoding parses an Accept-Encoding header into the q-value of
+// each coding per RFC 9110:
+//   - Tokens are lower-cased and trimmed; a missing q-value means 1.
+//   - q=0 means "explicitly not acceptable".
+//   - Invalid q-values make the coding unacceptable.
+//   - Exact tokens are compared, so "xgzip" does not match "gzip".
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, v := range strings.Split(strings.ToLower(header), ",") {
+		token, params, _ := strings.Cut(v, ";")
 		token = strings.TrimSpace(token)
-		if token != encoding {
+		if token == "" {
 			continue
 		}
-		// Check q-value: q=0 means "not acceptable"
-		if rest != "" {
-			rest = strings.TrimSpace(rest)
-			if strings.HasPrefix(rest, "q=") {
-				qStr := strings.TrimPrefix(rest, "q=")
-				qStr = strings.TrimSpace(qStr)
-				if qStr == "0" || strings.HasPrefix(qStr, "0.") {
-					// q=0 or q=0.x means explicitly not acceptable
-					return false
-				}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) != "q" {
+				continue
+			}
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
 			}
+			q = parsed
 		}
-		return true
+		accepted[token] = q
 	}
-	return false
+	return accepted
 }
 
 // compressResponseWriter wraps http.ResponseWriter to compress the response
-// when the content type is compressible.
+// when the content type is compressible. The status and headers are held
+// back until the writer decides whether to compress: when MinSize bytes have
+// been buffered, when the size is known from Content-Length, or when the
+// response is flushed or ends.
 type compressResponseWriter struct {
 	http.ResponseWriter
-	w                io.Writer
-	contentTypes     map[string]struct{}
-	contentWildcards map[string]struct{}
-	encoding         string
-	wroteHeader      bool
-	compressible     bool
+	compressor *Compressor
+	// encoding is the negotiated encoding, "" if the client accepts none.
+	encoding string
+	head     bool
+
+	status      int
+	wroteHeader bool
+	// decided is set once the headers have been sent.
+	decided bool
+	buf     []byte
+
+	encoder io.Writer
+	release func()
 }
 
 // isCompressible checks if the response's Content-Type is in the allowed list.
@@ -257,74 +355,157 @@ func (cw *compressResponseWriter) isCompressible() bool {
 	contentType, _, _ = strings.Cut(contentType, ";")
 	contentType = strings.ToLower(strings.TrimSpace(contentType))
 
-	if _, ok := cw.contentTypes[contentType]; ok {
+	if _, ok := cw.compressor.allowedTypes[contentType]; ok {
 		return true
 	}
 	if contentType, _, hadSlash := strings.Cut(contentType, "/"); hadSlash {
-		_, ok := cw.contentWildcards[contentType]
+		_, ok := cw.compressor.allowedWildcards[contentType]
 		return ok
 	}
 	return false
 }
 
-// WriteHeader sets the Content-Encoding header if the response is compressible.
+// varies reports whether the response depends on Accept-Encoding, i.e.
+// whether another client could get it compressed.
+func (cw *compressResponseWriter) varies() bool {
+	return cw.Header().Get("Content-Encoding") == "" && cw.isCompressible()
+}
+
+// eligible reports whether the response can be compressed for this client,
+// size aside.
+func (cw *compressResponseWriter) eligible() bool {
+	if cw.encoding == "" || cw.head || !cw.varies() {
+		return false
+	}
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	return cw.Header().Get("Content-Range") == ""
+}
+
+// WriteHeader records the status code. Informational responses are sent
+// immediately; others once the writer decides whether to compress.
 func (cw *compressResponseWriter) WriteHeader(code int) {
-	if cw.wroteHeader {
+	if cw.decided || cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code >= 100 && code < 200 {
 		cw.ResponseWriter.WriteHeader(code)
 		return
 	}
+	cw.status = code
 	cw.wroteHeader = true
-	defer cw.ResponseWriter.WriteHeader(code)
 
-	if cw.Header().Get("Content-Encoding") != "" {
+	if cw.needsSniff() {
+		// Wait for the body to sniff the content type, as net/http would.
 		return
 	}
-
-	if !cw.isCompressible() {
-		cw.compressible = false
+	if !cw.eligible() {
+		cw.decide(false)
 		return
 	}
-
-	if cw.encoding != "" {
-		cw.compressible = true
-		cw.Header().Set("Content-Encoding", cw.encoding)
-		cw.Header().Add("Vary", "Accept-Encoding")
-		cw.Header().Del("Content-Length")
+	if length, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64); err == nil {
+		cw.decide(length >= int64(cw.compressor.minSize))
 	}
 }
 
+// needsSniff reports whether the content type must be sniffed from the body
+// to decide on compression and Vary.
+func (cw *compressResponseWriter) needsSniff() bool {
+	h := cw.Header()
+	return !cw.head && h.Get("Content-Type") == "" && h.Get("Content-Encoding") == ""
+}
+
 // Write writes the data to the underlying writer, compressing if applicable.
 func (cw *compressResponseWriter) Write(p []byte) (int, error) {
 	if !cw.wroteHeader {
 		cw.WriteHeader(http.StatusOK)
 	}
-	return cw.writer().Write(p)
+	if cw.decided {
+		return cw.writer().Write(p)
+	}
+
+	if cw.Header().Get("Content-Type") == "" && len(p) > 0 {
+		cw.Header().Set("Content-Type", http.DetectContentType(p))
+		if !cw.eligible() {
+			cw.decide(false)
+			return cw.writer().Write(p)
+		}
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.compressor.minSize {
+		if err := cw.decideAndFlushBuffer(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the headers, setting up compression if compress is set and
+// the response is eligible.
+func (cw *compressResponseWriter) decide(compress bool) {
+	cw.decided = true
+	h := cw.Header()
+
+	if cw.varies() {
+		addVary(h, "Accept-Encoding")
+	}
+	if compress && cw.eligible() {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The compressed bytes differ, so a strong validator no longer holds.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.encoder, cw.release = cw.compressor.newEncoder(cw.encoding, cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// decideAndFlushBuffer decides whether to compress, then writes out the
+// buffered body.
+func (cw *compressResponseWriter) decideAndFlushBuffer(compress bool) error {
+	if !cw.decided {
+		cw.decide(compress)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	_, err := cw.writer().Write(cw.buf)
+	cw.buf = nil
+	return err
 }
 
 // writer returns the active writer (compressor or raw response writer).
 func (cw *compressResponseWriter) writer() io.Writer {
-	if cw.compressible {
-		return cw.w
+	if cw.encoder != nil {
+		return cw.encoder
 	}
 	return cw.ResponseWriter
 }
 
-// Flush flushes the underlying writers if they support flushing.
+// Flush sends the buffered body, compressed if eligible as a streamed
+// response's size is unknown, and flushes the underlying writers.
 func (cw *compressResponseWriter) Flush() {
-	if f, ok := cw.writer().(http.Flusher); ok {
-		f.Flush()
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
 	}
-	if f, ok := cw.writer().(compressFlusher); ok {
-		f.Flush()
-		if f, ok := cw.ResponseWriter.(http.Flusher); ok {
-			f.Flush()
-		}
+	_ = cw.decideAndFlushBuffer(true)
+
+	if f, ok := cw.encoder.(compressFlusher); ok {
+		_ = f.Flush()
 	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
 }
 
 // Hijack supports connection hijacking if the underlying writer supports it.
 func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
-	if hj, ok := cw.writer().(http.Hijacker); ok {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok {
 		return hj.Hijack()
 	}
 	return nil, nil, errors.New("compress: http.Hijacker is unavailable on the writer")
@@ -332,20 +513,31 @@ func (cw *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error)
 
 // Push supports HTTP/2 server push if the underlying writer supports it.
 func (cw *compressResponseWriter) Push(target string, opts *http.PushOptions) error {
-	if ps, ok := cw.writer().(http.Pusher); ok {
+	if ps, ok := cw.ResponseWriter.(http.Pusher); ok {
 		return ps.Push(target, opts)
 	}
 	return errors.New("compress: http.Pusher is unavailable on the writer")
 }
 
-// close closes the underlying encoder if it is a WriteCloser.
-// Returns nil if the writer is not a WriteCloser (e.g. the raw ResponseWriter
-// for non-compressible responses).
+// close ends the response: a body smaller than MinSize is sent uncompressed,
+// and the encoder, if any, is closed and released.
 func (cw *compressResponseWriter) close() error {
-	if c, ok := cw.writer().(io.WriteCloser); ok {
-		return c.Close()
+	if !cw.decided && (cw.wroteHeader || len(cw.buf) > 0) {
+		if err := cw.decideAndFlushBuffer(false); err != nil {
+			return err
+		}
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+
+	var err error
+	if c, ok := cw.encoder.(io.WriteCloser); ok {
+		err = c.Close()
 	}
-	return nil
+	cw.release()
+	cw.encoder = nil
+	return err
 }
 
 // Unwrap returns the underlying ResponseWriter for compatibility.
@@ -358,6 +550,20 @@ type compressFlusher interface {
 	Flush() error
 }
 
+// addVary adds value to the Vary header unless it is already listed or the
+// response varies on everything.
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
+
 // encoderGzip creates a gzip writer at the given level.
 func encoderGzip(w io.Writer, level int) io.Writer {
 	gw, err := gzip.NewWriterLevel(w, level)
@@ -375,3 +581,29 @@ func encoderDeflate(w io.Writer, level int) io.Writer {
 	}
 	return dw
 }
+
+// encoderBrotli creates a brotli writer. Levels 0 to 9 are used as brotli
+// qualities; other levels select brotli's default.
+func encoderBrotli(w io.Writer, level int) io.Writer {
+	if level < 0 || level > gzip.BestCompression {
+		level = brotli.DefaultCompression
+	}
+	return brotli.NewWriterLevel(w, level)
+}
+
+// encoderZstd creates a zstd writer at the zstd level closest to level, with
+// a window browsers accept.
+func encoderZstd(w io.Writer, level int) io.Writer {
+	speed := zstd.SpeedDefault
+	if level >= 0 {
+		speed = zstd.EncoderLevelFromZstd(level)
+	}
+	zw, err := zstd.NewWriter(w,
+		zstd.WithEncoderLevel(speed),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(zstdMaxWindowSize))
+	if err != nil {
+		return nil
+	}
+	return zw
+}
@@ -6,17 +6,23 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/andybalholm/brotli"
 	"github.com/dracory/rtr/middlewares"
+	"github.com/klauspost/compress/zstd"
 )
 
+// compressText is long enough to pass the default minimum size.
+var compressText = strings.Repeat("test response content ", 64)
+
 func TestCompressMiddleware(t *testing.T) {
 	t.Run("compresses response with gzip when accepted", func(t *testing.T) {
 		// Create a test handler that returns some content
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test response content"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		// Create middleware with default compression level
@@ -52,14 +58,14 @@ func TestCompressMiddleware(t *testing.T) {
 			t.Fatalf("Failed to read decompressed content: %v", err)
 		}
 
-		if string(content) != "test response content" {
+		if string(content) != compressText {
 			t.Errorf("Unexpected response content: %s", string(content))
 		}
 	})
 
 	t.Run("does not compress when client does not accept gzip", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			_, _ = w.Write([]byte("test response content"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		// Create middleware with default compression level
@@ -85,7 +91,7 @@ func TestCompressMiddleware(t *testing.T) {
 			t.Fatalf("Failed to read response: %v", err)
 		}
 
-		if string(content) != "test response content" {
+		if string(content) != compressText {
 			t.Errorf("Unexpected response content: %s", string(content))
 		}
 	})
@@ -94,7 +100,7 @@ func TestCompressMiddleware(t *testing.T) {
 		// Test handler that sets content type
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "application/json")
-			_, _ = w.Write([]byte(`{"message":"test"}`))
+			_, _ = w.Write([]byte(`{"message":"` + compressText + `"}`))
 		})
 
 		// Create middleware that only compresses JSON
@@ -118,7 +124,7 @@ func TestCompressMiddleware(t *testing.T) {
 		t.Run("does not compress when content type does not match", func(t *testing.T) {
 			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				w.Header().Set("Content-Type", "text/plain")
-				_, _ = w.Write([]byte("test"))
+				_, _ = w.Write([]byte(compressText))
 			})
 
 			req := httptest.NewRequest("GET", "http://example.com/api", nil)
@@ -139,7 +145,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("compresses with deflate when only deflate accepted", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test response content"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(flate.DefaultCompression)
@@ -161,7 +167,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("prefers gzip over deflate when both accepted", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test response content"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -183,7 +189,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("adds Vary header when compressing", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -230,7 +236,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("compresses default content types when no types specified", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/html")
-			_, _ = w.Write([]byte("<html><body>test</body></html>"))
+			_, _ = w.Write([]byte("<html><body>" + compressText + "</body></html>"))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -252,7 +258,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("supports wildcard content types", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/custom")
-			_, _ = w.Write([]byte("test"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression, "text/*")
@@ -274,7 +280,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("strips content type parameters before checking", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			_, _ = w.Write([]byte("test"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -296,7 +302,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("compresses with case-insensitive content type matching", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "TEXT/HTML")
-			_, _ = w.Write([]byte("<html>test</html>"))
+			_, _ = w.Write([]byte("<html>" + compressText + "</html>"))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -318,7 +324,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("trims whitespace in content type before matching", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/html ; charset=utf-8")
-			_, _ = w.Write([]byte("<html>test</html>"))
+			_, _ = w.Write([]byte("<html>" + compressText + "</html>"))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -340,7 +346,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("respects q=0 as explicit refusal", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test response content"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -363,7 +369,7 @@ func TestCompressMiddleware(t *testing.T) {
 	t.Run("does not match encoding substrings", func(t *testing.T) {
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test response content"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		middleware := middlewares.CompressMiddleware(gzip.DefaultCompression)
@@ -407,7 +413,7 @@ func TestCompressMiddleware(t *testing.T) {
 
 		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Content-Type", "text/plain")
-			_, _ = w.Write([]byte("test"))
+			_, _ = w.Write([]byte(compressText))
 		})
 
 		req := httptest.NewRequest("GET", "http://example.com/test", nil)
@@ -424,3 +430,228 @@ func TestCompressMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func compressRequest(handler http.Handler, method, acceptEncoding string) *http.Response {
+	req := httptest.NewRequest(method, "http://example.com/test", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w.Result()
+}
+
+func TestCompressor_Negotiation(t *testing.T) {
+	compressor, err := middlewares.NewCompressor(middlewares.CompressConfig{})
+	if err != nil {
+		t.Fatalf("NewCompressor failed: %v", err)
+	}
+	handler := compressor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(compressText))
+	}))
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip, deflate, br, zstd", "zstd"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=0.5, br;q=0.8", "br"},
+		{"br;q=0.2, gzip", "gzip"},
+		{"*;q=0.1, zstd;q=0, br;q=0", "gzip"},
+		{"gzip;q=abc", ""},
+		{"identity", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		resp := compressRequest(handler, http.MethodGet, tt.acceptEncoding)
+		if ce := resp.Header.Get("Content-Encoding"); ce != tt.expected {
+			t.Errorf("%q: expected Content-Encoding %q, got %q", tt.acceptEncoding, tt.expected, ce)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+			t.Errorf("%q: expected Vary: Accept-Encoding, got %q", tt.acceptEncoding, vary)
+		}
+	}
+
+	readers := map[string]func(r io.Reader) (io.Reader, error){
+		"br": func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) {
+			return zstd.NewReader(r)
+		},
+	}
+	for encoding, newReader := range readers {
+		resp := compressRequest(handler, http.MethodGet, encoding)
+		reader, err := newReader(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil || string(content) != compressText {
+			t.Errorf("%s: failed to decode response: %v", encoding, err)
+		}
+	}
+}
+
+func TestCompressor_MinSize(t *testing.T) {
+	compressor, err := middlewares.NewCompressor(middlewares.CompressConfig{MinSize: 100})
+	if err != nil {
+		t.Fatalf("NewCompressor failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		expected string
+	}{
+		{"small body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(strings.Repeat("a", 99)))
+		}, ""},
+		{"body reaching the threshold in several writes", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			for range 10 {
+				_, _ = w.Write([]byte(strings.Repeat("a", 10)))
+			}
+		}, "gzip"},
+		{"large Content-Length", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "200")
+			_, _ = w.Write([]byte(strings.Repeat("a", 200)))
+		}, "gzip"},
+		{"small body flushed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("event"))
+			http.NewResponseController(w).Flush()
+		}, "gzip"},
+		{"sniffed content type", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("<html><body>" + compressText + "</body></html>"))
+		}, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := compressRequest(compressor.Handler(tt.handler), http.MethodGet, "gzip")
+			if ce := resp.Header.Get("Content-Encoding"); ce != tt.expected {
+				t.Errorf("Expected Content-Encoding %q, got %q", tt.expected, ce)
+			}
+			if vary := resp.Header.Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("Expected Vary: Accept-Encoding, got %q", vary)
+			}
+			if tt.expected == "" {
+				body, _ := io.ReadAll(resp.Body)
+				if len(body) != 99 {
+					t.Errorf("Expected the uncompressed body, got %d bytes", len(body))
+				}
+			}
+		})
+	}
+
+	always := middlewares.CompressMiddlewareWithConfig(middlewares.CompressConfig{MinSize: -1}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("a"))
+	}))
+	if ce := compressRequest(always, http.MethodGet, "gzip").Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Expected a negative MinSize to compress every response, got %q", ce)
+	}
+}
+
+func TestCompressor_SkipsAndHeaders(t *testing.T) {
+	compressor, err := middlewares.NewCompressor(middlewares.CompressConfig{MinSize: -1})
+	if err != nil {
+		t.Fatalf("NewCompressor failed: %v", err)
+	}
+
+	t.Run("already encoded", func(t *testing.T) {
+		resp := compressRequest(compressor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = w.Write([]byte("pre-encoded"))
+		})), http.MethodGet, "gzip")
+		if ce := resp.Header.Get("Content-Encoding"); ce != "br" {
+			t.Errorf("Expected the handler's encoding to be kept, got %q", ce)
+		}
+		if vary := resp.Header.Get("Vary"); vary != "" {
+			t.Errorf("Expected no Vary for a pre-encoded response, got %q", vary)
+		}
+	})
+
+	t.Run("not compressible", func(t *testing.T) {
+		resp := compressRequest(compressor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write([]byte("png"))
+		})), http.MethodGet, "gzip")
+		if resp.Header.Get("Content-Encoding") != "" || resp.Header.Get("Vary") != "" {
+			t.Errorf("Expected an untouched response, got headers %v", resp.Header)
+		}
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		for _, tt := range []struct {
+			method string
+			status int
+		}{
+			{http.MethodHead, http.StatusOK},
+			{http.MethodGet, http.StatusNotModified},
+			{http.MethodGet, http.StatusNoContent},
+		} {
+			resp := compressRequest(compressor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Vary", "Origin, accept-encoding")
+				w.WriteHeader(tt.status)
+			})), tt.method, "gzip")
+			if resp.StatusCode != tt.status || resp.Header.Get("Content-Encoding") != "" {
+				t.Errorf("%s %d: expected an uncompressed response, got %d %v", tt.method, tt.status, resp.StatusCode, resp.Header)
+			}
+			if vary := resp.Header.Values("Vary"); len(vary) != 1 {
+				t.Errorf("%s %d: expected Vary not to be duplicated, got %q", tt.method, tt.status, vary)
+			}
+		}
+	})
+
+	t.Run("weakens ETag", func(t *testing.T) {
+		resp := compressRequest(compressor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"v1"`)
+			_, _ = w.Write([]byte(compressText))
+		})), http.MethodGet, "gzip")
+		if etag := resp.Header.Get("ETag"); etag != `W/"v1"` {
+			t.Errorf("Expected a weak ETag, got %q", etag)
+		}
+	})
+}
+
+func TestCompressor_SetEncoder(t *testing.T) {
+	compressor, err := middlewares.NewCompressor(middlewares.CompressConfig{})
+	if err != nil {
+		t.Fatalf("NewCompressor failed: %v", err)
+	}
+	compressor.SetEncoder("x-upper", func(w io.Writer, level int) io.Writer {
+		return upperWriter{w}
+	})
+	handler := compressor.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(compressText))
+	}))
+
+	resp := compressRequest(handler, http.MethodGet, "gzip, x-upper")
+	body, _ := io.ReadAll(resp.Body)
+	if ce := resp.Header.Get("Content-Encoding"); ce != "x-upper" || string(body) != strings.ToUpper(compressText) {
+		t.Errorf("Expected the custom encoder to be preferred, got %q", ce)
+	}
+	if ce := compressRequest(handler, http.MethodGet, "gzip").Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("Expected built-in encoders to remain, got %q", ce)
+	}
+
+	if _, err := middlewares.NewCompressor(middlewares.CompressConfig{Level: 42}); err == nil {
+		t.Error("Expected an error for an invalid level")
+	}
+}
+
+// upperWriter is a toy encoder upper-casing its input.
+type upperWriter struct{ w io.Writer }
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.w.Write([]byte(strings.ToUpper(string(p))))
+}
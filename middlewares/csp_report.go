@@ -0,0 +1,355 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// CSPReport is a single Content Security Policy violation report, normalized
+// from either the legacy application/csp-report format (report-uri) or the
+// Reporting API application/reports+json format (report-to).
+type CSPReport struct {
+	DocumentURI        string `json:"document_uri"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURI         string `json:"blocked_uri,omitempty"`
+	EffectiveDirective string `json:"effective_directive,omitempty"`
+	ViolatedDirective  string `json:"violated_directive,omitempty"`
+	OriginalPolicy     string `json:"original_policy,omitempty"`
+	Disposition        string `json:"disposition,omitempty"`
+	SourceFile         string `json:"source_file,omitempty"`
+	Sample             string `json:"sample,omitempty"`
+	StatusCode         int    `json:"status_code,omitempty"`
+	LineNumber         int    `json:"line_number,omitempty"`
+	ColumnNumber       int    `json:"column_number,omitempty"`
+
+	// UserAgent is the browser that sent the report.
+	UserAgent string `json:"user_agent,omitempty"`
+	// ReceivedAt is when the report reached the server.
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// CSPReportSink receives the CSP violation reports accepted by
+// CSPReportRoute.
+type CSPReportSink interface {
+	HandleCSPReport(ctx context.Context, report CSPReport)
+}
+
+// CSPReportSinkFunc adapts a function to a CSPReportSink.
+type CSPReportSinkFunc func(ctx context.Context, report CSPReport)
+
+// HandleCSPReport calls f(ctx, report).
+func (f CSPReportSinkFunc) HandleCSPReport(ctx context.Context, report CSPReport) {
+	f(ctx, report)
+}
+
+// NewLogCSPReportSink returns a sink that logs each report as a warning. A
+// nil logger uses slog.Default().
+func NewLogCSPReportSink(logger *slog.Logger) CSPReportSink {
+	return CSPReportSinkFunc(func(ctx context.Context, report CSPReport) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.WarnContext(ctx, "CSP violation",
+			"document_uri", report.DocumentURI,
+			"blocked_uri", report.BlockedURI,
+			"effective_directive", report.EffectiveDirective,
+			"disposition", report.Disposition,
+			"source_file", report.SourceFile,
+			"line_number", report.LineNumber,
+			"user_agent", report.UserAgent,
+		)
+	})
+}
+
+// CSPReportConfig configures CSPReportRoute.
+type CSPReportConfig struct {
+	// Path is the route path. Optional; defaults to "/csp-report". Set
+	// SecurityHeadersConfig.CSPReportURI to the same value so policies point
+	// at it.
+	Path string
+	// Sink receives accepted reports. Optional; defaults to
+	// NewLogCSPReportSink(nil).
+	Sink CSPReportSink
+	// MaxBodySize caps the request body in bytes. Optional; defaults to 64KB.
+	MaxBodySize int64
+	// DedupeWindow drops reports identical to one already accepted within the
+	// window (same document, directive, blocked URI and source location).
+	// Optional; defaults to one minute. A negative value disables deduping.
+	DedupeWindow time.Duration
+	// RateLimit is the number of reports per second accepted from a single
+	// client, with bursts of up to RateBurst. Optional; defaults to 1.
+	RateLimit float64
+	// RateBurst is the burst size for RateLimit. Optional; defaults to 10.
+	RateBurst int
+	// KeyFunc identifies the client for rate limiting. Optional; defaults to
+	// KeyByIP.
+	KeyFunc RateLimitKeyFunc
+}
+
+// CSPReportRoute returns a POST route that collects Content Security Policy
+// violation reports sent by browsers. It accepts both the legacy
+// application/csp-report payload produced by report-uri and the Reporting
+// API application/reports+json payload produced by report-to; Reporting API
+// entries other than csp-violation are ignored.
+//
+// Reports are normalized to CSPReport and handed to the configured sink.
+// Because a single misconfigured page can produce a flood of identical
+// reports, duplicates are dropped within DedupeWindow and each client is rate
+// limited. Accepted requests get 204 No Content, rate limited ones 429, and
+// malformed ones 400 or 415.
+func CSPReportRoute(config CSPReportConfig) rtr.RouteInterface {
+	if config.Path == "" {
+		config.Path = "/csp-report"
+	}
+	if config.Sink == nil {
+		config.Sink = NewLogCSPReportSink(nil)
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = 64 << 10
+	}
+	if config.DedupeWindow == 0 {
+		config.DedupeWindow = time.Minute
+	}
+	if config.RateLimit <= 0 {
+		config.RateLimit = 1
+	}
+	if config.RateBurst < 1 {
+		config.RateBurst = 10
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = KeyByIP
+	}
+
+	c := &cspReportCollector{
+		config: config,
+		limiter: &tokenBucketLimiter{
+			rate:    config.RateLimit,
+			burst:   float64(config.RateBurst),
+			keyFn:   config.KeyFunc,
+			buckets: make(map[string]*tokenBucket),
+		},
+		seen: make(map[string]time.Time),
+	}
+
+	return rtr.Post(config.Path, c.serveHTTP).SetName("CSP Report")
+}
+
+// cspReportCollector holds the state behind CSPReportRoute.
+type cspReportCollector struct {
+	config    CSPReportConfig
+	limiter   *tokenBucketLimiter
+	seen      map[string]time.Time
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+func (c *cspReportCollector) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	key, err := c.limiter.keyFn(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+		return
+	}
+
+	now := time.Now()
+	if ok, _, _ := c.limiter.take(key, now); !ok {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, c.config.MaxBodySize)
+	var reports []CSPReport
+	switch mediaType {
+	case "application/csp-report", "application/json":
+		reports, err = parseLegacyCSPReport(body)
+	case "application/reports+json":
+		reports, err = parseReportingAPICSPReports(body)
+	default:
+		http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+		return
+	}
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Invalid CSP report", http.StatusBadRequest)
+		return
+	}
+
+	for _, report := range reports {
+		if report.UserAgent == "" {
+			report.UserAgent = r.UserAgent()
+		}
+		report.ReceivedAt = now
+		if c.isDuplicate(report, now) {
+			continue
+		}
+		c.config.Sink.HandleCSPReport(r.Context(), report)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isDuplicate records report and reports whether an identical one was
+// already accepted within the dedupe window.
+func (c *cspReportCollector) isDuplicate(report CSPReport, now time.Time) bool {
+	if c.config.DedupeWindow < 0 {
+		return false
+	}
+
+	key := strings.Join([]string{
+		report.DocumentURI,
+		report.EffectiveDirective,
+		report.ViolatedDirective,
+		report.BlockedURI,
+		report.SourceFile,
+		strconv.Itoa(report.LineNumber),
+		strconv.Itoa(report.ColumnNumber),
+	}, "\x00")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if now.Sub(c.lastSweep) >= c.config.DedupeWindow {
+		for k, expires := range c.seen {
+			if !now.Before(expires) {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if expires, ok := c.seen[key]; ok && now.Before(expires) {
+		return true
+	}
+	c.seen[key] = now.Add(c.config.DedupeWindow)
+	return false
+}
+
+// legacyCSPReport is the body of an application/csp-report request.
+type legacyCSPReport struct {
+	CSPReport struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		EffectiveDirective string `json:"effective-directive"`
+		ViolatedDirective  string `json:"violated-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		ScriptSample       string `json:"script-sample"`
+		StatusCode         int    `json:"status-code"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is a single entry of an application/reports+json
+// request.
+type reportingAPIReport struct {
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	UserAgent string `json:"user_agent"`
+	Body      struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"sourceFile"`
+		Sample             string `json:"sample"`
+		StatusCode         int    `json:"statusCode"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+	} `json:"body"`
+}
+
+// parseLegacyCSPReport decodes a report-uri payload.
+func parseLegacyCSPReport(body io.Reader) ([]CSPReport, error) {
+	var payload legacyCSPReport
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	p := payload.CSPReport
+	if p.DocumentURI == "" {
+		return nil, errors.New("csp report: missing document-uri")
+	}
+
+	effective := p.EffectiveDirective
+	if effective == "" {
+		// Older browsers only send violated-directive, which holds the
+		// directive name followed by its sources.
+		effective, _, _ = strings.Cut(p.ViolatedDirective, " ")
+	}
+
+	return []CSPReport{{
+		DocumentURI:        p.DocumentURI,
+		Referrer:           p.Referrer,
+		BlockedURI:         p.BlockedURI,
+		EffectiveDirective: effective,
+		ViolatedDirective:  p.ViolatedDirective,
+		OriginalPolicy:     p.OriginalPolicy,
+		Disposition:        p.Disposition,
+		SourceFile:         p.SourceFile,
+		Sample:             p.ScriptSample,
+		StatusCode:         p.StatusCode,
+		LineNumber:         p.LineNumber,
+		ColumnNumber:       p.ColumnNumber,
+	}}, nil
+}
+
+// parseReportingAPICSPReports decodes a report-to payload, keeping only the
+// csp-violation entries.
+func parseReportingAPICSPReports(body io.Reader) ([]CSPReport, error) {
+	var payload []reportingAPIReport
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	reports := make([]CSPReport, 0, len(payload))
+	for _, p := range payload {
+		if p.Type != "csp-violation" {
+			continue
+		}
+		documentURI := p.Body.DocumentURL
+		if documentURI == "" {
+			documentURI = p.URL
+		}
+		reports = append(reports, CSPReport{
+			DocumentURI:        documentURI,
+			Referrer:           p.Body.Referrer,
+			BlockedURI:         p.Body.BlockedURL,
+			EffectiveDirective: p.Body.EffectiveDirective,
+			OriginalPolicy:     p.Body.OriginalPolicy,
+			Disposition:        p.Body.Disposition,
+			SourceFile:         p.Body.SourceFile,
+			Sample:             p.Body.Sample,
+			StatusCode:         p.Body.StatusCode,
+			LineNumber:         p.Body.LineNumber,
+			ColumnNumber:       p.Body.ColumnNumber,
+			UserAgent:          p.UserAgent,
+		})
+	}
+	return reports, nil
+}
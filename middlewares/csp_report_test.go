@@ -0,0 +1,160 @@
+package middlewares_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// collectingCSPSink records every report it receives.
+type collectingCSPSink struct {
+	mu      sync.Mutex
+	reports []middlewares.CSPReport
+}
+
+func (s *collectingCSPSink) HandleCSPReport(ctx context.Context, report middlewares.CSPReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = append(s.reports, report)
+}
+
+func postCSPReport(router rtr.RouterInterface, contentType, body string) int {
+	req := httptest.NewRequest(http.MethodPost, "/csp-report", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "test-agent")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+const legacyCSPReportBody = `{"csp-report":{"document-uri":"https://example.com/page","blocked-uri":"https://evil.example/x.js","violated-directive":"script-src 'self'","original-policy":"script-src 'self'","line-number":12}}`
+
+func TestCSPReportRoute_LegacyFormat(t *testing.T) {
+	sink := &collectingCSPSink{}
+	router := rtr.NewRouter()
+	router.AddRoute(middlewares.CSPReportRoute(middlewares.CSPReportConfig{Sink: sink}))
+
+	if code := postCSPReport(router, "application/csp-report", legacyCSPReportBody); code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", code)
+	}
+
+	if len(sink.reports) != 1 {
+		t.Fatalf("Expected 1 report, got %d", len(sink.reports))
+	}
+	report := sink.reports[0]
+	if report.DocumentURI != "https://example.com/page" || report.BlockedURI != "https://evil.example/x.js" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if report.EffectiveDirective != "script-src" {
+		t.Errorf("Expected effective directive derived from violated-directive, got %q", report.EffectiveDirective)
+	}
+	if report.LineNumber != 12 || report.UserAgent != "test-agent" || report.ReceivedAt.IsZero() {
+		t.Errorf("Unexpected report metadata %+v", report)
+	}
+}
+
+func TestCSPReportRoute_ReportingAPIFormat(t *testing.T) {
+	sink := &collectingCSPSink{}
+	router := rtr.NewRouter()
+	router.AddRoute(middlewares.CSPReportRoute(middlewares.CSPReportConfig{Sink: sink}))
+
+	body := `[
+		{"type":"csp-violation","url":"https://example.com/a","user_agent":"browser","body":{"documentURL":"https://example.com/a","blockedURL":"inline","effectiveDirective":"style-src-elem","disposition":"enforce","lineNumber":3}},
+		{"type":"deprecation","url":"https://example.com/a","body":{}}
+	]`
+	if code := postCSPReport(router, "application/reports+json", body); code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", code)
+	}
+
+	if len(sink.reports) != 1 {
+		t.Fatalf("Expected only the csp-violation entry, got %d reports", len(sink.reports))
+	}
+	report := sink.reports[0]
+	if report.EffectiveDirective != "style-src-elem" || report.BlockedURI != "inline" || report.UserAgent != "browser" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func TestCSPReportRoute_RejectsInvalidRequests(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(middlewares.CSPReportRoute(middlewares.CSPReportConfig{
+		Sink:        &collectingCSPSink{},
+		MaxBodySize: 256,
+		RateBurst:   100,
+	}))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    int
+	}{
+		{"unsupported content type", "text/plain", legacyCSPReportBody, http.StatusUnsupportedMediaType},
+		{"malformed json", "application/csp-report", "{", http.StatusBadRequest},
+		{"missing document uri", "application/csp-report", `{"csp-report":{}}`, http.StatusBadRequest},
+		{"too large", "application/csp-report", `{"csp-report":{"document-uri":"` + strings.Repeat("a", 300) + `"}}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := postCSPReport(router, tt.contentType, tt.body); code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestCSPReportRoute_DedupeAndRateLimit(t *testing.T) {
+	sink := &collectingCSPSink{}
+	router := rtr.NewRouter()
+	router.AddRoute(middlewares.CSPReportRoute(middlewares.CSPReportConfig{
+		Sink:      sink,
+		RateLimit: 0.001,
+		RateBurst: 3,
+	}))
+
+	for i := 0; i < 3; i++ {
+		if code := postCSPReport(router, "application/csp-report", legacyCSPReportBody); code != http.StatusNoContent {
+			t.Fatalf("Request %d: expected status 204, got %d", i+1, code)
+		}
+	}
+	if len(sink.reports) != 1 {
+		t.Errorf("Expected duplicates to be dropped, got %d reports", len(sink.reports))
+	}
+
+	if code := postCSPReport(router, "application/csp-report", legacyCSPReportBody); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 once the burst is used, got %d", code)
+	}
+}
+
+func TestSecurityHeadersMiddleware_CSPReportURI(t *testing.T) {
+	config := &middlewares.SecurityHeadersConfig{
+		CSP: &middlewares.CSPConfig{
+			Enabled:    true,
+			DefaultSrc: []string{"'self'"},
+			ReportTo:   "csp",
+		},
+		CSPReportURI: "/csp-report",
+	}
+
+	rr := httptest.NewRecorder()
+	middlewares.NewSecurityHeadersMiddleware(config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	expected := "default-src 'self'; report-uri /csp-report; report-to csp"
+	if got := rr.Header().Get("Content-Security-Policy"); got != expected {
+		t.Errorf("Expected CSP %q, got %q", expected, got)
+	}
+	if got := rr.Header().Get("Reporting-Endpoints"); got != `csp="/csp-report"` {
+		t.Errorf("Unexpected Reporting-Endpoints header %q", got)
+	}
+	if config.CSP.ReportURI != "" {
+		t.Errorf("Expected config to be left untouched, got %q", config.CSP.ReportURI)
+	}
+}
@@ -0,0 +1,341 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// CSRFMode selects how CSRFMiddleware verifies unsafe requests.
+type CSRFMode string
+
+const (
+	// CSRFModeSynchronizer keeps a random secret in an HttpOnly cookie and
+	// hands out per-request masked tokens derived from it. Forms and scripts
+	// must echo a token in the form field or header. This is the default.
+	CSRFModeSynchronizer CSRFMode = "synchronizer"
+	// CSRFModeDoubleSubmit stores the token in a cookie readable by scripts;
+	// the same value must be echoed in the form field or header. Use it for
+	// single-page apps that cannot render tokens server side.
+	CSRFModeDoubleSubmit CSRFMode = "double-submit"
+	// CSRFModeOriginOnly relies solely on the Sec-Fetch-Site and Origin
+	// headers, without tokens or cookies.
+	CSRFModeOriginOnly CSRFMode = "origin-only"
+)
+
+// Errors passed to CSRFConfig.OnFailure.
+var (
+	ErrCSRFOriginMismatch = errors.New("csrf: cross-origin request")
+	ErrCSRFTokenMissing   = errors.New("csrf: token missing")
+	ErrCSRFTokenInvalid   = errors.New("csrf: token invalid")
+)
+
+// csrfTokenLength is the length in bytes of the CSRF secret.
+const csrfTokenLength = 32
+
+// csrfTokenKey is the context key used to store the CSRF token.
+type csrfTokenKey struct{}
+
+// csrfFieldKey is the context key used to store the configured form field
+// name for CSRFTemplateField.
+type csrfFieldKey struct{}
+
+// CSRFConfig configures CSRFMiddleware. All fields are optional.
+type CSRFConfig struct {
+	// Mode selects the verification strategy. Defaults to
+	// CSRFModeSynchronizer. The Sec-Fetch-Site/Origin check runs in every
+	// mode unless DisableOriginCheck is set.
+	Mode CSRFMode
+
+	// CookieName defaults to "_csrf". Consider the "__Host-" prefix on HTTPS
+	// sites to stop subdomains from overwriting the cookie.
+	CookieName string
+	// CookiePath defaults to "/".
+	CookiePath   string
+	CookieDomain string
+	// CookieSecure should be true on HTTPS sites.
+	CookieSecure bool
+	// CookieSameSite defaults to http.SameSiteLaxMode.
+	CookieSameSite http.SameSite
+	// CookieMaxAge defaults to 12 hours.
+	CookieMaxAge time.Duration
+
+	// FieldName is the form field carrying the token. Defaults to
+	// "csrf_token".
+	FieldName string
+	// HeaderName is the header carrying the token. Defaults to
+	// "X-CSRF-Token".
+	HeaderName string
+
+	// TrustedOrigins are origins (e.g. "https://app.example.com") allowed to
+	// send cross-origin unsafe requests.
+	TrustedOrigins []string
+	// DisableOriginCheck turns off the Sec-Fetch-Site/Origin check.
+	DisableOriginCheck bool
+
+	// ExemptPaths bypass verification. Patterns follow
+	// JailBotsConfig.ExcludePaths: "/webhooks*" is a prefix match, "/api"
+	// matches "/api" and "/api/...".
+	ExemptPaths []string
+	// ExemptRoutes lists route names (see rtr.RouteInterface.SetName) that
+	// bypass verification. To protect only some groups, attach the middleware
+	// to those groups instead of the router.
+	ExemptRoutes []string
+
+	// OnFailure is called when a request is rejected, with one of the ErrCSRF*
+	// errors. Defaults to a 403 Forbidden plain-text response.
+	OnFailure func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// CSRFMiddleware returns a middleware protecting unsafe requests (anything
+// other than GET, HEAD, OPTIONS and TRACE) against cross-site request forgery.
+//
+// Every request gets a token in its context, which templates read with
+// CSRFToken or CSRFTemplateField. Unsafe requests must:
+//  1. pass the Sec-Fetch-Site/Origin check: same-origin requests and
+//     TrustedOrigins pass, other cross-site requests are rejected, and
+//     requests without either header fall through to the token check;
+//  2. in the token modes, echo a valid token in HeaderName or FieldName.
+func CSRFMiddleware(config CSRFConfig) rtr.MiddlewareInterface {
+	c := newCSRF(config)
+	return rtr.NewMiddleware().
+		SetName("CSRF").
+		SetHandler(c.handler)
+}
+
+// CSRFToken returns the CSRF token for the request, to be embedded in forms or
+// sent by scripts in the configured header. It returns an empty string if
+// CSRFMiddleware did not run or runs in CSRFModeOriginOnly.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// CSRFTemplateField returns a hidden input carrying the CSRF token, ready to be
+// placed inside a form in an html/template.
+func CSRFTemplateField(r *http.Request) template.HTML {
+	token := CSRFToken(r)
+	if token == "" {
+		return ""
+	}
+	field, _ := r.Context().Value(csrfFieldKey{}).(string)
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(field) + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
+
+// csrf holds the normalized CSRFMiddleware configuration.
+type csrf struct {
+	config         CSRFConfig
+	trustedOrigins []string
+}
+
+// newCSRF applies the configuration defaults.
+func newCSRF(config CSRFConfig) *csrf {
+	if config.Mode == "" {
+		config.Mode = CSRFModeSynchronizer
+	}
+	if config.CookieName == "" {
+		config.CookieName = "_csrf"
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.CookieSameSite == 0 {
+		config.CookieSameSite = http.SameSiteLaxMode
+	}
+	if config.CookieMaxAge <= 0 {
+		config.CookieMaxAge = 12 * time.Hour
+	}
+	if config.FieldName == "" {
+		config.FieldName = "csrf_token"
+	}
+	if config.HeaderName == "" {
+		config.HeaderName = "X-CSRF-Token"
+	}
+	if config.OnFailure == nil {
+		config.OnFailure = func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+		}
+	}
+
+	trusted := make([]string, 0, len(config.TrustedOrigins))
+	for _, origin := range config.TrustedOrigins {
+		trusted = append(trusted, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+
+	return &csrf{config: config, trustedOrigins: trusted}
+}
+
+// handler returns the middleware handler enforcing CSRF protection.
+func (c *csrf) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var secret []byte
+		if c.config.Mode != CSRFModeOriginOnly {
+			secret = c.secret(w, r)
+			ctx := context.WithValue(r.Context(), csrfTokenKey{}, c.token(secret))
+			ctx = context.WithValue(ctx, csrfFieldKey{}, c.config.FieldName)
+			r = r.WithContext(ctx)
+		}
+
+		if isSafeMethod(r.Method) || c.isExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.config.DisableOriginCheck && !c.originAllowed(r) {
+			c.config.OnFailure(w, r, ErrCSRFOriginMismatch)
+			return
+		}
+
+		if c.config.Mode != CSRFModeOriginOnly {
+			if err := c.verifyToken(r, secret); err != nil {
+				c.config.OnFailure(w, r, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// secret returns the secret stored in the CSRF cookie, issuing a new cookie if
+// it is missing or malformed.
+func (c *csrf) secret(w http.ResponseWriter, r *http.Request) []byte {
+	if cookie, err := r.Cookie(c.config.CookieName); err == nil {
+		if secret, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil && len(secret) == csrfTokenLength {
+			return secret
+		}
+	}
+
+	secret := make([]byte, csrfTokenLength)
+	_, _ = rand.Read(secret)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.config.CookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(secret),
+		Path:     c.config.CookiePath,
+		Domain:   c.config.CookieDomain,
+		MaxAge:   int(c.config.CookieMaxAge.Seconds()),
+		Secure:   c.config.CookieSecure,
+		HttpOnly: c.config.Mode == CSRFModeSynchronizer,
+		SameSite: c.config.CookieSameSite,
+	})
+	w.Header().Add("Vary", "Cookie")
+
+	return secret
+}
+
+// token returns the token handed to templates. Synchronizer tokens are masked
+// with a fresh one-time pad on every request, so the token in a compressed
+// response never repeats (BREACH); double-submit tokens equal the cookie.
+func (c *csrf) token(secret []byte) string {
+	if c.config.Mode == CSRFModeDoubleSubmit {
+		return base64.RawURLEncoding.EncodeToString(secret)
+	}
+
+	pad := make([]byte, csrfTokenLength)
+	_, _ = rand.Read(pad)
+
+	masked := make([]byte, 0, 2*csrfTokenLength)
+	masked = append(masked, pad...)
+	for i := range secret {
+		masked = append(masked, secret[i]^pad[i])
+	}
+	return base64.RawURLEncoding.EncodeToString(masked)
+}
+
+// verifyToken checks the submitted token against the cookie secret.
+func (c *csrf) verifyToken(r *http.Request, secret []byte) error {
+	submitted := r.Header.Get(c.config.HeaderName)
+	if submitted == "" {
+		submitted = r.PostFormValue(c.config.FieldName)
+	}
+	if submitted == "" {
+		return ErrCSRFTokenMissing
+	}
+
+	// A cookie issued on this very request cannot have been echoed back.
+	if _, err := r.Cookie(c.config.CookieName); err != nil {
+		return ErrCSRFTokenInvalid
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(submitted)
+	if err != nil {
+		return ErrCSRFTokenInvalid
+	}
+
+	if c.config.Mode == CSRFModeSynchronizer {
+		if len(decoded) != 2*csrfTokenLength {
+			return ErrCSRFTokenInvalid
+		}
+		pad, masked := decoded[:csrfTokenLength], decoded[csrfTokenLength:]
+		for i := range masked {
+			masked[i] ^= pad[i]
+		}
+		decoded = masked
+	}
+
+	if subtle.ConstantTimeCompare(decoded, secret) != 1 {
+		return ErrCSRFTokenInvalid
+	}
+	return nil
+}
+
+// originAllowed applies the Fetch Metadata and Origin checks. Requests that
+// carry neither header (older browsers, non-browser clients) are allowed and
+// left to the token check.
+func (c *csrf) originAllowed(r *http.Request) bool {
+	origin := strings.ToLower(r.Header.Get("Origin"))
+
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return origin != "" && slices.Contains(c.trustedOrigins, origin)
+	}
+
+	if origin == "" {
+		return true
+	}
+	if slices.Contains(c.trustedOrigins, origin) {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// isExempt reports whether the request bypasses verification.
+func (c *csrf) isExempt(r *http.Request) bool {
+	if pathMatchesAny(r.URL.Path, c.config.ExemptPaths) {
+		return true
+	}
+	if len(c.config.ExemptRoutes) > 0 {
+		if route := rtr.GetRoute(r); route != nil && slices.Contains(c.config.ExemptRoutes, route.GetName()) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSafeMethod reports whether method is defined as safe by RFC 9110.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
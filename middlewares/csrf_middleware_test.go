@@ -0,0 +1,221 @@
+package middlewares_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// csrfFetch performs a GET through h and returns the issued cookie and the
+// token exposed to the handler.
+func csrfFetch(t *testing.T, h http.Handler) (*http.Cookie, string) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "http://example.com/form", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	token := w.Header().Get("X-Test-Token")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("Expected one cookie, got %d", len(cookies))
+	}
+	return cookies[0], token
+}
+
+func newCSRFHandler(config middlewares.CSRFConfig) http.Handler {
+	return middlewares.CSRFMiddleware(config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test-Token", middlewares.CSRFToken(r))
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestCSRFMiddleware_Synchronizer(t *testing.T) {
+	h := newCSRFHandler(middlewares.CSRFConfig{})
+	cookie, token := csrfFetch(t, h)
+
+	if !cookie.HttpOnly {
+		t.Error("Expected synchronizer cookie to be HttpOnly")
+	}
+	if token == "" || token == cookie.Value {
+		t.Fatalf("Expected masked token different from the cookie, got %q", token)
+	}
+
+	t.Run("accepts token in form field", func(t *testing.T) {
+		form := url.Values{"csrf_token": {token}}
+		req := httptest.NewRequest("POST", "http://example.com/form", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("accepts a fresh token from a later request", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/form", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		second := w.Header().Get("X-Test-Token")
+		if second == token {
+			t.Fatal("Expected a differently masked token per request")
+		}
+
+		req = httptest.NewRequest("POST", "http://example.com/form", nil)
+		req.Header.Set("X-CSRF-Token", second)
+		req.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("rejects missing token", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "http://example.com/form", nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("rejects token for another cookie", func(t *testing.T) {
+		other, _ := csrfFetch(t, h)
+		req := httptest.NewRequest("POST", "http://example.com/form", nil)
+		req.Header.Set("X-CSRF-Token", token)
+		req.AddCookie(other)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+}
+
+func TestCSRFMiddleware_DoubleSubmit(t *testing.T) {
+	h := newCSRFHandler(middlewares.CSRFConfig{Mode: middlewares.CSRFModeDoubleSubmit})
+	cookie, token := csrfFetch(t, h)
+
+	if cookie.HttpOnly {
+		t.Error("Expected double-submit cookie to be readable by scripts")
+	}
+	if token != cookie.Value {
+		t.Fatalf("Expected token to equal cookie value")
+	}
+
+	req := httptest.NewRequest("POST", "http://example.com/form", nil)
+	req.Header.Set("X-CSRF-Token", cookie.Value)
+	req.AddCookie(cookie)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	req = httptest.NewRequest("POST", "http://example.com/form", nil)
+	req.Header.Set("X-CSRF-Token", "forged")
+	req.AddCookie(cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestCSRFMiddleware_OriginChecks(t *testing.T) {
+	var failure error
+	h := newCSRFHandler(middlewares.CSRFConfig{
+		Mode:           middlewares.CSRFModeOriginOnly,
+		TrustedOrigins: []string{"https://partner.example.org"},
+		OnFailure: func(w http.ResponseWriter, r *http.Request, err error) {
+			failure = err
+			w.WriteHeader(http.StatusTeapot)
+		},
+	})
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		expected int
+	}{
+		{"same-origin fetch metadata", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+		{"cross-site fetch metadata", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://evil.example"}, http.StatusTeapot},
+		{"cross-site trusted origin", map[string]string{"Sec-Fetch-Site": "cross-site", "Origin": "https://partner.example.org"}, http.StatusOK},
+		{"matching origin", map[string]string{"Origin": "https://example.com"}, http.StatusOK},
+		{"mismatching origin", map[string]string{"Origin": "https://evil.example"}, http.StatusTeapot},
+		{"no headers", map[string]string{}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure = nil
+			req := httptest.NewRequest("POST", "http://example.com/form", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+			if tt.expected == http.StatusTeapot && !errors.Is(failure, middlewares.ErrCSRFOriginMismatch) {
+				t.Errorf("Expected ErrCSRFOriginMismatch, got %v", failure)
+			}
+		})
+	}
+}
+
+func TestCSRFMiddleware_Exemptions(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{
+		middlewares.CSRFMiddleware(middlewares.CSRFConfig{
+			ExemptPaths:  []string{"/webhooks*"},
+			ExemptRoutes: []string{"api-login"},
+		}),
+	})
+	router.AddRoute(rtr.Post("/webhooks/stripe", ok))
+	router.AddRoute(rtr.Post("/api/login", ok).SetName("api-login"))
+	router.AddRoute(rtr.Post("/profile", ok).SetName("profile"))
+
+	for path, expected := range map[string]int{
+		"/webhooks/stripe": http.StatusOK,
+		"/api/login":       http.StatusOK,
+		"/profile":         http.StatusForbidden,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		if w.Code != expected {
+			t.Errorf("Expected status %d for %s, got %d", expected, path, w.Code)
+		}
+	}
+}
+
+func TestCSRFTemplateField(t *testing.T) {
+	var field string
+	h := middlewares.CSRFMiddleware(middlewares.CSRFConfig{FieldName: "_token"}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		field = string(middlewares.CSRFTemplateField(r))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	if !strings.HasPrefix(field, `<input type="hidden" name="_token" value="`) {
+		t.Errorf("Unexpected template field %q", field)
+	}
+
+	if got := middlewares.CSRFTemplateField(httptest.NewRequest("GET", "/", nil)); got != "" {
+		t.Errorf("Expected empty field without middleware, got %q", got)
+	}
+}
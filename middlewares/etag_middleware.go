@@ -0,0 +1,167 @@
+package middlewares
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// ETagConfig configures ETagMiddleware.
+type ETagConfig struct {
+	// Weak makes the computed ETags weak (W/"..."), for responses that are
+	// semantically but not byte-for-byte equivalent, e.g. when compressed
+	// by a proxy.
+	Weak bool
+
+	// MaxBodySize is the largest body hashed. Larger responses are sent
+	// without an ETag. Optional; defaults to 1MB, negative means no limit.
+	MaxBodySize int64
+}
+
+// ETagMiddleware returns a middleware adding an ETag to 200 OK responses to
+// GET and HEAD requests, computed by hashing the body, and answering
+// 304 Not Modified with an empty body when the request's If-None-Match or
+// If-Modified-Since header shows the client's copy is current.
+//
+// The response is buffered to hash it, which suits the string-returning
+// handlers (HTMLHandler, JSONHandler, ...) producing their whole body at
+// once. A handler flushing the response streams it without an ETag.
+//
+// Handlers can declare their own validators with rtr.CheckNotModified and
+// return early if the client's copy is current; the middleware keeps an
+// ETag set by the handler rather than hashing the body. As HEAD responses
+// have no body to hash, they only get a handler-declared ETag.
+func ETagMiddleware(config ETagConfig) rtr.MiddlewareInterface {
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = 1 << 20
+	}
+
+	return rtr.NewMiddleware().
+		SetName("ETag").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				ew := &etagResponseWriter{ResponseWriter: w, maxSize: config.MaxBodySize}
+				next.ServeHTTP(ew, r)
+				ew.finish(r, config.Weak)
+			})
+		})
+}
+
+// etagResponseWriter buffers a response to compute its ETag, falling back
+// to streaming once the body outgrows maxSize, or is flushed or hijacked.
+type etagResponseWriter struct {
+	http.ResponseWriter
+	status  int
+	body    []byte
+	maxSize int64
+	// streaming is set once the response is passed through unbuffered.
+	streaming bool
+}
+
+func (ew *etagResponseWriter) WriteHeader(code int) {
+	if ew.streaming {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if ew.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		ew.ResponseWriter.WriteHeader(code)
+		return
+	}
+	ew.status = code
+	if code != http.StatusOK {
+		ew.stream()
+	}
+}
+
+func (ew *etagResponseWriter) Write(b []byte) (int, error) {
+	if ew.status == 0 {
+		ew.WriteHeader(http.StatusOK)
+	}
+	if !ew.streaming && ew.maxSize >= 0 && int64(len(ew.body)+len(b)) > ew.maxSize {
+		ew.stream()
+	}
+	if ew.streaming {
+		return ew.ResponseWriter.Write(b)
+	}
+	ew.body = append(ew.body, b...)
+	return len(b), nil
+}
+
+// stream sends the response so far and passes the rest through.
+func (ew *etagResponseWriter) stream() {
+	if ew.streaming {
+		return
+	}
+	ew.streaming = true
+	if ew.status == 0 {
+		return
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+	if len(ew.body) > 0 {
+		_, _ = ew.ResponseWriter.Write(ew.body)
+	}
+	ew.body = nil
+}
+
+// finish validates and sends a buffered response.
+func (ew *etagResponseWriter) finish(r *http.Request, weak bool) {
+	if ew.streaming {
+		return
+	}
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+
+	h := ew.Header()
+	etag := h.Get("ETag")
+	if etag == "" && r.Method != http.MethodHead {
+		sum := sha256.Sum256(ew.body)
+		etag = `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+		if weak {
+			etag = "W/" + etag
+		}
+	}
+	lastModified, _ := time.Parse(http.TimeFormat, h.Get("Last-Modified"))
+
+	if rtr.CheckNotModified(ew.ResponseWriter, r, rtr.Validators{ETag: etag, LastModified: lastModified}) {
+		return
+	}
+	ew.ResponseWriter.WriteHeader(ew.status)
+	_, _ = ew.ResponseWriter.Write(ew.body)
+}
+
+// Flush streams the response without an ETag.
+func (ew *etagResponseWriter) Flush() {
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+	ew.stream()
+	_ = http.NewResponseController(ew.ResponseWriter).Flush()
+}
+
+// Hijack lets the handler take over the connection.
+func (ew *etagResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(ew.ResponseWriter).Hijack()
+	if err == nil {
+		ew.streaming = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (ew *etagResponseWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
@@ -0,0 +1,109 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+func TestETagMiddleware(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.ETagMiddleware(middlewares.ETagConfig{})})
+	router.AddRoute(rtr.GetHTML("/page", func(w http.ResponseWriter, r *http.Request) string {
+		return "<h1>" + r.URL.Query().Get("v") + "</h1>"
+	}))
+
+	rr := cacheRequest(router, "/page?v=1")
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || rr.Body.String() != "<h1>1</h1>" || !strings.HasPrefix(etag, `"`) {
+		t.Fatalf("Expected the page with a strong ETag, got %d %q %q", rr.Code, rr.Body.String(), etag)
+	}
+	if again := cacheRequest(router, "/page?v=1"); again.Header().Get("ETag") != etag {
+		t.Errorf("Expected a stable ETag, got %q and %q", etag, again.Header().Get("ETag"))
+	}
+
+	rr = cacheRequest(router, "/page?v=1", "If-None-Match", etag)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 || rr.Header().Get("Content-Type") != "" {
+		t.Errorf("Expected an empty 304, got %d %q %v", rr.Code, rr.Body.String(), rr.Header())
+	}
+
+	rr = cacheRequest(router, "/page?v=2", "If-None-Match", etag)
+	if rr.Code != http.StatusOK || rr.Body.String() != "<h1>2</h1>" || rr.Header().Get("ETag") == etag {
+		t.Errorf("Expected a changed body to get a new ETag, got %d %q %q", rr.Code, rr.Body.String(), rr.Header().Get("ETag"))
+	}
+
+	weak := middlewares.ETagMiddleware(middlewares.ETagConfig{Weak: true}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("weak"))
+	}))
+	if rr := cacheRequest(weak, "/"); !strings.HasPrefix(rr.Header().Get("ETag"), `W/"`) {
+		t.Errorf("Expected a weak ETag, got %q", rr.Header().Get("ETag"))
+	}
+}
+
+func TestETagMiddleware_HandlerValidators(t *testing.T) {
+	rendered := 0
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.ETagMiddleware(middlewares.ETagConfig{})})
+	router.AddRoute(rtr.GetJSON("/article", func(w http.ResponseWriter, r *http.Request) string {
+		if rtr.CheckNotModified(w, r, rtr.Validators{ETag: "article-7"}) {
+			return ""
+		}
+		rendered++
+		return `{"id":7}`
+	}))
+
+	rr := cacheRequest(router, "/article")
+	if rr.Header().Get("ETag") != `"article-7"` || rr.Body.String() != `{"id":7}` {
+		t.Fatalf("Expected the handler's ETag to be kept, got %q %q", rr.Header().Get("ETag"), rr.Body.String())
+	}
+
+	rr = cacheRequest(router, "/article", "If-None-Match", `"article-7"`)
+	if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+		t.Errorf("Expected an empty 304, got %d %q", rr.Code, rr.Body.String())
+	}
+	if rendered != 1 {
+		t.Errorf("Expected the handler to skip rendering, rendered %d times", rendered)
+	}
+}
+
+func TestETagMiddleware_Skips(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{"post", http.MethodPost, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("created"))
+		}},
+		{"not found", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}},
+		{"too large", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(strings.Repeat("x", 2<<20)))
+		}},
+		{"flushed", http.MethodGet, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("event"))
+			_ = http.NewResponseController(w).Flush()
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := middlewares.ETagMiddleware(middlewares.ETagConfig{}).GetHandler()(tt.handler)
+			req := httptest.NewRequest(tt.method, "/", nil)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Header().Get("ETag") != "" {
+				t.Errorf("Expected no ETag, got %q", rr.Header().Get("ETag"))
+			}
+			if rr.Body.Len() == 0 {
+				t.Error("Expected the body to be passed through")
+			}
+		})
+	}
+}
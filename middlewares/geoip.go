@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+)
+
+// GeoIPLookup resolves the ISO 3166-1 alpha-2 country code of an IP. It
+// returns "" if the country is unknown.
+type GeoIPLookup interface {
+	Country(ip netip.Addr) string
+}
+
+// GeoIPDatabase is an in-memory GeoIPLookup loaded from a CSV file. See
+// LoadGeoIPDatabase for the format.
+type GeoIPDatabase struct {
+	ranges []geoIPRange
+}
+
+// geoIPRange maps an inclusive address range to a country.
+type geoIPRange struct {
+	start   netip.Addr
+	end     netip.Addr
+	country string
+	// parent is the index of the innermost range containing this one, or
+	// -1.
+	parent int
+}
+
+// LoadGeoIPDatabase reads a local GeoIP database in CSV format, one
+// "network,country" row per line, e.g. "192.0.2.0/24,NL". The network may be
+// a CIDR or a single IP; the country is an ISO 3166-1 alpha-2 code. A header
+// row, blank lines and lines starting with # are ignored. Networks may be
+// nested, e.g. a /16 assigned to another country than its /8; the most
+// specific one wins. Most free country databases can be exported to this
+// format.
+func LoadGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseGeoIPDatabase(f)
+}
+
+// ParseGeoIPDatabase reads a GeoIP database in the format described by
+// LoadGeoIPDatabase.
+func ParseGeoIPDatabase(r io.Reader) (*GeoIPDatabase, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	db := &GeoIPDatabase{}
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("geoip: %w", err)
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("geoip: line %d: expected network and country", line)
+		}
+
+		prefix, err := parseIPPrefix(record[0])
+		if err != nil {
+			if line == 1 {
+				// Header row.
+				continue
+			}
+			return nil, fmt.Errorf("geoip: line %d: %w", line, err)
+		}
+
+		db.ranges = append(db.ranges, geoIPRange{
+			start:   prefix.Addr(),
+			end:     lastAddr(prefix),
+			country: strings.ToUpper(strings.TrimSpace(record[1])),
+		})
+	}
+
+	// Networks are either nested or disjoint, so sorted by start, larger
+	// first, each one's parent is the last earlier range still open.
+	sort.SliceStable(db.ranges, func(i, j int) bool {
+		a, b := db.ranges[i], db.ranges[j]
+		if a.start != b.start {
+			return a.start.Less(b.start)
+		}
+		return b.end.Less(a.end)
+	})
+	var open []int
+	for i := range db.ranges {
+		for len(open) > 0 && db.ranges[open[len(open)-1]].end.Less(db.ranges[i].start) {
+			open = open[:len(open)-1]
+		}
+		db.ranges[i].parent = -1
+		if len(open) > 0 {
+			db.ranges[i].parent = open[len(open)-1]
+		}
+		open = append(open, i)
+	}
+	return db, nil
+}
+
+// Country implements GeoIPLookup.
+func (db *GeoIPDatabase) Country(ip netip.Addr) string {
+	ip = ip.Unmap().WithZone("")
+
+	// Find the last range starting at or before ip. Every range containing
+	// ip contains that one too, so the innermost is found among its
+	// parents.
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return ip.Less(db.ranges[i].start)
+	}) - 1
+	for i >= 0 {
+		r := db.ranges[i]
+		if r.start.BitLen() == ip.BitLen() && !r.end.Less(ip) {
+			return r.country
+		}
+		i = r.parent
+	}
+	return ""
+}
+
+// lastAddr returns the last address of prefix.
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	b := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(b)*8; bit++ {
+		b[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
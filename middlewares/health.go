@@ -0,0 +1,312 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr"
+	"golang.org/x/sync/singleflight"
+)
+
+// Health check statuses, as reported in HealthReport and HealthCheckResult.
+const (
+	HealthStatusPass = "pass"
+	// HealthStatusWarn is reported for failing non-critical checks, which do
+	// not fail the report.
+	HealthStatusWarn = "warn"
+	HealthStatusFail = "fail"
+)
+
+// HealthCheck is a named check of a dependency, e.g. a database ping.
+type HealthCheck struct {
+	// Name identifies the check in reports.
+	Name string
+	// Check returns nil if the dependency is healthy. It should return once
+	// ctx is done.
+	Check func(ctx context.Context) error
+	// Timeout limits how long Check may take before it fails. Optional;
+	// defaults to 5 seconds.
+	Timeout time.Duration
+	// Critical checks fail the report. Failing non-critical checks are
+	// reported as warnings only.
+	Critical bool
+	// Liveness includes the check in /livez as well as /readyz. Only checks
+	// whose failure calls for a restart, e.g. a deadlocked worker, belong
+	// there; dependencies such as databases do not.
+	Liveness bool
+}
+
+// HealthConfig configures a Health.
+type HealthConfig struct {
+	// LivePath is the liveness endpoint. Optional; defaults to "/livez".
+	LivePath string
+	// ReadyPath is the readiness endpoint. Optional; defaults to "/readyz".
+	ReadyPath string
+
+	// CacheTTL is how long check results are reused, so frequent probes do
+	// not overload dependencies. Optional; defaults to 1 second, negative
+	// disables caching.
+	CacheTTL time.Duration
+
+	// Ready reports whether the server accepts traffic, e.g. rtr.Server.Ready.
+	// While it returns false, /readyz fails without running the checks, so
+	// load balancers stop routing requests to a server shutting down.
+	// Optional.
+	Ready func() bool
+}
+
+// HealthReport is the JSON body of the health endpoints.
+type HealthReport struct {
+	// Status is HealthStatusPass, HealthStatusWarn or HealthStatusFail.
+	Status string `json:"status"`
+	// Error explains a failure not caused by a check, e.g. a shutdown.
+	Error string `json:"error,omitempty"`
+	// Checks holds the results in the order the checks were added.
+	Checks []HealthCheckResult `json:"checks"`
+}
+
+// HealthCheckResult is the outcome of a HealthCheck.
+type HealthCheckResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Critical bool   `json:"critical"`
+	// Error is the message of the error returned by the check.
+	Error string `json:"error,omitempty"`
+	// Latency is how long the check took, e.g. "1.2ms".
+	Latency string `json:"latency"`
+	// LatencyMs is Latency in milliseconds.
+	LatencyMs float64 `json:"latency_ms"`
+	// CheckedAt is when the check ran, before the request when the result
+	// was cached.
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Health serves liveness and readiness endpoints reporting registered
+// checks, see Handler.
+type Health struct {
+	config HealthConfig
+
+	mu      sync.Mutex
+	checks  []HealthCheck
+	results map[string]HealthCheckResult
+
+	// runs coalesces concurrent runs of the same check.
+	runs singleflight.Group
+}
+
+// NewHealth returns a Health for config. Add checks with AddCheck.
+func NewHealth(config HealthConfig) *Health {
+	if config.LivePath == "" {
+		config.LivePath = "/livez"
+	}
+	if config.ReadyPath == "" {
+		config.ReadyPath = "/readyz"
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = time.Second
+	}
+	return &Health{config: config, results: make(map[string]HealthCheckResult)}
+}
+
+// AddCheck registers check, replacing a check of the same name.
+func (h *Health) AddCheck(check HealthCheck) *Health {
+	if check.Timeout <= 0 {
+		check.Timeout = 5 * time.Second
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.results, check.Name)
+	for i, existing := range h.checks {
+		if existing.Name == check.Name {
+			h.checks[i] = check
+			return h
+		}
+	}
+	h.checks = append(h.checks, check)
+	return h
+}
+
+// Middleware returns the health endpoints as a middleware, best placed
+// before authentication middlewares. Middlewares only run for matched
+// routes, so to serve the endpoints without declaring routes for them, wrap
+// the router with Handler instead, e.g.
+// rtr.NewServer(health.Handler(router), config).
+func (h *Health) Middleware() rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName("Health").
+		SetHandler(h.Handler)
+}
+
+// Handler answers GET and HEAD requests to the liveness and readiness paths
+// with a HealthReport, passing other requests to next. The status is
+// 200 OK unless the report fails, then 503 Service Unavailable.
+//
+// /livez runs the Liveness checks only, so it keeps passing while
+// dependencies are down or the server shuts down. /readyz runs all checks
+// and fails while HealthConfig.Ready returns false.
+func (h *Health) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var report HealthReport
+		switch {
+		case strings.EqualFold(r.URL.Path, h.config.LivePath):
+			report = h.Live(r.Context())
+		case strings.EqualFold(r.URL.Path, h.config.ReadyPath):
+			report = h.Readiness(r.Context())
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		status := http.StatusOK
+		if report.Status == HealthStatusFail {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		if r.Method != http.MethodHead {
+			_ = json.NewEncoder(w).Encode(report)
+		}
+	})
+}
+
+// Live runs the Liveness checks.
+func (h *Health) Live(ctx context.Context) HealthReport {
+	return h.report(ctx, true)
+}
+
+// Readiness runs all checks, unless HealthConfig.Ready reports the server
+// is not ready.
+func (h *Health) Readiness(ctx context.Context) HealthReport {
+	if h.config.Ready != nil && !h.config.Ready() {
+		return HealthReport{
+			Status: HealthStatusFail,
+			Error:  "server not ready",
+			Checks: []HealthCheckResult{},
+		}
+	}
+	return h.report(ctx, false)
+}
+
+// report runs the selected checks concurrently and aggregates their
+// results.
+func (h *Health) report(ctx context.Context, liveness bool) HealthReport {
+	h.mu.Lock()
+	var checks []HealthCheck
+	for _, check := range h.checks {
+		if !liveness || check.Liveness {
+			checks = append(checks, check)
+		}
+	}
+	h.mu.Unlock()
+
+	report := HealthReport{Status: HealthStatusPass, Checks: make([]HealthCheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Go(func() {
+			report.Checks[i] = h.result(ctx, check)
+		})
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		switch {
+		case result.Status == HealthStatusFail:
+			report.Status = HealthStatusFail
+		case result.Status == HealthStatusWarn && report.Status == HealthStatusPass:
+			report.Status = HealthStatusWarn
+		}
+	}
+	return report
+}
+
+// result returns the cached result of check, or runs it. Concurrent runs
+// are coalesced, and a run outlives a canceled request so its result can
+// still be cached.
+func (h *Health) result(ctx context.Context, check HealthCheck) HealthCheckResult {
+	if h.config.CacheTTL > 0 {
+		h.mu.Lock()
+		cached, ok := h.results[check.Name]
+		h.mu.Unlock()
+		if ok && time.Since(cached.CheckedAt) < h.config.CacheTTL {
+			return cached
+		}
+	}
+
+	runs := h.runs.DoChan(check.Name, func() (any, error) {
+		result := h.run(context.WithoutCancel(ctx), check)
+		h.mu.Lock()
+		h.results[check.Name] = result
+		h.mu.Unlock()
+		return result, nil
+	})
+	select {
+	case run := <-runs:
+		return run.Val.(HealthCheckResult)
+	case <-ctx.Done():
+		return checkResult(check, time.Now(), ctx.Err())
+	}
+}
+
+// run runs check within its timeout. A check ignoring its context is
+// abandoned at the timeout.
+func (h *Health) run(ctx context.Context, check HealthCheck) HealthCheckResult {
+	ctx, cancel := context.WithTimeout(ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		done <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", check.Timeout)
+	}
+	return checkResult(check, start, err)
+}
+
+// checkResult returns the result of check started at start and failed with
+// err, if not nil.
+func checkResult(check HealthCheck, start time.Time, err error) HealthCheckResult {
+	latency := time.Since(start)
+	result := HealthCheckResult{
+		Name:      check.Name,
+		Status:    HealthStatusPass,
+		Critical:  check.Critical,
+		Latency:   latency.String(),
+		LatencyMs: float64(latency.Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Status = HealthStatusWarn
+		if check.Critical {
+			result.Status = HealthStatusFail
+		}
+	}
+	return result
+}
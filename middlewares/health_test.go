@@ -0,0 +1,207 @@
+package middlewares_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+func healthRequest(t *testing.T, handler http.Handler, path string) (int, middlewares.HealthReport) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	var report middlewares.HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Expected a JSON report, got %q: %v", w.Body.String(), err)
+	}
+	return w.Code, report
+}
+
+func healthCheck(err error) func(ctx context.Context) error {
+	return func(ctx context.Context) error { return err }
+}
+
+func TestHealth_Report(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusTeapot) })
+	down := errors.New("connection refused")
+
+	for _, tt := range []struct {
+		name       string
+		checks     []middlewares.HealthCheck
+		wantCode   int
+		wantStatus string
+	}{
+		{"no checks", nil, http.StatusOK, middlewares.HealthStatusPass},
+		{"passing", []middlewares.HealthCheck{
+			{Name: "db", Check: healthCheck(nil), Critical: true},
+		}, http.StatusOK, middlewares.HealthStatusPass},
+		{"non-critical failure", []middlewares.HealthCheck{
+			{Name: "db", Check: healthCheck(nil), Critical: true},
+			{Name: "cache", Check: healthCheck(down)},
+		}, http.StatusOK, middlewares.HealthStatusWarn},
+		{"critical failure", []middlewares.HealthCheck{
+			{Name: "db", Check: healthCheck(down), Critical: true},
+			{Name: "cache", Check: healthCheck(down)},
+		}, http.StatusServiceUnavailable, middlewares.HealthStatusFail},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			health := middlewares.NewHealth(middlewares.HealthConfig{})
+			for _, check := range tt.checks {
+				health.AddCheck(check)
+			}
+			handler := health.Middleware().GetHandler()(next)
+
+			code, report := healthRequest(t, handler, "/readyz")
+			if code != tt.wantCode || report.Status != tt.wantStatus {
+				t.Errorf("Expected %d %q, got %d %q", tt.wantCode, tt.wantStatus, code, report.Status)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("Expected %d check results, got %+v", len(tt.checks), report.Checks)
+			}
+			for i, result := range report.Checks {
+				if result.Name != tt.checks[i].Name || result.Critical != tt.checks[i].Critical || result.Latency == "" {
+					t.Errorf("Unexpected result %+v", result)
+				}
+				if failed := result.Status != middlewares.HealthStatusPass; failed != (result.Error != "") {
+					t.Errorf("Expected an error with status %q, got %+v", result.Status, result)
+				}
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/other", nil))
+			if w.Code != http.StatusTeapot {
+				t.Errorf("Expected other paths to reach next, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestHealth_LiveAndReady(t *testing.T) {
+	ready := atomic.Bool{}
+	health := middlewares.NewHealth(middlewares.HealthConfig{Ready: ready.Load}).
+		AddCheck(middlewares.HealthCheck{Name: "db", Check: healthCheck(errors.New("down")), Critical: true}).
+		AddCheck(middlewares.HealthCheck{Name: "worker", Check: healthCheck(nil), Critical: true, Liveness: true})
+	handler := health.Handler(http.NotFoundHandler())
+
+	code, report := healthRequest(t, handler, "/livez")
+	if code != http.StatusOK || len(report.Checks) != 1 || report.Checks[0].Name != "worker" {
+		t.Errorf("Expected liveness to run the worker check only, got %d %+v", code, report)
+	}
+
+	code, report = healthRequest(t, handler, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Error == "" || len(report.Checks) != 0 {
+		t.Errorf("Expected readiness to fail without checks while not ready, got %d %+v", code, report)
+	}
+
+	ready.Store(true)
+	code, report = healthRequest(t, handler, "/readyz")
+	if code != http.StatusServiceUnavailable || report.Error != "" || len(report.Checks) != 2 {
+		t.Errorf("Expected readiness to run all checks, got %d %+v", code, report)
+	}
+}
+
+func TestHealth_CachingAndConcurrency(t *testing.T) {
+	var runs atomic.Int32
+	slow := func(ctx context.Context) error {
+		runs.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		return nil
+	}
+	health := middlewares.NewHealth(middlewares.HealthConfig{CacheTTL: time.Hour}).
+		AddCheck(middlewares.HealthCheck{Name: "a", Check: slow}).
+		AddCheck(middlewares.HealthCheck{Name: "b", Check: slow})
+
+	start := time.Now()
+	results := make(chan middlewares.HealthReport, 3)
+	for range 3 {
+		go func() { results <- health.Readiness(context.Background()) }()
+	}
+	for range 3 {
+		if report := <-results; report.Status != middlewares.HealthStatusPass {
+			t.Errorf("Expected the checks to pass, got %+v", report)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 180*time.Millisecond {
+		t.Errorf("Expected the checks to run concurrently, took %s", elapsed)
+	}
+	if n := runs.Load(); n != 2 {
+		t.Errorf("Expected concurrent reports to share one run per check, got %d runs", n)
+	}
+
+	health.Readiness(context.Background())
+	if n := runs.Load(); n != 2 {
+		t.Errorf("Expected cached results to be reused, got %d runs", n)
+	}
+}
+
+func TestHealth_Timeout(t *testing.T) {
+	health := middlewares.NewHealth(middlewares.HealthConfig{CacheTTL: -1}).
+		AddCheck(middlewares.HealthCheck{
+			Name:     "stuck",
+			Critical: true,
+			Timeout:  20 * time.Millisecond,
+			Check: func(ctx context.Context) error {
+				time.Sleep(time.Second)
+				return nil
+			},
+		}).
+		AddCheck(middlewares.HealthCheck{
+			Name: "panics",
+			Check: func(ctx context.Context) error {
+				panic("boom")
+			},
+		})
+
+	start := time.Now()
+	report := health.Readiness(context.Background())
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("Expected a check ignoring its context to be abandoned at the timeout")
+	}
+	if report.Status != middlewares.HealthStatusFail || report.Checks[0].Error != "timed out after 20ms" {
+		t.Errorf("Expected the stuck check to time out, got %+v", report)
+	}
+	if report.Checks[1].Status != middlewares.HealthStatusWarn {
+		t.Errorf("Expected the panicking check to fail, got %+v", report.Checks[1])
+	}
+}
+
+func TestHealth_ServerShutdown(t *testing.T) {
+	var server *rtr.Server
+	health := middlewares.NewHealth(middlewares.HealthConfig{Ready: func() bool { return server.Ready() }})
+	handler := health.Handler(rtr.NewRouter())
+
+	readyz := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	shutdownCode := make(chan int, 1)
+	server = rtr.NewServer(handler, rtr.ServerConfig{
+		Addr:       "127.0.0.1:0",
+		OnShutdown: func(ctx context.Context) { shutdownCode <- readyz() },
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() { result <- server.ListenAndServe(ctx) }()
+
+	waitFor(t, server.Ready)
+	if code := readyz(); code != http.StatusOK {
+		t.Errorf("Expected the server to be ready, got %d", code)
+	}
+	cancel()
+	if code := <-shutdownCode; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected readiness to fail during shutdown, got %d", code)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
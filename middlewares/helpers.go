@@ -1,6 +1,9 @@
 package middlewares
 
-import "reflect"
+import (
+	"reflect"
+	"strings"
+)
 
 // isNilInterface reports whether v is nil or is an interface containing a nil
 // pointer/channel/map/slice/function. This is necessary because a Go interface
@@ -16,3 +19,25 @@ func isNilInterface(v any) bool {
 	}
 	return false
 }
+
+// pathMatchesAny reports whether path matches any of the patterns.
+// Supports simple wildcard '*' suffix in patterns (prefix match), e.g., '/blog*'.
+// Without '*', it matches exact segment (exact path or path starting with pattern + '/').
+func pathMatchesAny(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if strings.HasSuffix(pattern, "*") {
+			prefix := strings.TrimSuffix(pattern, "*")
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+			continue
+		}
+		if path == pattern || strings.HasPrefix(path, pattern+"/") {
+			return true
+		}
+	}
+	return false
+}
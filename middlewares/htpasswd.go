@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd holds the users of an htpasswd-format password file. Supported
+// hash formats are bcrypt ($2y$, $2a$, $2b$), Argon2 ($argon2id$, $argon2i$,
+// as produced by the reference implementation) and unsalted SHA-1 ({SHA}).
+// Entries in other formats are skipped with a warning.
+type Htpasswd struct {
+	hashes map[string]string
+}
+
+// LoadHtpasswd reads an htpasswd file.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// ParseHtpasswd reads htpasswd entries ("user:hash" lines) from r. Blank
+// lines and lines starting with # are ignored.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: make(map[string]string)}
+
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" || hash == "" {
+			return nil, fmt.Errorf("htpasswd: malformed line %d", lineNo)
+		}
+		if !htpasswdSupported(hash) {
+			slog.Default().Warn("htpasswd: skipping entry with unsupported hash format", "user", user, "line", lineNo)
+			continue
+		}
+		h.hashes[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Verify reports whether password is correct for username.
+func (h *Htpasswd) Verify(username, password string) bool {
+	hash, ok := h.hashes[username]
+	if !ok {
+		// Spend comparable time on unknown users so response times do not
+		// reveal which usernames exist.
+		_ = bcrypt.CompareHashAndPassword(htpasswdDummyHash, []byte(password))
+		return false
+	}
+	return htpasswdVerify(hash, password)
+}
+
+// htpasswdDummyHash is a bcrypt hash compared against for unknown users.
+var htpasswdDummyHash = []byte("$2a$10$KmaPGOf4wI39dD5k0eMiBOnWZ0PhQkKPMxmlR/x1I1xxKzEb06b3e")
+
+// htpasswdSupported reports whether hash is in a supported format.
+func htpasswdSupported(hash string) bool {
+	for _, prefix := range []string{"$2y$", "$2a$", "$2b$", "$argon2id$", "$argon2i$", "{SHA}"} {
+		if strings.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// htpasswdVerify checks password against a single htpasswd hash.
+func htpasswdVerify(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "$argon2"):
+		return argon2Verify(hash, password)
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		expected := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash[len("{SHA}"):]), []byte(expected)) == 1
+	}
+	return false
+}
+
+// argon2Verify checks password against an encoded Argon2 hash of the form
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>.
+func argon2Verify(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil || threads == 0 {
+		return false
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+
+	var actual []byte
+	if parts[1] == "argon2id" {
+		actual = argon2.IDKey([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	} else {
+		actual = argon2.Key([]byte(password), salt, iterations, memory, threads, uint32(len(expected)))
+	}
+	return subtle.ConstantTimeCompare(actual, expected) == 1
+}
@@ -1,7 +1,10 @@
 package middlewares
 
 import (
+	"log/slog"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/dracory/rtr"
 )
@@ -10,10 +13,30 @@ import (
 type HTTPSRedirectConfig struct {
 	// SkipLocalhost skips HTTPS redirect for localhost and local development
 	SkipLocalhost bool
-	// TrustedProxies contains list of trusted proxy IPs for X-Forwarded-Proto checking
+	// TrustedProxies lists the proxy IPs and CIDRs (e.g. "10.0.0.0/8") whose
+	// Forwarded proto= and X-Forwarded-Proto headers are believed. Requests
+	// from any other peer are judged by their own connection only.
+	//
+	// Behind a TLS-terminating proxy or load balancer, its addresses must be
+	// listed here: without them every request looks like plain HTTP and is
+	// redirected again, looping forever, where earlier versions believed the
+	// headers from anyone. When RealIPMiddlewareWithConfig runs first, the
+	// proxy is still recognised although r.RemoteAddr now holds the client.
 	TrustedProxies []string
 	// CustomSkipFunc allows custom logic to skip HTTPS redirect
 	CustomSkipFunc func(r *http.Request) bool
+	// StatusCode is the redirect status. Optional; defaults to 301 Moved
+	// Permanently. Use 308 Permanent Redirect to preserve the method and body
+	// of POST requests.
+	StatusCode int
+	// AllowedHosts, if set, lists the hosts that may be redirected to. A
+	// leading "*." matches any subdomain. Requests for other hosts get 400
+	// Bad Request instead of a redirect, so a forged Host header cannot turn
+	// the middleware into an open redirect.
+	AllowedHosts []string
+	// HSTS, if enabled, sets Strict-Transport-Security on requests that
+	// already arrived over HTTPS.
+	HSTS *HSTSConfig
 }
 
 // DefaultHTTPSRedirectConfig returns a default configuration
@@ -31,6 +54,21 @@ func NewHTTPSRedirectMiddleware(config *HTTPSRedirectConfig) rtr.MiddlewareInter
 		config = DefaultHTTPSRedirectConfig()
 	}
 
+	proxies := parseTrustedProxies(config.TrustedProxies)
+
+	statusCode := config.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusMovedPermanently
+	}
+
+	hstsValue := ""
+	if config.HSTS != nil && config.HSTS.Enabled {
+		if config.HSTS.Preload && (config.HSTS.MaxAge < 31536000 || !config.HSTS.IncludeSubDomains) {
+			slog.Default().Warn("https redirect: HSTS preload requires a max-age of at least one year and includeSubDomains")
+		}
+		hstsValue = buildHSTSValue(config.HSTS)
+	}
+
 	return rtr.NewMiddleware().
 		SetName("HTTPS Redirect Middleware").
 		SetHandler(func(next http.Handler) http.Handler {
@@ -48,21 +86,82 @@ func NewHTTPSRedirectMiddleware(config *HTTPSRedirectConfig) rtr.MiddlewareInter
 				}
 
 				// Check if already HTTPS
-				if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+				if isHTTPSRequest(r, proxies) {
+					if hstsValue != "" {
+						w.Header().Set("Strict-Transport-Security", hstsValue)
+					}
 					next.ServeHTTP(w, r)
 					return
 				}
 
+				if len(config.AllowedHosts) > 0 && !hostAllowed(r.Host, config.AllowedHosts) {
+					http.Error(w, "Invalid host", http.StatusBadRequest)
+					return
+				}
+
 				// Redirect to HTTPS version of same URL
 				httpsURL := "https://" + r.Host + r.URL.Path
 				if r.URL.RawQuery != "" {
 					httpsURL += "?" + r.URL.RawQuery
 				}
-				http.Redirect(w, r, httpsURL, http.StatusMovedPermanently)
+				http.Redirect(w, r, httpsURL, statusCode)
 			})
 		})
 }
 
+// isHTTPSRequest reports whether the client connected over HTTPS, either
+// directly or, for requests from a trusted proxy, as reported by the last
+// Forwarded proto= or X-Forwarded-Proto value.
+func isHTTPSRequest(r *http.Request, proxies trustedProxies) bool {
+	if r.TLS != nil {
+		return true
+	}
+	if !proxies.isTrustedPeer(r) {
+		return false
+	}
+
+	if elements := parseForwarded(r.Header); len(elements) > 0 {
+		for i := len(elements) - 1; i >= 0; i-- {
+			if proto, ok := elements[i]["proto"]; ok {
+				return strings.EqualFold(proto, "https")
+			}
+		}
+	}
+
+	if values := r.Header.Values("X-Forwarded-Proto"); len(values) > 0 {
+		protos := strings.Split(values[len(values)-1], ",")
+		return strings.EqualFold(strings.TrimSpace(protos[len(protos)-1]), "https")
+	}
+
+	return false
+}
+
+// hostAllowed reports whether host (with an optional port) matches one of
+// the allowed hosts.
+func hostAllowed(host string, allowed []string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
 // isLocalhost checks if the host is a local development address
 func isLocalhost(host string) bool {
 	return host == "localhost" ||
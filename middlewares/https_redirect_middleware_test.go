@@ -128,6 +128,147 @@ func TestHTTPSRedirectMiddleware_CustomConfig(t *testing.T) {
 	}
 }
 
+func TestHTTPSRedirectMiddleware_TrustedProxies(t *testing.T) {
+	middleware := NewHTTPSRedirectMiddleware(&HTTPSRedirectConfig{
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		headers        map[string]string
+		expectedStatus int
+	}{
+		{
+			name:           "X-Forwarded-Proto from untrusted peer is ignored",
+			remoteAddr:     "203.0.113.5:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "https"},
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		{
+			name:           "X-Forwarded-Proto from trusted proxy is honored",
+			remoteAddr:     "10.1.2.3:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "https"},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "X-Forwarded-Proto http from trusted proxy redirects",
+			remoteAddr:     "10.1.2.3:1234",
+			headers:        map[string]string{"X-Forwarded-Proto": "http"},
+			expectedStatus: http.StatusMovedPermanently,
+		},
+		{
+			name:           "Forwarded proto from trusted proxy is honored",
+			remoteAddr:     "10.1.2.3:1234",
+			headers:        map[string]string{"Forwarded": `for=192.0.2.1;proto=https`},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Forwarded proto from untrusted peer is ignored",
+			remoteAddr:     "203.0.113.5:1234",
+			headers:        map[string]string{"Forwarded": `proto=https`},
+			expectedStatus: http.StatusMovedPermanently,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://example.com/test", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			rr := httptest.NewRecorder()
+			middleware.GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(200)
+			})).ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestHTTPSRedirectMiddleware_AfterRealIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8"}
+	redirect := NewHTTPSRedirectMiddleware(&HTTPSRedirectConfig{TrustedProxies: trusted})
+	realIP := RealIPMiddlewareWithConfig(RealIPConfig{TrustedProxies: trusted})
+
+	var remoteAddr string
+	handler := realIP.GetHandler()(redirect.GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+		w.WriteHeader(200)
+	})))
+
+	req := httptest.NewRequest("GET", "http://example.com/test", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.9")
+	req.Header.Set("X-Forwarded-Proto", "https")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected the proxy's proto to be trusted after RemoteAddr was rewritten, got %d", rr.Code)
+	}
+	if remoteAddr != "203.0.113.9" {
+		t.Errorf("Expected RemoteAddr to be the client, got %q", remoteAddr)
+	}
+}
+
+func TestHTTPSRedirectMiddleware_StatusAllowedHostsAndHSTS(t *testing.T) {
+	middleware := NewHTTPSRedirectMiddleware(&HTTPSRedirectConfig{
+		StatusCode:   http.StatusPermanentRedirect,
+		AllowedHosts: []string{"example.com", "*.example.org"},
+		HSTS:         &HSTSConfig{Enabled: true, MaxAge: 31536000, IncludeSubDomains: true, Preload: true},
+	})
+	handler := middleware.GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+
+	tests := []struct {
+		name             string
+		host             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"allowed host", "example.com", http.StatusPermanentRedirect, "https://example.com/submit"},
+		{"allowed host with port", "example.com:8080", http.StatusPermanentRedirect, "https://example.com:8080/submit"},
+		{"wildcard subdomain", "api.example.org", http.StatusPermanentRedirect, "https://api.example.org/submit"},
+		{"wildcard does not match apex", "example.org", http.StatusBadRequest, ""},
+		{"forged host", "evil.com", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "http://example.com/submit", nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			if got := rr.Header().Get("Location"); got != tt.expectedLocation {
+				t.Errorf("Expected location %q, got %q", tt.expectedLocation, got)
+			}
+			if rr.Header().Get("Strict-Transport-Security") != "" {
+				t.Error("Expected no HSTS header over plain HTTP")
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	req.TLS = &tls.ConnectionState{}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains; preload" {
+		t.Errorf("Unexpected HSTS header %q", got)
+	}
+}
+
 func TestIsLocalhost(t *testing.T) {
 	tests := []struct {
 		host     string
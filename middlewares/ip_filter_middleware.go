@@ -0,0 +1,203 @@
+package middlewares
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/dracory/rtr"
+)
+
+// IPFilterConfig configures an IPFilter. Deny rules win over allow rules:
+// a request is rejected if its IP matches Deny or DenyCountries, or if an
+// allow list is set and the IP matches neither Allow nor AllowCountries.
+type IPFilterConfig struct {
+	// Allow lists the CIDRs or IPs allowed in. Optional; if empty (and
+	// AllowCountries is empty), every IP not denied is allowed.
+	Allow []string
+	// Deny lists the CIDRs or IPs rejected.
+	Deny []string
+
+	// GeoIP resolves countries for AllowCountries and DenyCountries, e.g. a
+	// database loaded with LoadGeoIPDatabase.
+	GeoIP GeoIPLookup
+	// AllowCountries lists the ISO 3166-1 alpha-2 country codes allowed in.
+	AllowCountries []string
+	// DenyCountries lists the country codes rejected.
+	DenyCountries []string
+
+	// OnReject writes the response for a rejected request. Optional;
+	// defaults to 403 Forbidden.
+	OnReject func(w http.ResponseWriter, r *http.Request, ip string)
+}
+
+// IPFilter allows or rejects requests by client IP. Its rules can be
+// replaced at runtime with SetRules, e.g. after reloading them from a file.
+// It is created by NewIPFilter.
+type IPFilter struct {
+	rules    atomic.Pointer[ipFilterRules]
+	onReject func(w http.ResponseWriter, r *http.Request, ip string)
+}
+
+// ipFilterRules is an immutable, parsed set of IPFilter rules.
+type ipFilterRules struct {
+	allow          []netip.Prefix
+	deny           []netip.Prefix
+	geoIP          GeoIPLookup
+	allowCountries []string
+	denyCountries  []string
+}
+
+// IPFilterMiddleware returns a middleware that filters requests by client
+// IP; see IPFilterConfig. Use NewIPFilter to be able to reload the rules.
+//
+// If config contains an invalid CIDR or IP, every request is answered with
+// 500 Internal Server Error describing the problem.
+func IPFilterMiddleware(config IPFilterConfig) rtr.MiddlewareInterface {
+	filter, err := NewIPFilter(config)
+	if err != nil {
+		return rtr.NewMiddleware().
+			SetName("IP Filter").
+			SetHandler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				})
+			})
+	}
+	return filter.Middleware()
+}
+
+// NewIPFilter creates an IPFilter. It returns an error if a rule is not a
+// valid CIDR or IP, or if country rules are set without GeoIP.
+func NewIPFilter(config IPFilterConfig) (*IPFilter, error) {
+	f := &IPFilter{onReject: config.OnReject}
+	if f.onReject == nil {
+		f.onReject = func(w http.ResponseWriter, r *http.Request, ip string) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		}
+	}
+	if err := f.SetRules(config); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// SetRules atomically replaces the allow, deny and country rules of the
+// filter with those in config. OnReject is not changed. On error the current
+// rules are kept.
+func (f *IPFilter) SetRules(config IPFilterConfig) error {
+	allow, err := parseIPFilterList(config.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseIPFilterList(config.Deny)
+	if err != nil {
+		return err
+	}
+	if (len(config.AllowCountries) > 0 || len(config.DenyCountries) > 0) && config.GeoIP == nil {
+		return errors.New("ip filter: GeoIP is required for country rules")
+	}
+
+	f.rules.Store(&ipFilterRules{
+		allow:          allow,
+		deny:           deny,
+		geoIP:          config.GeoIP,
+		allowCountries: upperAll(config.AllowCountries),
+		denyCountries:  upperAll(config.DenyCountries),
+	})
+	return nil
+}
+
+// Allowed reports whether requests from ip pass the filter. An invalid IP is
+// rejected when an allow list is set and allowed otherwise.
+func (f *IPFilter) Allowed(ip string) bool {
+	rules := f.rules.Load()
+
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(rules.allow) == 0 && len(rules.allowCountries) == 0
+	}
+	addr = addr.Unmap().WithZone("")
+
+	if prefixesContain(rules.deny, addr) {
+		return false
+	}
+
+	country := ""
+	if rules.geoIP != nil {
+		country = rules.geoIP.Country(addr)
+	}
+	if country != "" && slices.Contains(rules.denyCountries, country) {
+		return false
+	}
+
+	if len(rules.allow) == 0 && len(rules.allowCountries) == 0 {
+		return true
+	}
+	return prefixesContain(rules.allow, addr) ||
+		(country != "" && slices.Contains(rules.allowCountries, country))
+}
+
+// Middleware returns the filter as a named middleware. Attach it to a
+// router, domain or group.
+func (f *IPFilter) Middleware() rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName("IP Filter").
+		SetHandler(f.Handler)
+}
+
+// Handler is the middleware handler enforcing the filter. The client IP is
+// the one resolved by RealIPMiddlewareWithConfig if it ran, otherwise the
+// peer address; forwarding headers are never read directly, as they could
+// be forged to bypass the filter.
+func (f *IPFilter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := GetRealIP(r.Context())
+		if ip == "" {
+			ip = remoteIP(r)
+		}
+
+		if !f.Allowed(ip) {
+			f.onReject(w, r, ip)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// parseIPFilterList parses CIDRs and IPs, failing on the first invalid one.
+func parseIPFilterList(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parseIPPrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("ip filter: invalid CIDR or IP %q", entry)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// prefixesContain reports whether addr belongs to one of prefixes.
+func prefixesContain(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// upperAll returns the upper-cased, trimmed values.
+func upperAll(values []string) []string {
+	upper := make([]string, len(values))
+	for i, v := range values {
+		upper[i] = strings.ToUpper(strings.TrimSpace(v))
+	}
+	return upper
+}
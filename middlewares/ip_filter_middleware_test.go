@@ -0,0 +1,204 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+func ipFilterRequest(handler http.Handler, path, remoteAddr string, headers map[string]string) int {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code
+}
+
+func TestIPFilterMiddleware_AllowAndDeny(t *testing.T) {
+	handler := middlewares.IPFilterMiddleware(middlewares.IPFilterConfig{
+		Allow: []string{"10.0.0.0/8", "2001:db8::/32"},
+		Deny:  []string{"10.6.6.0/24"},
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remoteAddr string
+		expected   int
+	}{
+		{"10.1.2.3:1234", http.StatusOK},
+		{"[2001:db8::1]:1234", http.StatusOK},
+		{"[::ffff:10.1.2.3]:1234", http.StatusOK},
+		{"10.6.6.6:1234", http.StatusForbidden},
+		{"203.0.113.1:1234", http.StatusForbidden},
+		{"garbage", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		if code := ipFilterRequest(handler, "/", tt.remoteAddr, nil); code != tt.expected {
+			t.Errorf("%s: expected status %d, got %d", tt.remoteAddr, tt.expected, code)
+		}
+	}
+}
+
+func TestIPFilterMiddleware_UsesRealIPNotHeaders(t *testing.T) {
+	filter := middlewares.IPFilterMiddleware(middlewares.IPFilterConfig{Allow: []string{"198.51.100.0/24"}})
+	realIP := middlewares.RealIPMiddlewareWithConfig(middlewares.RealIPConfig{TrustedProxies: []string{"10.0.0.1"}})
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := realIP.GetHandler()(filter.GetHandler()(ok))
+	if code := ipFilterRequest(handler, "/", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}); code != http.StatusOK {
+		t.Errorf("Expected IP from trusted proxy to be allowed, got %d", code)
+	}
+	if code := ipFilterRequest(handler, "/", "203.0.113.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}); code != http.StatusForbidden {
+		t.Errorf("Expected forged header from untrusted peer to be ignored, got %d", code)
+	}
+
+	if code := ipFilterRequest(filter.GetHandler()(ok), "/", "203.0.113.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.7"}); code != http.StatusForbidden {
+		t.Errorf("Expected forwarding headers to be ignored without RealIP, got %d", code)
+	}
+}
+
+func TestIPFilter_ReloadAndGroups(t *testing.T) {
+	filter, err := middlewares.NewIPFilter(middlewares.IPFilterConfig{
+		Allow: []string{"192.0.2.0/24"},
+		OnReject: func(w http.ResponseWriter, r *http.Request, ip string) {
+			http.Error(w, "office only: "+ip, http.StatusNotFound)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewIPFilter failed: %v", err)
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.Get("/", ok))
+	router.AddGroup(rtr.NewGroup().
+		SetPrefix("/admin").
+		AddBeforeMiddlewares([]rtr.MiddlewareInterface{filter.Middleware()}).
+		AddRoute(rtr.Get("/dashboard", ok)))
+
+	if code := ipFilterRequest(router, "/", "203.0.113.1:1234", nil); code != http.StatusOK {
+		t.Errorf("Expected routes outside the group to be unfiltered, got %d", code)
+	}
+	if code := ipFilterRequest(router, "/admin/dashboard", "203.0.113.1:1234", nil); code != http.StatusNotFound {
+		t.Errorf("Expected custom rejection, got %d", code)
+	}
+
+	if err := filter.SetRules(middlewares.IPFilterConfig{Allow: []string{"not-an-ip"}}); err == nil {
+		t.Error("Expected an error for an invalid rule")
+	}
+	if code := ipFilterRequest(router, "/admin/dashboard", "192.0.2.5:1234", nil); code != http.StatusOK {
+		t.Errorf("Expected previous rules to be kept after a failed reload, got %d", code)
+	}
+
+	if err := filter.SetRules(middlewares.IPFilterConfig{Allow: []string{"203.0.113.0/24"}}); err != nil {
+		t.Fatalf("SetRules failed: %v", err)
+	}
+	if code := ipFilterRequest(router, "/admin/dashboard", "203.0.113.1:1234", nil); code != http.StatusOK {
+		t.Errorf("Expected reloaded rules to apply, got %d", code)
+	}
+	if code := ipFilterRequest(router, "/admin/dashboard", "192.0.2.5:1234", nil); code != http.StatusNotFound {
+		t.Errorf("Expected old rules to be replaced, got %d", code)
+	}
+}
+
+func TestIPFilter_Countries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	content := strings.Join([]string{
+		"network,country",
+		"# test ranges",
+		"192.0.2.0/24,nl",
+		"198.51.100.0/24,RU",
+		"2001:db8::/32,DE",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	geoIP, err := middlewares.LoadGeoIPDatabase(path)
+	if err != nil {
+		t.Fatalf("LoadGeoIPDatabase failed: %v", err)
+	}
+	if got := geoIP.Country(netip.MustParseAddr("192.0.2.200")); got != "NL" {
+		t.Errorf("Expected NL, got %q", got)
+	}
+	if got := geoIP.Country(netip.MustParseAddr("192.0.3.1")); got != "" {
+		t.Errorf("Expected unknown country, got %q", got)
+	}
+
+	filter, err := middlewares.NewIPFilter(middlewares.IPFilterConfig{
+		GeoIP:          geoIP,
+		AllowCountries: []string{"NL", "DE"},
+		Allow:          []string{"203.0.113.1"},
+	})
+	if err != nil {
+		t.Fatalf("NewIPFilter failed: %v", err)
+	}
+
+	for ip, expected := range map[string]bool{
+		"192.0.2.1":    true,
+		"2001:db8::1":  true,
+		"198.51.100.1": false,
+		"203.0.113.1":  true,
+		"203.0.113.2":  false,
+	} {
+		if got := filter.Allowed(ip); got != expected {
+			t.Errorf("%s: expected allowed=%v, got %v", ip, expected, got)
+		}
+	}
+
+	_ = filter.SetRules(middlewares.IPFilterConfig{GeoIP: geoIP, DenyCountries: []string{"ru"}})
+	if filter.Allowed("198.51.100.1") || !filter.Allowed("203.0.113.2") {
+		t.Error("Expected only the denied country to be rejected")
+	}
+
+	if _, err := middlewares.NewIPFilter(middlewares.IPFilterConfig{DenyCountries: []string{"RU"}}); err == nil {
+		t.Error("Expected an error for country rules without GeoIP")
+	}
+}
+
+func TestGeoIPDatabase_NestedRanges(t *testing.T) {
+	geoIP, err := middlewares.ParseGeoIPDatabase(strings.NewReader(strings.Join([]string{
+		"10.1.2.0/24,DE",
+		"10.0.0.0/8,US",
+		"10.1.0.0/16,NL",
+		"10.3.0.0/16,FR",
+		"2001:db8::/32,BE",
+	}, "\n")))
+	if err != nil {
+		t.Fatalf("ParseGeoIPDatabase failed: %v", err)
+	}
+
+	for ip, expected := range map[string]string{
+		"10.0.0.1":    "US",
+		"10.1.0.1":    "NL",
+		"10.1.2.3":    "DE",
+		"10.1.3.1":    "NL",
+		"10.2.0.1":    "US",
+		"10.3.0.1":    "FR",
+		"10.4.0.1":    "US",
+		"11.0.0.1":    "",
+		"2001:db8::1": "BE",
+	} {
+		if got := geoIP.Country(netip.MustParseAddr(ip)); got != expected {
+			t.Errorf("%s: expected %q, got %q", ip, expected, got)
+		}
+	}
+
+	filter, err := middlewares.NewIPFilter(middlewares.IPFilterConfig{GeoIP: geoIP, DenyCountries: []string{"US"}})
+	if err != nil {
+		t.Fatalf("NewIPFilter failed: %v", err)
+	}
+	if filter.Allowed("10.2.0.1") || !filter.Allowed("10.1.0.1") {
+		t.Error("Expected addresses outside nested ranges to keep the outer range's country")
+	}
+}
@@ -1,56 +1,98 @@
 package middlewares
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"regexp"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/dracory/rtr"
-	"github.com/jellydator/ttlcache/v3"
 	"github.com/samber/lo"
 )
 
-// getIP extracts the real IP address from the request
+// getIP returns the client IP of the request: the one resolved by
+// RealIPMiddlewareWithConfig, or else the direct peer. Forwarding headers
+// are never read here, as any client can set them to dodge the jail or to
+// get someone else jailed.
 func getIP(r *http.Request) string {
-	// Check X-Forwarded-For header first
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		xff = strings.TrimSpace(xff)
-		if xff != "" {
-			// X-Forwarded-For can contain multiple IPs, take the first one
-			if idx := strings.Index(xff, ","); idx != -1 {
-				return strings.TrimSpace(xff[:idx])
-			}
-			return xff
-		}
+	if ip := GetRealIP(r.Context()); ip != "" {
+		return ip
 	}
+	return remoteIP(r)
+}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		xri = strings.TrimSpace(xri)
-		if xri != "" {
-			return xri
-		}
+// defaultJailDuration is how long an IP stays jailed when
+// JailBotsConfig.JailDuration is not set.
+const defaultJailDuration = 5 * time.Minute
+
+// JailBotsMiddleware returns a middleware that jails IPs requesting paths that
+// only malicious bots probe for (e.g. "/wp-admin" or ".env"), answering every
+// request from a jailed IP with 403 Forbidden. Use NewJailBots to also get the
+// admin API.
+//
+// IPs are those resolved by RealIPMiddlewareWithConfig, or else the direct
+// peer's, so behind a proxy put RealIPMiddlewareWithConfig in front, or the
+// proxy itself gets jailed. Earlier versions read X-Forwarded-For and
+// X-Real-IP from any client; deployments behind a proxy relying on that now
+// jail the proxy, and with it the whole site, after the first bot request.
+//
+// If config contains an invalid regex pattern, every request is answered with
+// 500 Internal Server Error describing the problem.
+func JailBotsMiddleware(config JailBotsConfig) rtr.MiddlewareInterface {
+	jb, err := NewJailBots(config)
+	if err != nil {
+		configErr := err.Error()
+		return rtr.NewMiddleware().
+			SetName("Jail Bots Middleware").
+			SetHandler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, configErr, http.StatusInternalServerError)
+				})
+			})
 	}
 
-	// Fall back to RemoteAddr
-	if idx := strings.LastIndex(r.RemoteAddr, ":"); idx != -1 {
-		return r.RemoteAddr[:idx]
-	}
-	return r.RemoteAddr
+	return jb.Middleware()
 }
 
-func JailBotsMiddleware(config JailBotsConfig) rtr.MiddlewareInterface {
-	jb := new(jailBotsMiddleware)
-	jb.exclude = config.Exclude
-	jb.cache = ttlcache.New[string, struct{}]()
-	jb.excludePaths = append([]string{}, config.ExcludePaths...)
-	m := rtr.NewMiddleware().
-		SetName(jb.Name()).
-		SetHandler(jb.Handler)
-
-	return m
+// NewJailBots creates the jail used by JailBotsMiddleware. Besides the
+// middleware, it offers an admin API to list, jail and unjail IPs. It returns
+// an error if a regex pattern does not compile.
+func NewJailBots(config JailBotsConfig) (*JailBots, error) {
+	jb := &JailBots{
+		exclude:      append([]string{}, config.Exclude...),
+		excludePaths: append([]string{}, config.ExcludePaths...),
+		store:        config.Store,
+		jailDuration: config.JailDuration,
+		onEvent:      config.OnEvent,
+	}
+
+	if jb.store == nil {
+		jb.store = NewMemoryJailStore()
+	}
+	if jb.jailDuration <= 0 {
+		jb.jailDuration = defaultJailDuration
+	}
+
+	if !config.DisableDefaultPatterns {
+		jb.containsList = defaultContainsBlacklist()
+		jb.startsWithList = defaultStartsWithBlacklist()
+	}
+	jb.containsList = append(jb.containsList, config.ContainsPatterns...)
+	jb.startsWithList = append(jb.startsWithList, config.StartsWithPatterns...)
+
+	for _, pattern := range config.RegexPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("jail bots: invalid regex pattern %q: %w", pattern, err)
+		}
+		jb.regexList = append(jb.regexList, re)
+	}
+
+	return jb, nil
 }
 
 // JailBotsConfig defines configuration for jail bots middleware
@@ -69,19 +111,85 @@ type JailBotsConfig struct {
 	//    subpath starting with that segment (e.g. "/blog/..."), but NOT lookalikes
 	//    like "/blogger".
 	ExcludePaths []string
+
+	// ContainsPatterns are added to the built-in list of strings that jail an
+	// IP when found anywhere in the request path.
+	ContainsPatterns []string
+
+	// StartsWithPatterns are added to the built-in list of prefixes that jail
+	// an IP when the request path starts with them.
+	StartsWithPatterns []string
+
+	// RegexPatterns are regular expressions that jail an IP when they match
+	// the request path.
+	RegexPatterns []string
+
+	// DisableDefaultPatterns drops the built-in contains and starts-with
+	// lists, leaving only the user-supplied patterns.
+	DisableDefaultPatterns bool
+
+	// JailDuration is how long an IP stays jailed. Defaults to 5 minutes.
+	JailDuration time.Duration
+
+	// Store keeps the jailed IPs. Defaults to NewMemoryJailStore; use a shared
+	// store to keep bans across restarts and replicas.
+	Store JailStore
+
+	// OnEvent is called whenever an IP is jailed or unjailed, e.g. to raise
+	// an alert. It runs synchronously on the request goroutine.
+	OnEvent func(JailEvent)
 }
 
-type jailBotsMiddleware struct {
-	exclude      []string
-	cache        *ttlcache.Cache[string, struct{}]
-	excludePaths []string
+// JailEventType identifies the kind of JailEvent.
+type JailEventType string
+
+const (
+	// JailEventJailed is emitted when an IP is jailed, automatically or via
+	// JailBots.Jail.
+	JailEventJailed JailEventType = "jailed"
+	// JailEventUnjailed is emitted when an IP is released via JailBots.Unjail.
+	JailEventUnjailed JailEventType = "unjailed"
+)
+
+// JailEvent describes a change to the jail.
+type JailEvent struct {
+	Type      JailEventType
+	IP        string
+	Reason    string
+	ExpiresAt time.Time
+	// Path and UserAgent are set for automatic jailings.
+	Path      string
+	UserAgent string
+	// Manual is true for events caused by the admin API.
+	Manual bool
 }
 
-func (m *jailBotsMiddleware) Name() string {
+// JailBots jails IPs of malicious bots. It is created by NewJailBots.
+type JailBots struct {
+	exclude        []string
+	excludePaths   []string
+	containsList   []string
+	startsWithList []string
+	regexList      []*regexp.Regexp
+	store          JailStore
+	jailDuration   time.Duration
+	onEvent        func(JailEvent)
+}
+
+// Name returns the middleware name.
+func (m *JailBots) Name() string {
 	return "Jail Bots Middleware"
 }
 
-func (m *jailBotsMiddleware) Handler(next http.Handler) http.Handler {
+// Middleware returns the jail as a named middleware.
+func (m *JailBots) Middleware() rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName(m.Name()).
+		SetHandler(m.Handler)
+}
+
+// Handler is the middleware handler enforcing the jail.
+func (m *JailBots) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		path := r.URL.Path
 		ip := getIP(r)
@@ -92,7 +200,7 @@ func (m *jailBotsMiddleware) Handler(next http.Handler) http.Handler {
 			return
 		}
 
-		if m.isJailed(ip) {
+		if m.isJailed(r.Context(), ip) {
 			w.WriteHeader(http.StatusForbidden)
 			writeJailBotsHTML(w, "malicious access not allowed (jb)")
 			return
@@ -101,15 +209,24 @@ func (m *jailBotsMiddleware) Handler(next http.Handler) http.Handler {
 		jailable, reason := m.isJailable(path)
 
 		if jailable {
-			m.jail(ip)
+			entry := m.jail(r.Context(), ip, reason)
 
-			slog.Default().Info("Jailed bot from "+ip+" for 5 minutes",
+			slog.Default().Info("Jailed bot from "+ip+" for "+m.jailDuration.String(),
 				slog.String("reason", reason),
 				slog.String("path", path),
 				slog.String("ip", ip),
 				slog.String("useragent", r.UserAgent()),
 			)
 
+			m.emit(JailEvent{
+				Type:      JailEventJailed,
+				IP:        ip,
+				Reason:    reason,
+				ExpiresAt: entry.ExpiresAt,
+				Path:      path,
+				UserAgent: r.UserAgent(),
+			})
+
 			w.WriteHeader(http.StatusForbidden)
 			writeJailBotsHTML(w, "malicious access not allowed (jb)")
 			return
@@ -119,6 +236,42 @@ func (m *jailBotsMiddleware) Handler(next http.Handler) http.Handler {
 	})
 }
 
+// Jail manually jails ip for duration (the configured JailDuration if zero).
+func (m *JailBots) Jail(ctx context.Context, ip string, duration time.Duration, reason string) error {
+	if duration <= 0 {
+		duration = m.jailDuration
+	}
+
+	now := time.Now()
+	entry := JailEntry{IP: ip, Reason: reason, JailedAt: now, ExpiresAt: now.Add(duration)}
+	if err := m.store.Jail(ctx, entry); err != nil {
+		return err
+	}
+
+	m.emit(JailEvent{Type: JailEventJailed, IP: ip, Reason: reason, ExpiresAt: entry.ExpiresAt, Manual: true})
+	return nil
+}
+
+// Unjail releases ip from the jail.
+func (m *JailBots) Unjail(ctx context.Context, ip string) error {
+	if err := m.store.Unjail(ctx, ip); err != nil {
+		return err
+	}
+
+	m.emit(JailEvent{Type: JailEventUnjailed, IP: ip, Manual: true})
+	return nil
+}
+
+// List returns the currently jailed IPs.
+func (m *JailBots) List(ctx context.Context) ([]JailEntry, error) {
+	return m.store.List(ctx)
+}
+
+// IsJailed reports whether ip is currently jailed.
+func (m *JailBots) IsJailed(ctx context.Context, ip string) (bool, error) {
+	return m.store.IsJailed(ctx, ip)
+}
+
 func writeJailBotsHTML(w http.ResponseWriter, body string) {
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if _, err := w.Write([]byte(body)); err != nil {
@@ -126,21 +279,36 @@ func writeJailBotsHTML(w http.ResponseWriter, body string) {
 	}
 }
 
-func (j *jailBotsMiddleware) isJailed(ip string) bool {
-	if j.cache == nil {
+// isJailed reports whether ip is jailed. Store errors are logged and the IP
+// is treated as not jailed, so an unavailable store does not block everyone.
+func (j *JailBots) isJailed(ctx context.Context, ip string) bool {
+	jailed, err := j.store.IsJailed(ctx, ip)
+	if err != nil {
+		slog.Default().Error("jail bots: store lookup failed", slog.String("ip", ip), slog.String("error", err.Error()))
 		return false
 	}
-	return j.cache.Has("jail:" + ip)
+	return jailed
+}
+
+// jail stores a jail entry for ip. Store errors are logged; the current
+// request is rejected regardless.
+func (j *JailBots) jail(ctx context.Context, ip string, reason string) JailEntry {
+	now := time.Now()
+	entry := JailEntry{IP: ip, Reason: reason, JailedAt: now, ExpiresAt: now.Add(j.jailDuration)}
+	if err := j.store.Jail(ctx, entry); err != nil {
+		slog.Default().Error("jail bots: store write failed", slog.String("ip", ip), slog.String("error", err.Error()))
+	}
+	return entry
 }
 
-func (j *jailBotsMiddleware) jail(ip string) {
-	if j.cache == nil {
-		return
+// emit passes event to the configured OnEvent callback, if any.
+func (j *JailBots) emit(event JailEvent) {
+	if j.onEvent != nil {
+		j.onEvent(event)
 	}
-	j.cache.Set("jail:"+ip, struct{}{}, 5*time.Minute)
 }
 
-func (m *jailBotsMiddleware) isJailable(path string) (jailable bool, reason string) {
+func (m *JailBots) isJailable(path string) (jailable bool, reason string) {
 	startsWithList := m.startsWithBlacklistedUriList()
 
 	for i := 0; i < len(startsWithList); i++ {
@@ -157,37 +325,65 @@ func (m *jailBotsMiddleware) isJailable(path string) (jailable bool, reason stri
 		}
 	}
 
+	for _, re := range m.regexList {
+		if re.MatchString(path) {
+			return true, "matches " + re.String()
+		}
+	}
+
 	return false, ""
 }
 
 // isExcludedPath returns true for routes that should bypass jail logic entirely.
-// Supports simple wildcard '*' suffix in patterns (prefix match), e.g., '/blog*'.
-// Without '*', it matches exact segment (exact path or path starting with pattern + '/').
-func (m *jailBotsMiddleware) isExcludedPath(path string) bool {
-	for _, pattern := range m.excludePaths {
-		if pattern == "" {
-			continue
-		}
-		if strings.HasSuffix(pattern, "*") {
-			prefix := strings.TrimSuffix(pattern, "*")
-			if strings.HasPrefix(path, prefix) {
-				return true
-			}
-			continue
-		}
-		if path == pattern || strings.HasPrefix(path, pattern+"/") {
-			return true
-		}
-	}
-	return false
+func (m *JailBots) isExcludedPath(path string) bool {
+	return pathMatchesAny(path, m.excludePaths)
 }
 
 // containsBlacklistedUriList returns a list of strings
 // which if they are found anywhere in the uri
 // clearly indicate that there is a malicious bot/user
 // trying to access them.
-func (j *jailBotsMiddleware) containsBlacklistedUriList() []string {
-	stopList := []string{
+func (j *JailBots) containsBlacklistedUriList() []string {
+	stopList := j.containsList
+
+	// Check if we have any exclusion rules?
+
+	if len(j.exclude) > 0 { // Check if exclude list is not empty
+		stopList = lo.Filter(stopList, func(item string, index int) bool {
+			return !slices.Contains(j.exclude, item)
+		})
+	}
+
+	return stopList
+}
+
+// startsWithBlacklistedUriList returns a list of strings
+// which if they are found at the start of the uri
+// clearly indicate that there is a malicious bot/user
+// trying to access them.
+func (j *JailBots) startsWithBlacklistedUriList() []string {
+	startList := j.startsWithList
+
+	// Apply exclusion rules to startsWith list as well
+	if len(j.exclude) > 0 {
+		startList = lo.Filter(startList, func(item string, index int) bool {
+			// Check if any excluded item is contained in the start list item
+			for _, exclude := range j.exclude {
+				if strings.Contains(item, exclude) {
+					return false // Exclude this item
+				}
+			}
+			return true // Keep this item
+		})
+	}
+
+	return startList
+}
+
+// defaultContainsBlacklist returns the built-in strings which, found anywhere
+// in the uri, indicate a malicious bot.
+func defaultContainsBlacklist() []string {
+	return []string{
 		"print(",
 		"${print",
 		".aws",
@@ -238,24 +434,12 @@ func (j *jailBotsMiddleware) containsBlacklistedUriList() []string {
 		"wp",
 		"www/license.txt",
 	}
-
-	// Check if we have any exclusion rules?
-
-	if len(j.exclude) > 0 { // Check if exclude list is not empty
-		stopList = lo.Filter(stopList, func(item string, index int) bool {
-			return !slices.Contains(j.exclude, item)
-		})
-	}
-
-	return stopList
 }
 
-// startsWithBlacklistedUriList returns a list of strings
-// which if they are found at the start of the uri
-// clearly indicate that there is a malicious bot/user
-// trying to access them.
-func (j jailBotsMiddleware) startsWithBlacklistedUriList() []string {
-	startList := []string{
+// defaultStartsWithBlacklist returns the built-in prefixes which, found at the
+// start of the uri, indicate a malicious bot.
+func defaultStartsWithBlacklist() []string {
+	return []string{
 		"/content/sitetree",
 		"/backup",
 		"/bc",
@@ -268,19 +452,4 @@ func (j jailBotsMiddleware) startsWithBlacklistedUriList() []string {
 		"/wp",
 		"/www",
 	}
-
-	// Apply exclusion rules to startsWith list as well
-	if len(j.exclude) > 0 {
-		startList = lo.Filter(startList, func(item string, index int) bool {
-			// Check if any excluded item is contained in the start list item
-			for _, exclude := range j.exclude {
-				if strings.Contains(item, exclude) {
-					return false // Exclude this item
-				}
-			}
-			return true // Keep this item
-		})
-	}
-
-	return startList
 }
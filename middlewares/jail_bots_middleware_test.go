@@ -1,10 +1,12 @@
 package middlewares
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestJailBotsMiddleware(t *testing.T) {
@@ -123,7 +125,7 @@ func TestJailBotsMiddleware_IPJailing(t *testing.T) {
 	}
 }
 
-func TestJailBotsMiddleware_XForwardedFor(t *testing.T) {
+func TestJailBotsMiddleware_IgnoresForwardingHeaders(t *testing.T) {
 	config := JailBotsConfig{}
 	middleware := JailBotsMiddleware(config)
 
@@ -134,7 +136,7 @@ func TestJailBotsMiddleware_XForwardedFor(t *testing.T) {
 
 	handler := middleware.GetHandler()(testHandler)
 
-	// Request with X-Forwarded-For header
+	// A client spoofing X-Forwarded-For is jailed under its own IP
 	req := httptest.NewRequest("GET", "/wp-admin", nil)
 	req.Header.Set("X-Forwarded-For", "203.0.113.1")
 	req.RemoteAddr = "192.168.1.1:12345"
@@ -142,18 +144,27 @@ func TestJailBotsMiddleware_XForwardedFor(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 
 	if rr.Code != http.StatusForbidden {
-		t.Errorf("Expected request with X-Forwarded-For to be jailed, got status %d", rr.Code)
+		t.Errorf("Expected the probing request to be jailed, got status %d", rr.Code)
 	}
 
-	// Second request from same forwarded IP to safe path should still be jailed
+	req = httptest.NewRequest("GET", "/safe/path", nil)
+	req.RemoteAddr = "192.168.1.1:23456"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected the client to be jailed under its own IP, got status %d", rr.Code)
+	}
+
+	// The spoofed IP is not jailed
 	req = httptest.NewRequest("GET", "/safe/path", nil)
 	req.Header.Set("X-Forwarded-For", "203.0.113.1")
 	req.RemoteAddr = "192.168.1.2:12345"
 	rr = httptest.NewRecorder()
 	handler.ServeHTTP(rr, req)
 
-	if rr.Code != http.StatusForbidden {
-		t.Errorf("Expected second request from same forwarded IP to be jailed, got status %d", rr.Code)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected the spoofed IP not to be jailed, got status %d", rr.Code)
 	}
 }
 
@@ -164,23 +175,30 @@ func TestGetIP(t *testing.T) {
 		expectedIP string
 	}{
 		{
-			name: "X-Forwarded-For single IP",
+			name: "X-Forwarded-For ignored",
 			request: &http.Request{
 				Header:     http.Header{"X-Forwarded-For": []string{"203.0.113.1"}},
 				RemoteAddr: "192.168.1.1:12345",
 			},
+			expectedIP: "192.168.1.1",
+		},
+		{
+			name: "IP resolved by RealIPMiddlewareWithConfig",
+			request: (&http.Request{
+				Header:     http.Header{"X-Forwarded-For": []string{"198.51.100.1"}},
+				RemoteAddr: "10.0.0.1:12345",
+			}).WithContext(context.WithValue(context.Background(), realIPKey{}, "203.0.113.1")),
 			expectedIP: "203.0.113.1",
 		},
 		{
-			name: "X-Forwarded-For multiple IPs",
+			name: "IPv6 RemoteAddr",
 			request: &http.Request{
-				Header:     http.Header{"X-Forwarded-For": []string{"203.0.113.1, 198.51.100.1"}},
-				RemoteAddr: "192.168.1.1:12345",
+				RemoteAddr: "[2001:db8::1]:12345",
 			},
-			expectedIP: "203.0.113.1",
+			expectedIP: "2001:db8::1",
 		},
 		{
-			name: "X-Real-IP",
+			name: "X-Real-IP ignored",
 			request: func() *http.Request {
 				req := &http.Request{
 					Header:     http.Header{},
@@ -189,7 +207,7 @@ func TestGetIP(t *testing.T) {
 				req.Header.Set("X-Real-IP", "203.0.113.1")
 				return req
 			}(),
-			expectedIP: "203.0.113.1",
+			expectedIP: "192.168.1.1",
 		},
 		{
 			name: "RemoteAddr only",
@@ -256,3 +274,183 @@ func TestJailBotsMiddleware_Integration(t *testing.T) {
 		})
 	}
 }
+
+func TestJailBotsMiddleware_UsesTrustedRealIP(t *testing.T) {
+	realIP := RealIPMiddlewareWithConfig(RealIPConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	jail := JailBotsMiddleware(JailBotsConfig{})
+
+	handler := realIP.GetHandler()(jail.GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	// An untrusted client spoofing X-Forwarded-For is jailed under its own IP.
+	req := httptest.NewRequest("GET", "/wp-admin", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.RemoteAddr = "203.0.113.9:1234"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/safe", nil)
+	req.RemoteAddr = "203.0.113.9:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected spoofing client to be jailed, got status %d", rr.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/safe", nil)
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	req.RemoteAddr = "10.0.0.1:1234"
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected spoofed IP not to be jailed, got status %d", rr.Code)
+	}
+}
+
+func TestJailBotsMiddleware_JailsClientBehindProxy(t *testing.T) {
+	realIP := RealIPMiddlewareWithConfig(RealIPConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	jail := JailBotsMiddleware(JailBotsConfig{})
+
+	handler := realIP.GetHandler()(jail.GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+	request := func(path, xff string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Forwarded-For", xff)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	// The bot prepends a spoofed entry; the proxy appends its real IP
+	if code := request("/wp-admin", "192.0.2.1, 198.51.100.7"); code != http.StatusForbidden {
+		t.Fatalf("Expected the probing request to be jailed, got status %d", code)
+	}
+
+	if code := request("/safe", "198.51.100.7"); code != http.StatusForbidden {
+		t.Errorf("Expected the IP resolved by RealIP to be jailed, got status %d", code)
+	}
+	if code := request("/safe", "192.0.2.1"); code != http.StatusOK {
+		t.Errorf("Expected the spoofed IP not to be jailed, got status %d", code)
+	}
+	if code := request("/safe", "198.51.100.8"); code != http.StatusOK {
+		t.Errorf("Expected other clients behind the proxy not to be jailed, got status %d", code)
+	}
+}
+
+func TestJailBotsMiddleware_CustomPatterns(t *testing.T) {
+	config := JailBotsConfig{
+		DisableDefaultPatterns: true,
+		ContainsPatterns:       []string{"secret"},
+		StartsWithPatterns:     []string{"/private"},
+		RegexPatterns:          []string{`^/admin/\d+$`},
+	}
+
+	handler := JailBotsMiddleware(config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/wp-admin", http.StatusOK},
+		{"/x/secret/y", http.StatusForbidden},
+		{"/private/area", http.StatusForbidden},
+		{"/admin/42", http.StatusForbidden},
+		{"/admin/list", http.StatusOK},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			req.RemoteAddr = "192.168.2." + string(rune('1'+i)) + ":12345"
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d for %s, got %d", tt.expectedStatus, tt.path, rr.Code)
+			}
+		})
+	}
+}
+
+func TestJailBotsMiddleware_InvalidRegex(t *testing.T) {
+	if _, err := NewJailBots(JailBotsConfig{RegexPatterns: []string{"("}}); err == nil {
+		t.Fatal("Expected error for invalid regex")
+	}
+
+	handler := JailBotsMiddleware(JailBotsConfig{RegexPatterns: []string{"("}}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+	}
+}
+
+func TestJailBots_AdminAPIAndEvents(t *testing.T) {
+	var events []JailEvent
+	jb, err := NewJailBots(JailBotsConfig{
+		JailDuration: time.Hour,
+		OnEvent: func(e JailEvent) {
+			events = append(events, e)
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	handler := jb.Middleware().GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/.env", nil)
+	req.RemoteAddr = "192.168.3.1:12345"
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(events) != 1 || events[0].Type != JailEventJailed || events[0].IP != "192.168.3.1" || events[0].Path != "/.env" || events[0].Manual {
+		t.Fatalf("Expected automatic jail event, got %+v", events)
+	}
+	if until := time.Until(events[0].ExpiresAt); until < 59*time.Minute {
+		t.Errorf("Expected configured jail duration, got expiry in %v", until)
+	}
+
+	ctx := context.Background()
+	if err := jb.Jail(ctx, "192.168.3.2", 0, "manual ban"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	entries, err := jb.List(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 2 || entries[0].IP != "192.168.3.1" || entries[1].IP != "192.168.3.2" || entries[1].Reason != "manual ban" {
+		t.Fatalf("Unexpected jail entries: %+v", entries)
+	}
+
+	req = httptest.NewRequest("GET", "/safe", nil)
+	req.RemoteAddr = "192.168.3.2:12345"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected manually jailed IP to be blocked, got status %d", rr.Code)
+	}
+
+	if err := jb.Unjail(ctx, "192.168.3.2"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected unjailed IP to pass, got status %d", rr.Code)
+	}
+
+	if len(events) != 3 || events[1].Type != JailEventJailed || !events[1].Manual || events[2].Type != JailEventUnjailed {
+		t.Errorf("Unexpected events: %+v", events)
+	}
+}
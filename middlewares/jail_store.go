@@ -0,0 +1,207 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// JailEntry describes a jailed IP address.
+type JailEntry struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	JailedAt  time.Time `json:"jailed_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// JailStore persists jailed IPs for JailBotsMiddleware. Implementations must
+// be safe for concurrent use and must not report expired entries. A store
+// backed by a shared database or Redis lets bans survive restarts and be
+// shared across replicas.
+type JailStore interface {
+	// Jail stores or replaces the entry for entry.IP.
+	Jail(ctx context.Context, entry JailEntry) error
+	// IsJailed reports whether ip is currently jailed.
+	IsJailed(ctx context.Context, ip string) (bool, error)
+	// Unjail removes ip from the jail. Unjailing an IP that is not jailed is
+	// not an error.
+	Unjail(ctx context.Context, ip string) error
+	// List returns all current entries.
+	List(ctx context.Context) ([]JailEntry, error)
+}
+
+// NewMemoryJailStore returns the in-process JailStore used by default. Entries
+// are lost on restart and are not shared between processes.
+func NewMemoryJailStore() JailStore {
+	return &memoryJailStore{
+		cache: ttlcache.New[string, JailEntry](),
+	}
+}
+
+// NewFileJailStore returns a JailStore that keeps its entries in memory and
+// writes them to the JSON file at path on every change, so bans survive
+// restarts. The file is loaded if it exists. It is meant for single-instance
+// deployments; use a shared store for several replicas.
+func NewFileJailStore(path string) (JailStore, error) {
+	s := &fileJailStore{
+		path:    path,
+		entries: make(map[string]JailEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var entries []JailEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		for _, entry := range entries {
+			if entry.ExpiresAt.After(now) {
+				s.entries[entry.IP] = entry
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// memoryJailStore is a JailStore backed by a TTL cache.
+type memoryJailStore struct {
+	cache *ttlcache.Cache[string, JailEntry]
+}
+
+var _ JailStore = (*memoryJailStore)(nil)
+
+func (s *memoryJailStore) Jail(_ context.Context, entry JailEntry) error {
+	ttl := time.Until(entry.ExpiresAt)
+	if ttl <= 0 {
+		// ttlcache treats a non-positive TTL as "never expires".
+		s.cache.Delete(entry.IP)
+		return nil
+	}
+	s.cache.Set(entry.IP, entry, ttl)
+	return nil
+}
+
+func (s *memoryJailStore) IsJailed(_ context.Context, ip string) (bool, error) {
+	return s.cache.Has(ip), nil
+}
+
+func (s *memoryJailStore) Unjail(_ context.Context, ip string) error {
+	s.cache.Delete(ip)
+	return nil
+}
+
+func (s *memoryJailStore) List(_ context.Context) ([]JailEntry, error) {
+	entries := make([]JailEntry, 0, s.cache.Len())
+	for _, item := range s.cache.Items() {
+		if !item.IsExpired() {
+			entries = append(entries, item.Value())
+		}
+	}
+	sortJailEntries(entries)
+	return entries, nil
+}
+
+// fileJailStore is a JailStore persisted to a JSON file.
+type fileJailStore struct {
+	path    string
+	entries map[string]JailEntry
+	mu      sync.Mutex
+}
+
+var _ JailStore = (*fileJailStore)(nil)
+
+func (s *fileJailStore) Jail(_ context.Context, entry JailEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[entry.IP] = entry
+	return s.save()
+}
+
+func (s *fileJailStore) IsJailed(_ context.Context, ip string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[ip]
+	return ok && entry.ExpiresAt.After(time.Now()), nil
+}
+
+func (s *fileJailStore) Unjail(_ context.Context, ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[ip]; !ok {
+		return nil
+	}
+	delete(s.entries, ip)
+	return s.save()
+}
+
+func (s *fileJailStore) List(_ context.Context) ([]JailEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entries := make([]JailEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		if entry.ExpiresAt.After(now) {
+			entries = append(entries, entry)
+		}
+	}
+	sortJailEntries(entries)
+	return entries, nil
+}
+
+// save drops expired entries and atomically rewrites the file. The caller
+// must hold s.mu.
+func (s *fileJailStore) save() error {
+	now := time.Now()
+	entries := make([]JailEntry, 0, len(s.entries))
+	for ip, entry := range s.entries {
+		if !entry.ExpiresAt.After(now) {
+			delete(s.entries, ip)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sortJailEntries(entries)
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// sortJailEntries orders entries by jail time, oldest first.
+func sortJailEntries(entries []JailEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].JailedAt.Before(entries[j].JailedAt)
+	})
+}
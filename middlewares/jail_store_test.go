@@ -0,0 +1,95 @@
+package middlewares_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr/middlewares"
+)
+
+func TestJailStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) middlewares.JailStore{
+		"memory": func(t *testing.T) middlewares.JailStore {
+			return middlewares.NewMemoryJailStore()
+		},
+		"file": func(t *testing.T) middlewares.JailStore {
+			store, err := middlewares.NewFileJailStore(filepath.Join(t.TempDir(), "jail.json"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			now := time.Now()
+
+			if err := store.Jail(ctx, middlewares.JailEntry{IP: "192.0.2.1", Reason: "test", JailedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Jail(ctx, middlewares.JailEntry{IP: "192.0.2.2", JailedAt: now, ExpiresAt: now.Add(-time.Second)}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if jailed, _ := store.IsJailed(ctx, "192.0.2.1"); !jailed {
+				t.Error("Expected 192.0.2.1 to be jailed")
+			}
+			if jailed, _ := store.IsJailed(ctx, "192.0.2.2"); jailed {
+				t.Error("Expected expired entry not to be jailed")
+			}
+
+			entries, err := store.List(ctx)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(entries) != 1 || entries[0].IP != "192.0.2.1" || entries[0].Reason != "test" {
+				t.Errorf("Unexpected entries: %+v", entries)
+			}
+
+			if err := store.Unjail(ctx, "192.0.2.1"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if jailed, _ := store.IsJailed(ctx, "192.0.2.1"); jailed {
+				t.Error("Expected 192.0.2.1 to be unjailed")
+			}
+			if err := store.Unjail(ctx, "192.0.2.99"); err != nil {
+				t.Errorf("Expected unjailing unknown IP to succeed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFileJailStore_SurvivesRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "jail.json")
+
+	store, err := middlewares.NewFileJailStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Now()
+	if err := store.Jail(ctx, middlewares.JailEntry{IP: "192.0.2.1", JailedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reopened, err := middlewares.NewFileJailStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if jailed, _ := reopened.IsJailed(ctx, "192.0.2.1"); !jailed {
+		t.Error("Expected jail entry to survive restart")
+	}
+
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := middlewares.NewFileJailStore(path); err == nil {
+		t.Error("Expected error for corrupt jail file")
+	}
+}
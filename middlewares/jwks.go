@@ -0,0 +1,299 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JWKSConfig configures a JSON Web Key Set loaded by NewJWKSKeySet.
+type JWKSConfig struct {
+	// URL is the address of the key set, e.g.
+	// "https://issuer.example.com/.well-known/jwks.json". Either URL or File
+	// is required.
+	URL string
+	// File is the path of a local key set file.
+	File string
+	// HTTPClient fetches URL. Optional; defaults to a client with a 10 second
+	// timeout.
+	HTTPClient *http.Client
+	// RefreshInterval is how often the key set is reloaded to pick up rotated
+	// keys. Optional; defaults to one hour.
+	RefreshInterval time.Duration
+	// MinRefreshInterval limits how often a token with an unknown kid may
+	// trigger an early reload. Optional; defaults to one minute.
+	MinRefreshInterval time.Duration
+	// RefreshTimeout limits reloads triggered by JWTKey, which do not use
+	// the context of the request that triggered them. Optional; defaults to
+	// 10 seconds.
+	RefreshTimeout time.Duration
+}
+
+// JWKSKeySet is a JWTKeySet backed by a JSON Web Key Set (RFC 7517). It
+// supports RSA, P-256 EC and symmetric ("oct") keys and handles key rotation
+// by reloading the set periodically and whenever a token names an unknown
+// kid. When a reload fails, the previously loaded keys stay in use.
+type JWKSKeySet struct {
+	config      JWKSConfig
+	keys        map[string]any
+	loadedAt    time.Time
+	lastAttempt time.Time
+	mu          sync.Mutex
+	// refreshes coalesces the reloads triggered by JWTKey.
+	refreshes singleflight.Group
+}
+
+// NewJWKSKeySet loads the key set described by config and returns it. It
+// fails if the initial load fails.
+func NewJWKSKeySet(ctx context.Context, config JWKSConfig) (*JWKSKeySet, error) {
+	if config.URL == "" && config.File == "" {
+		return nil, errors.New("jwks: URL or File is required")
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = time.Hour
+	}
+	if config.MinRefreshInterval <= 0 {
+		config.MinRefreshInterval = time.Minute
+	}
+	if config.RefreshTimeout <= 0 {
+		config.RefreshTimeout = 10 * time.Second
+	}
+
+	ks := &JWKSKeySet{config: config}
+	if err := ks.refresh(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// JWTKey returns the key for kid, reloading the set if it is stale or the
+// kid is unknown. A token without a kid matches the only key of a compatible
+// type, if there is exactly one.
+//
+// Stale sets are reloaded in the background while the loaded keys stay in
+// use. Only requests with an unknown kid wait for the reload, which is
+// shared between them and is not canceled with their context.
+func (ks *JWKSKeySet) JWTKey(ctx context.Context, kid, alg string) (any, error) {
+	ks.mu.Lock()
+	key, found := ks.lookup(kid, alg)
+	stale := time.Since(ks.loadedAt) >= ks.config.RefreshInterval &&
+		time.Since(ks.lastAttempt) >= ks.config.MinRefreshInterval
+	ks.mu.Unlock()
+
+	if found {
+		if stale {
+			ks.refreshShared(ctx)
+		}
+		return key, nil
+	}
+
+	select {
+	case <-ks.refreshShared(ctx):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key, ok := ks.lookup(kid, alg); ok {
+		return key, nil
+	}
+	return nil, ErrJWTKeyNotFound
+}
+
+// Refresh reloads the key set immediately.
+func (ks *JWKSKeySet) Refresh(ctx context.Context) error {
+	return ks.refresh(ctx, time.Now())
+}
+
+// lookup finds the key for kid and alg. The caller must hold ks.mu.
+func (ks *JWKSKeySet) lookup(kid, alg string) (any, bool) {
+	if kid != "" {
+		key, ok := ks.keys[kid]
+		if !ok || !jwtKeyMatchesAlg(key, alg) {
+			return nil, false
+		}
+		return key, true
+	}
+
+	var found any
+	for _, key := range ks.keys {
+		if jwtKeyMatchesAlg(key, alg) {
+			if found != nil {
+				return nil, false
+			}
+			found = key
+		}
+	}
+	return found, found != nil
+}
+
+// refreshShared starts a reload, or joins the one in progress, returning a
+// channel closed when it is done. It does nothing if the last attempt was
+// less than MinRefreshInterval ago. The reload runs detached from ctx with
+// RefreshTimeout, and failures are logged.
+func (ks *JWKSKeySet) refreshShared(ctx context.Context) <-chan singleflight.Result {
+	return ks.refreshes.DoChan("", func() (any, error) {
+		ks.mu.Lock()
+		recent := time.Since(ks.lastAttempt) < ks.config.MinRefreshInterval
+		ks.mu.Unlock()
+		if recent {
+			return nil, nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), ks.config.RefreshTimeout)
+		defer cancel()
+		if err := ks.refresh(ctx, time.Now()); err != nil {
+			slog.Default().Error("jwks: refresh failed", "error", err.Error())
+		}
+		return nil, nil
+	})
+}
+
+// refresh reloads the key set. The key set is fetched without holding
+// ks.mu, so requests with known keys are not held up.
+func (ks *JWKSKeySet) refresh(ctx context.Context, now time.Time) error {
+	data, err := ks.fetch(ctx)
+	var keys map[string]any
+	if err == nil {
+		keys, err = parseJWKS(data)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.lastAttempt = now
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	ks.loadedAt = now
+	return nil
+}
+
+// fetch reads the raw key set from the configured file or URL.
+func (ks *JWKSKeySet) fetch(ctx context.Context) ([]byte, error) {
+	if ks.config.File != "" {
+		return os.ReadFile(ks.config.File)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ks.config.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("jwks: unexpected status %d from %s", resp.StatusCode, ks.config.URL)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// jwk is a single JSON Web Key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// parseJWKS decodes a key set into keys by kid. Encryption keys and keys of
+// unsupported types are skipped.
+func parseJWKS(data []byte) (map[string]any, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Default().Warn("jwks: skipping key", "kid", k.Kid, "error", err.Error())
+			continue
+		}
+		if key == nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// publicKey converts the JWK to a key usable by verifyJWTSignature. It
+// returns nil for unsupported key types.
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA key")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, nil
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid EC key")
+		}
+		uncompressed := append(append([]byte{4}, x...), y...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), uncompressed)
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, err
+		}
+		return secret, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,297 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Supported JWT signing algorithms.
+const (
+	JWTAlgHS256 = "HS256"
+	JWTAlgRS256 = "RS256"
+	JWTAlgES256 = "ES256"
+)
+
+// Errors returned when a JWT is rejected. They are passed to
+// JWTConfig.OnError and can be matched with errors.Is.
+var (
+	ErrJWTMissing              = errors.New("jwt: token missing")
+	ErrJWTMalformed            = errors.New("jwt: token malformed")
+	ErrJWTUnsupportedAlgorithm = errors.New("jwt: unsupported algorithm")
+	ErrJWTKeyNotFound          = errors.New("jwt: signing key not found")
+	ErrJWTInvalidSignature     = errors.New("jwt: invalid signature")
+	ErrJWTExpired              = errors.New("jwt: token expired")
+	ErrJWTNotYetValid          = errors.New("jwt: token not valid yet")
+	ErrJWTInvalidIssuer        = errors.New("jwt: invalid issuer")
+	ErrJWTInvalidAudience      = errors.New("jwt: invalid audience")
+)
+
+// JWTClaims holds the claims of a verified token. The registered claims are
+// decoded into fields; Raw holds every claim, including custom ones.
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+	Raw       map[string]any
+}
+
+// String returns the custom claim name as a string, or "" if it is missing or
+// not a string.
+func (c *JWTClaims) String(name string) string {
+	s, _ := c.Raw[name].(string)
+	return s
+}
+
+// Strings returns the custom claim name as a string slice. A single string
+// claim is returned as a one-element slice; space-separated values (as in the
+// OAuth "scope" claim) are not split.
+func (c *JWTClaims) Strings(name string) []string {
+	switch v := c.Raw[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// JWTKeySet resolves the key that verifies a token, given the kid and alg
+// from its header. HS256 keys are []byte, RS256 keys *rsa.PublicKey and ES256
+// keys *ecdsa.PublicKey.
+type JWTKeySet interface {
+	JWTKey(ctx context.Context, kid, alg string) (any, error)
+}
+
+// NewStaticJWTKeySet returns a key set that verifies every token with key,
+// whatever its kid. Use it for a single shared secret or public key.
+func NewStaticJWTKeySet(key any) JWTKeySet {
+	return staticJWTKeySet{key: key}
+}
+
+type staticJWTKeySet struct {
+	key any
+}
+
+func (s staticJWTKeySet) JWTKey(ctx context.Context, kid, alg string) (any, error) {
+	if !jwtKeyMatchesAlg(s.key, alg) {
+		return nil, ErrJWTKeyNotFound
+	}
+	return s.key, nil
+}
+
+// jwtHeader is the decoded JOSE header of a token.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// jwtValidation holds the claim checks applied by verifyJWT.
+type jwtValidation struct {
+	algorithms        []string
+	issuer            []string
+	audience          []string
+	clockSkew         time.Duration
+	requireExpiration bool
+}
+
+// verifyJWT checks the signature and registered claims of token and returns
+// its claims.
+func verifyJWT(ctx context.Context, token string, keys JWTKeySet, v jwtValidation, now time.Time) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTMalformed
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if !slices.Contains(v.algorithms, header.Alg) {
+		return nil, fmt.Errorf("%w: %q", ErrJWTUnsupportedAlgorithm, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	key, err := keys.JWTKey(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrJWTMalformed
+	}
+	claims, err := decodeJWTClaims(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.ExpiresAt.IsZero() && !now.Before(claims.ExpiresAt.Add(v.clockSkew)) {
+		return nil, ErrJWTExpired
+	}
+	if claims.ExpiresAt.IsZero() && v.requireExpiration {
+		return nil, fmt.Errorf("%w: missing exp claim", ErrJWTExpired)
+	}
+	if !claims.NotBefore.IsZero() && now.Add(v.clockSkew).Before(claims.NotBefore) {
+		return nil, ErrJWTNotYetValid
+	}
+	if len(v.issuer) > 0 && !slices.Contains(v.issuer, claims.Issuer) {
+		return nil, ErrJWTInvalidIssuer
+	}
+	if len(v.audience) > 0 && !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return slices.Contains(v.audience, aud)
+	}) {
+		return nil, ErrJWTInvalidAudience
+	}
+
+	return claims, nil
+}
+
+// verifyJWTSignature checks signature over signingInput with key. The key
+// type must match alg, so an RSA public key can never be used as an HMAC
+// secret.
+func verifyJWTSignature(alg string, key any, signingInput string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signingInput))
+
+	switch alg {
+	case JWTAlgHS256:
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return ErrJWTKeyNotFound
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signingInput))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return ErrJWTInvalidSignature
+		}
+	case JWTAlgRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrJWTKeyNotFound
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) != nil {
+			return ErrJWTInvalidSignature
+		}
+	case JWTAlgES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return ErrJWTKeyNotFound
+		}
+		if len(signature) != 64 {
+			return ErrJWTInvalidSignature
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrJWTInvalidSignature
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrJWTUnsupportedAlgorithm, alg)
+	}
+
+	return nil
+}
+
+// jwtKeyMatchesAlg reports whether key can verify tokens signed with alg.
+func jwtKeyMatchesAlg(key any, alg string) bool {
+	switch k := key.(type) {
+	case []byte:
+		return alg == JWTAlgHS256
+	case *rsa.PublicKey:
+		return alg == JWTAlgRS256
+	case *ecdsa.PublicKey:
+		return alg == JWTAlgES256 && k.Curve == elliptic.P256()
+	}
+	return false
+}
+
+// decodeJWTClaims decodes the token payload into JWTClaims.
+func decodeJWTClaims(payload []byte) (*JWTClaims, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+
+	var raw map[string]any
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, ErrJWTMalformed
+	}
+
+	claims := &JWTClaims{Raw: raw}
+	claims.Issuer, _ = raw["iss"].(string)
+	claims.Subject, _ = raw["sub"].(string)
+	claims.ID, _ = raw["jti"].(string)
+	claims.Audience = claims.Strings("aud")
+
+	var err error
+	if claims.ExpiresAt, err = jwtNumericDate(raw, "exp"); err != nil {
+		return nil, err
+	}
+	if claims.NotBefore, err = jwtNumericDate(raw, "nbf"); err != nil {
+		return nil, err
+	}
+	if claims.IssuedAt, err = jwtNumericDate(raw, "iat"); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// jwtMaxNumericDate is the largest NumericDate accepted, the end of the
+// year 9999. Larger values are rejected as malformed rather than overflowing.
+const jwtMaxNumericDate = 253402300799
+
+// jwtNumericDate decodes the NumericDate claim name, returning the zero time
+// if it is absent.
+func jwtNumericDate(raw map[string]any, name string) (time.Time, error) {
+	value, ok := raw[name]
+	if !ok {
+		return time.Time{}, nil
+	}
+	number, ok := value.(json.Number)
+	if !ok {
+		return time.Time{}, fmt.Errorf("%w: %s is not a number", ErrJWTMalformed, name)
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s is not a number", ErrJWTMalformed, name)
+	}
+	if math.IsNaN(seconds) || math.Abs(seconds) > jwtMaxNumericDate {
+		return time.Time{}, fmt.Errorf("%w: %s is out of range", ErrJWTMalformed, name)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
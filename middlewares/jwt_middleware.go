@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// jwtClaimsKey is the context key used to store the verified JWT claims.
+type jwtClaimsKey struct{}
+
+// JWTConfig configures JWTMiddleware.
+type JWTConfig struct {
+	// Secret is the shared HS256 secret. Exactly one of Secret, PublicKey or
+	// KeySet is required.
+	Secret []byte
+	// PublicKey is an *rsa.PublicKey (RS256) or P-256 *ecdsa.PublicKey
+	// (ES256).
+	PublicKey crypto.PublicKey
+	// KeySet resolves keys by kid, e.g. a JWKSKeySet.
+	KeySet JWTKeySet
+
+	// Algorithms lists the accepted signing algorithms. Optional; defaults to
+	// HS256, RS256 and ES256. The key type must always match the algorithm.
+	Algorithms []string
+	// Issuer lists the accepted iss values. Optional; not checked if empty.
+	Issuer []string
+	// Audience lists the accepted aud values; the token must contain at least
+	// one of them. Optional; not checked if empty.
+	Audience []string
+	// ClockSkew is the leeway allowed when checking exp and nbf. Optional.
+	ClockSkew time.Duration
+	// RequireExpiration rejects tokens without an exp claim.
+	RequireExpiration bool
+
+	// Optional lets requests without a token through unauthenticated.
+	// Requests with an invalid token are still rejected.
+	Optional bool
+	// TokenLookup extracts the token from the request. Optional; defaults to
+	// the "Authorization: Bearer <token>" header.
+	TokenLookup func(r *http.Request) string
+
+	// ContextKeyUser, together with MapUser, stores the user resolved from
+	// the claims under this key, following the AuthMiddleware convention so
+	// that UserMiddleware and handlers keep working with JWT clients.
+	ContextKeyUser any
+	// MapUser resolves the user for the verified claims. Returning an error
+	// rejects the request; returning nil stores no user.
+	MapUser func(ctx context.Context, claims *JWTClaims) (any, error)
+
+	// OnError is called when a request is rejected. Optional; defaults to
+	// 401 Unauthorized with a WWW-Authenticate header.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+}
+
+// JWTMiddleware returns a middleware that authenticates requests carrying a
+// JSON Web Token as a bearer token. It verifies the signature (HS256, RS256
+// or ES256) and the exp, nbf, iss and aud claims, then stores the claims in
+// the request context (see GetJWTClaims) and, if MapUser is set, the user
+// under ContextKeyUser.
+//
+// If config sets none or more than one of Secret, PublicKey and KeySet, or
+// MapUser without ContextKeyUser, every request is answered with 500 Internal
+// Server Error describing the problem.
+func JWTMiddleware(config JWTConfig) rtr.MiddlewareInterface {
+	keySources := 0
+	for _, set := range []bool{len(config.Secret) > 0, config.PublicKey != nil, config.KeySet != nil} {
+		if set {
+			keySources++
+		}
+	}
+
+	var configErr string
+	switch {
+	case keySources != 1:
+		configErr = "jwt middleware: exactly one of Secret, PublicKey or KeySet is required"
+	case config.MapUser != nil && config.ContextKeyUser == nil:
+		configErr = "jwt middleware: ContextKeyUser is required with MapUser"
+	}
+
+	keys := config.KeySet
+	switch {
+	case len(config.Secret) > 0:
+		keys = NewStaticJWTKeySet(config.Secret)
+	case config.PublicKey != nil:
+		keys = NewStaticJWTKeySet(config.PublicKey)
+	}
+
+	if len(config.Algorithms) == 0 {
+		config.Algorithms = []string{JWTAlgHS256, JWTAlgRS256, JWTAlgES256}
+	}
+	if config.TokenLookup == nil {
+		config.TokenLookup = bearerToken
+	}
+	if config.OnError == nil {
+		config.OnError = jwtUnauthorized
+	}
+
+	validation := jwtValidation{
+		algorithms:        config.Algorithms,
+		issuer:            config.Issuer,
+		audience:          config.Audience,
+		clockSkew:         config.ClockSkew,
+		requireExpiration: config.RequireExpiration,
+	}
+
+	return rtr.NewMiddleware().
+		SetName("JWT Middleware").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if configErr != "" {
+					http.Error(w, configErr, http.StatusInternalServerError)
+					return
+				}
+
+				token := config.TokenLookup(r)
+				if token == "" {
+					if config.Optional {
+						next.ServeHTTP(w, r)
+						return
+					}
+					config.OnError(w, r, ErrJWTMissing)
+					return
+				}
+
+				claims, err := verifyJWT(r.Context(), token, keys, validation, time.Now())
+				if err != nil {
+					config.OnError(w, r, err)
+					return
+				}
+
+				ctx := context.WithValue(r.Context(), jwtClaimsKey{}, claims)
+
+				if config.MapUser != nil {
+					user, err := config.MapUser(ctx, claims)
+					if err != nil {
+						config.OnError(w, r, err)
+						return
+					}
+					if !isNilInterface(user) {
+						ctx = context.WithValue(ctx, config.ContextKeyUser, user)
+					}
+				}
+
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+}
+
+// GetJWTClaims returns the claims stored by JWTMiddleware, or nil if the
+// request was not authenticated with a JWT.
+func GetJWTClaims(ctx context.Context) *JWTClaims {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(*JWTClaims)
+	return claims
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// jwtUnauthorized is the default JWTConfig.OnError. It follows RFC 6750,
+// flagging rejected tokens as invalid_token.
+func jwtUnauthorized(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrJWTMissing) {
+		w.Header().Set("WWW-Authenticate", `Bearer`)
+	} else {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -0,0 +1,406 @@
+package middlewares_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr/middlewares"
+)
+
+// signJWT builds a compact JWT signed with key using alg.
+func signJWT(t *testing.T, alg string, key any, kid string, claims map[string]any) string {
+	t.Helper()
+
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signingInput))
+		signature = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	default:
+		t.Fatalf("unsupported key type %T", key)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// rsaJWK returns the public JWK for key.
+func rsaJWK(kid string, key *rsa.PrivateKey) map[string]any {
+	return map[string]any{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func jwtRequest(handler http.Handler, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"sub": "user-1",
+		"iss": "https://issuer.example.com",
+		"aud": "api",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestJWTMiddleware_Algorithms(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	secret := []byte("top-secret")
+
+	tests := []struct {
+		name   string
+		config middlewares.JWTConfig
+		token  string
+	}{
+		{"HS256", middlewares.JWTConfig{Secret: secret}, signJWT(t, "HS256", secret, "", validClaims())},
+		{"RS256", middlewares.JWTConfig{PublicKey: &rsaKey.PublicKey}, signJWT(t, "RS256", rsaKey, "", validClaims())},
+		{"ES256", middlewares.JWTConfig{PublicKey: &ecKey.PublicKey}, signJWT(t, "ES256", ecKey, "", validClaims())},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var subject string
+			handler := middlewares.JWTMiddleware(tt.config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				subject = middlewares.GetJWTClaims(r.Context()).Subject
+			}))
+
+			if rr := jwtRequest(handler, tt.token); rr.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d", rr.Code)
+			}
+			if subject != "user-1" {
+				t.Errorf("Expected subject user-1 in context, got %q", subject)
+			}
+
+			tampered := tt.token[:len(tt.token)-4] + "AAAA"
+			if rr := jwtRequest(handler, tampered); rr.Code != http.StatusUnauthorized {
+				t.Errorf("Expected status 401 for a tampered token, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestJWTMiddleware_RejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	handler := middlewares.JWTMiddleware(middlewares.JWTConfig{PublicKey: &rsaKey.PublicKey}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// An HS256 token "signed" with the public key bytes must not verify.
+	token := signJWT(t, "HS256", rsaKey.N.Bytes(), "", validClaims())
+	if rr := jwtRequest(handler, token); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", rr.Code)
+	}
+
+	none := "eyJhbGciOiJub25lIn0." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"x"}`)) + "."
+	if rr := jwtRequest(handler, none); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for alg none, got %d", rr.Code)
+	}
+}
+
+func TestJWTMiddleware_Claims(t *testing.T) {
+	secret := []byte("top-secret")
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		modify   func(claims map[string]any)
+		expected error
+	}{
+		{"valid", func(c map[string]any) {}, nil},
+		{"expired", func(c map[string]any) { c["exp"] = now.Add(-time.Minute).Unix() }, middlewares.ErrJWTExpired},
+		{"expired within skew", func(c map[string]any) { c["exp"] = now.Add(-10 * time.Second).Unix() }, nil},
+		{"missing exp", func(c map[string]any) { delete(c, "exp") }, middlewares.ErrJWTExpired},
+		{"not yet valid", func(c map[string]any) { c["nbf"] = now.Add(time.Minute).Unix() }, middlewares.ErrJWTNotYetValid},
+		{"not yet valid within skew", func(c map[string]any) { c["nbf"] = now.Add(10 * time.Second).Unix() }, nil},
+		{"not valid for centuries", func(c map[string]any) { c["nbf"] = 1e11 }, middlewares.ErrJWTNotYetValid},
+		{"expires in centuries", func(c map[string]any) { c["exp"] = 1e11 }, nil},
+		{"fractional exp", func(c map[string]any) { c["exp"] = float64(now.Add(time.Hour).Unix()) + 0.5 }, nil},
+		{"out of range nbf", func(c map[string]any) { c["nbf"] = 1e300 }, middlewares.ErrJWTMalformed},
+		{"out of range exp", func(c map[string]any) { c["exp"] = -1e19 }, middlewares.ErrJWTMalformed},
+		{"wrong issuer", func(c map[string]any) { c["iss"] = "https://evil.example.com" }, middlewares.ErrJWTInvalidIssuer},
+		{"wrong audience", func(c map[string]any) { c["aud"] = "other" }, middlewares.ErrJWTInvalidAudience},
+		{"audience list", func(c map[string]any) { c["aud"] = []string{"other", "api"} }, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotErr error
+			handler := middlewares.JWTMiddleware(middlewares.JWTConfig{
+				Secret:            secret,
+				Issuer:            []string{"https://issuer.example.com"},
+				Audience:          []string{"api"},
+				ClockSkew:         30 * time.Second,
+				RequireExpiration: true,
+				OnError: func(w http.ResponseWriter, r *http.Request, err error) {
+					gotErr = err
+					w.WriteHeader(http.StatusUnauthorized)
+				},
+			}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			claims := validClaims()
+			tt.modify(claims)
+			jwtRequest(handler, signJWT(t, "HS256", secret, "", claims))
+
+			if tt.expected == nil && gotErr != nil {
+				t.Errorf("Expected token to be accepted, got %v", gotErr)
+			}
+			if tt.expected != nil && !errors.Is(gotErr, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, gotErr)
+			}
+		})
+	}
+}
+
+func TestJWTMiddleware_MissingToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	rr := jwtRequest(middlewares.JWTMiddleware(middlewares.JWTConfig{Secret: []byte("s")}).GetHandler()(ok), "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", rr.Code)
+	}
+	if got := rr.Header().Get("WWW-Authenticate"); got != "Bearer" {
+		t.Errorf("Expected WWW-Authenticate Bearer, got %q", got)
+	}
+
+	rr = jwtRequest(middlewares.JWTMiddleware(middlewares.JWTConfig{Secret: []byte("s"), Optional: true}).GetHandler()(ok), "")
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected optional middleware to pass through, got %d", rr.Code)
+	}
+}
+
+func TestJWTMiddleware_ConfigError(t *testing.T) {
+	handler := middlewares.JWTMiddleware(middlewares.JWTConfig{}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if rr := jwtRequest(handler, "x"); rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status 500, got %d", rr.Code)
+	}
+}
+
+type jwtTestUser struct{ id string }
+
+func (u *jwtTestUser) IsActive() bool                { return true }
+func (u *jwtTestUser) IsRegistrationCompleted() bool { return true }
+
+func TestJWTMiddleware_MapUserWithUserMiddleware(t *testing.T) {
+	type ctxKey struct{}
+	secret := []byte("top-secret")
+
+	jwtMiddleware := middlewares.JWTMiddleware(middlewares.JWTConfig{
+		Secret:         secret,
+		ContextKeyUser: ctxKey{},
+		MapUser: func(ctx context.Context, claims *middlewares.JWTClaims) (any, error) {
+			if claims.Subject == "banned" {
+				return nil, errors.New("banned")
+			}
+			return &jwtTestUser{id: claims.Subject}, nil
+		},
+	})
+	userMiddleware := middlewares.UserMiddleware(middlewares.UserMiddlewareConfig{
+		GetUser: func(r *http.Request) middlewares.UserMiddlewareUser {
+			user, _ := r.Context().Value(ctxKey{}).(*jwtTestUser)
+			if user == nil {
+				return nil
+			}
+			return user
+		},
+	})
+
+	var userID string
+	handler := jwtMiddleware.GetHandler()(userMiddleware.GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID = r.Context().Value(ctxKey{}).(*jwtTestUser).id
+	})))
+
+	if rr := jwtRequest(handler, signJWT(t, "HS256", secret, "", validClaims())); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if userID != "user-1" {
+		t.Errorf("Expected mapped user user-1, got %q", userID)
+	}
+
+	claims := validClaims()
+	claims["sub"] = "banned"
+	if rr := jwtRequest(handler, signJWT(t, "HS256", secret, "", claims)); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 when MapUser fails, got %d", rr.Code)
+	}
+}
+
+func TestJWKSKeySet_URLRotation(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var mu sync.Mutex
+	jwks := []any{rsaJWK("old", oldKey)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": jwks})
+	}))
+	defer server.Close()
+
+	keySet, err := middlewares.NewJWKSKeySet(context.Background(), middlewares.JWKSConfig{
+		URL:                server.URL,
+		MinRefreshInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("NewJWKSKeySet failed: %v", err)
+	}
+
+	handler := middlewares.JWTMiddleware(middlewares.JWTConfig{KeySet: keySet}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if rr := jwtRequest(handler, signJWT(t, "RS256", oldKey, "old", validClaims())); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the old key, got %d", rr.Code)
+	}
+
+	mu.Lock()
+	jwks = []any{rsaJWK("new", newKey)}
+	mu.Unlock()
+
+	if rr := jwtRequest(handler, signJWT(t, "RS256", newKey, "new", validClaims())); rr.Code != http.StatusOK {
+		t.Errorf("Expected the rotated key to be picked up, got %d", rr.Code)
+	}
+	if rr := jwtRequest(handler, signJWT(t, "RS256", oldKey, "old", validClaims())); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the retired key to be rejected, got %d", rr.Code)
+	}
+}
+
+func TestJWKSKeySet_RefreshDoesNotBlock(t *testing.T) {
+	oldKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []any{rsaJWK("old", oldKey)}
+		if fetches.Add(1) > 1 {
+			<-release
+			keys = append(keys, rsaJWK("new", newKey))
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": keys})
+	}))
+	defer server.Close()
+
+	keySet, err := middlewares.NewJWKSKeySet(context.Background(), middlewares.JWKSConfig{
+		URL:                server.URL,
+		MinRefreshInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("NewJWKSKeySet failed: %v", err)
+	}
+
+	// A request with an unknown kid gives up, but its refresh goes on
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := keySet.JWTKey(ctx, "new", "RS256"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the caller's deadline to end the wait, got %v", err)
+	}
+
+	start := time.Now()
+	if _, err := keySet.JWTKey(context.Background(), "old", "RS256"); err != nil {
+		t.Fatalf("Expected the known key, got %v", err)
+	}
+	if time.Since(start) > 20*time.Millisecond {
+		t.Errorf("Expected known keys not to wait for the refresh, took %s", time.Since(start))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := keySet.JWTKey(context.Background(), "new", "RS256")
+		done <- err
+	}()
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("Expected the refreshed key, got %v", err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("Expected waiting requests to share one refresh, got %d fetches", n)
+	}
+}
+
+func TestJWKSKeySet_File(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	jwks := map[string]any{"keys": []any{
+		map[string]any{
+			"kty": "EC",
+			"kid": "ec",
+			"crv": "P-256",
+			"x":   base64.RawURLEncoding.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			"y":   base64.RawURLEncoding.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+		},
+		map[string]any{"kty": "oct", "kid": "hmac", "k": base64.RawURLEncoding.EncodeToString([]byte("secret"))},
+	}}
+	data, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keySet, err := middlewares.NewJWKSKeySet(context.Background(), middlewares.JWKSConfig{File: path})
+	if err != nil {
+		t.Fatalf("NewJWKSKeySet failed: %v", err)
+	}
+
+	handler := middlewares.JWTMiddleware(middlewares.JWTConfig{KeySet: keySet}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	if rr := jwtRequest(handler, signJWT(t, "ES256", ecKey, "ec", validClaims())); rr.Code != http.StatusOK {
+		t.Errorf("Expected ES256 token to verify, got %d", rr.Code)
+	}
+	if rr := jwtRequest(handler, signJWT(t, "HS256", []byte("secret"), "hmac", validClaims())); rr.Code != http.StatusOK {
+		t.Errorf("Expected HS256 token to verify, got %d", rr.Code)
+	}
+	if rr := jwtRequest(handler, signJWT(t, "HS256", []byte("secret"), "ec", validClaims())); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a kid/alg mismatch to be rejected, got %d", rr.Code)
+	}
+
+	if _, err := middlewares.NewJWKSKeySet(context.Background(), middlewares.JWKSConfig{File: filepath.Join(t.TempDir(), "missing.json")}); err == nil {
+		t.Error("Expected an error for a missing key set file")
+	}
+}
@@ -12,7 +12,7 @@ import (
 // their /64 prefix.
 // This is a stdlib-only reimplementation of go-chi/httprate's LimitByIP.
 func RateLimitByIPMiddleware(maxRequests int, seconds int) rtr.MiddlewareInterface {
-	rl := newRateLimiter(maxRequests, time.Duration(seconds)*time.Second, keyByIP)
+	rl := newRateLimiter(maxRequests, time.Duration(seconds)*time.Second, KeyByIP, nil)
 	return rtr.NewMiddleware().
 		SetName("Rate Limit By IP").
 		SetHandler(rl.handler)
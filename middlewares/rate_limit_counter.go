@@ -0,0 +1,175 @@
+package middlewares
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LimitCounter stores the per-key request counts used by the sliding-window
+// rate limiter. Implementations must be safe for concurrent use. The interface
+// mirrors go-chi/httprate's LimitCounter so that counters backed by a shared
+// store (e.g. Redis) can be plugged in when running more than one replica.
+type LimitCounter interface {
+	// Config is called once by the rate limiter before the counter is used.
+	Config(requestLimit int, windowLength time.Duration)
+	// IncrementBy adds amount to the counter for key in the window starting at
+	// currentWindow.
+	IncrementBy(key string, currentWindow time.Time, amount int) error
+	// Get returns the counts for key in the current and previous windows.
+	Get(key string, currentWindow, previousWindow time.Time) (int, int, error)
+}
+
+// KeyValueStore is the minimal key-value contract needed by
+// NewKeyValueLimitCounter. A Redis client satisfies it with INCRBY followed by
+// EXPIRE (ideally pipelined) and GET. Implementations must be safe for
+// concurrent use.
+type KeyValueStore interface {
+	// IncrBy atomically adds amount to the integer stored at key, creating it
+	// with the given ttl if it does not exist, and returns the new value.
+	IncrBy(key string, amount int64, ttl time.Duration) (int64, error)
+	// Get returns the integer stored at key, or 0 and a nil error if the key
+	// does not exist.
+	Get(key string) (int64, error)
+}
+
+// NewMemoryLimitCounter returns the in-process LimitCounter used by default.
+// Counts are not shared between processes.
+func NewMemoryLimitCounter() LimitCounter {
+	return &limitCounter{
+		latestWindow:     time.Now().UTC(),
+		latestCounters:   make(map[string]int),
+		previousCounters: make(map[string]int),
+	}
+}
+
+// NewKeyValueLimitCounter returns a LimitCounter that keeps its counts in the
+// given KeyValueStore, so that several replicas can share one limit. Keys are
+// stored as prefix + key + ":" + window length + ":" + window start (Unix
+// nanoseconds) and expire after two window lengths, so one counter may be
+// used by several tiers of a RateLimitMiddleware.
+func NewKeyValueLimitCounter(store KeyValueStore, prefix string) LimitCounter {
+	return &keyValueLimitCounter{
+		store:  store,
+		prefix: prefix,
+	}
+}
+
+// limitCounter is an in-memory counter with two buckets (current and previous
+// window). It mirrors go-chi/httprate's localCounter.
+type limitCounter struct {
+	windowLength     time.Duration
+	latestWindow     time.Time
+	latestCounters   map[string]int
+	previousCounters map[string]int
+	mu               sync.RWMutex
+}
+
+var _ LimitCounter = (*limitCounter)(nil)
+
+// Config sets the window length used to shift the buckets.
+func (c *limitCounter) Config(requestLimit int, windowLength time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.windowLength = windowLength
+}
+
+// IncrementBy adds amount to the counter for the given key in the current
+// window.
+func (c *limitCounter) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evict(currentWindow)
+
+	count := c.latestCounters[key]
+	c.latestCounters[key] = count + amount
+	return nil
+}
+
+// Get returns the counts for the current and previous windows for the given
+// key.
+func (c *limitCounter) Get(key string, currentWindow, previousWindow time.Time) (int, int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.latestWindow.Equal(currentWindow) {
+		curr := c.latestCounters[key]
+		prev := c.previousCounters[key]
+		return curr, prev, nil
+	}
+
+	if c.latestWindow.Equal(previousWindow) {
+		prev := c.latestCounters[key]
+		return 0, prev, nil
+	}
+
+	return 0, 0, nil
+}
+
+// evict shifts or clears the windows when the current window has advanced.
+func (c *limitCounter) evict(currentWindow time.Time) {
+	if c.latestWindow.Equal(currentWindow) {
+		return
+	}
+
+	previousWindow := currentWindow.Add(-c.windowLength)
+	if c.latestWindow.Equal(previousWindow) {
+		c.latestWindow = currentWindow
+		// Shift the windows without map re-allocation.
+		clear(c.previousCounters)
+		c.latestCounters, c.previousCounters = c.previousCounters, c.latestCounters
+		return
+	}
+
+	c.latestWindow = currentWindow
+	clear(c.previousCounters)
+	clear(c.latestCounters)
+}
+
+// keyValueLimitCounter is a LimitCounter backed by a KeyValueStore. Each
+// (key, window) pair is stored under its own store key, so no local state is
+// kept and windows never need to be shifted.
+type keyValueLimitCounter struct {
+	store        KeyValueStore
+	prefix       string
+	windowLength time.Duration
+}
+
+var _ LimitCounter = (*keyValueLimitCounter)(nil)
+
+// Config records the window length used to compute key expiry.
+func (c *keyValueLimitCounter) Config(requestLimit int, windowLength time.Duration) {
+	c.windowLength = windowLength
+}
+
+// IncrementBy adds amount to the store key for the current window.
+func (c *keyValueLimitCounter) IncrementBy(key string, currentWindow time.Time, amount int) error {
+	// Keep the key around for the following window too, where it is read as
+	// the previous window count.
+	_, err := c.store.IncrBy(c.storeKey(key, currentWindow), int64(amount), 2*c.windowLength)
+	return err
+}
+
+// Get reads the store keys for the current and previous windows.
+func (c *keyValueLimitCounter) Get(key string, currentWindow, previousWindow time.Time) (int, int, error) {
+	curr, err := c.store.Get(c.storeKey(key, currentWindow))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	prev, err := c.store.Get(c.storeKey(key, previousWindow))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return int(curr), int(prev), nil
+}
+
+// storeKey builds the store key for the given rate-limit key and window.
+func (c *keyValueLimitCounter) storeKey(key string, window time.Time) string {
+	// The window length keeps tiers sharing a store and prefix apart; windows
+	// of different lengths regularly start at the same instant.
+	return c.prefix + key + ":" + c.windowLength.String() + ":" + strconv.FormatInt(window.UnixNano(), 10)
+}
@@ -0,0 +1,158 @@
+package middlewares
+
+import (
+	"fmt"
+	"hash/maphash"
+	"net/http"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// RateLimitConfig configures RateLimitMiddleware.
+type RateLimitConfig struct {
+	// Requests is the number of requests allowed per Window. It is shorthand
+	// for a single entry in Limits and is ignored when Limits is set.
+	Requests int
+	// Window is the length of the sliding window used with Requests.
+	Window time.Duration
+	// Counter stores the request counts for Requests/Window. Optional;
+	// defaults to an in-memory counter. Use NewKeyValueLimitCounter to share
+	// limits between replicas.
+	Counter LimitCounter
+
+	// Limits are tiers evaluated together, e.g. 10 per second and 1000 per
+	// hour. A request is rejected if any tier is exhausted.
+	Limits []RateLimit
+
+	// RouteLimits replaces the tiers for requests whose matched route has the
+	// given name (see rtr.RouteInterface.SetName). Attach the middleware to a
+	// group to make, say, the "login" route far stricter than the rest of the
+	// group. Route limits are counted separately from the default tiers.
+	RouteLimits map[string][]RateLimit
+
+	// KeyFunc derives the key requests are counted under. Optional; defaults
+	// to KeyByIP. Use KeyByAll to combine KeyByIP, KeyByContextValue,
+	// KeyByHeader and KeyByRouteName.
+	KeyFunc RateLimitKeyFunc
+}
+
+// RateLimit is a single rate-limit tier.
+type RateLimit struct {
+	// Requests is the number of requests allowed per Window.
+	Requests int
+	// Window is the length of the sliding window.
+	Window time.Duration
+	// Counter stores the request counts. Optional; defaults to an in-memory
+	// counter. A counter from NewKeyValueLimitCounter may be shared by
+	// several tiers; any other counter must only be used by one.
+	Counter LimitCounter
+}
+
+// RateLimitMiddleware returns a sliding-window rate limiting middleware. By
+// default requests are keyed by client IP (see RateLimitByIPMiddleware) and
+// counted in memory.
+//
+// Every response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset headers for the tier closest to exhaustion; rejected
+// requests get 429 Too Many Requests with a Retry-After header. If a counter
+// returns an error the request is allowed through and the error is logged.
+//
+// If config uses the same counter, other than one from
+// NewKeyValueLimitCounter, for more than one tier, whose counts would mix,
+// every request is answered with 500 Internal Server Error describing the
+// problem.
+//
+// Example:
+//
+//	middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+//		Limits: []middlewares.RateLimit{
+//			{Requests: 10, Window: time.Second},
+//			{Requests: 1000, Window: time.Hour},
+//		},
+//		RouteLimits: map[string][]middlewares.RateLimit{
+//			"login": {{Requests: 5, Window: time.Minute}},
+//		},
+//		KeyFunc: middlewares.KeyByAll(middlewares.KeyByIP, middlewares.KeyByHeader("X-API-Key")),
+//	})
+func RateLimitMiddleware(config RateLimitConfig) rtr.MiddlewareInterface {
+	if err := checkRateLimitCounters(config); err != nil {
+		configErr := err.Error()
+		return rtr.NewMiddleware().
+			SetName("Rate Limit").
+			SetHandler(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					http.Error(w, configErr, http.StatusInternalServerError)
+				})
+			})
+	}
+
+	keyFn := config.KeyFunc
+	if keyFn == nil {
+		keyFn = KeyByIP
+	}
+
+	rl := &rateLimiter{
+		keyFn:  keyFn,
+		limits: newSlidingWindows(config.Limits),
+		seed:   maphash.MakeSeed(),
+	}
+	if len(config.Limits) == 0 {
+		rl.limits = []*slidingWindow{newSlidingWindow(config.Requests, config.Window, config.Counter)}
+	}
+
+	if len(config.RouteLimits) > 0 {
+		rl.routeLimits = make(map[string][]*slidingWindow, len(config.RouteLimits))
+		for name, limits := range config.RouteLimits {
+			rl.routeLimits[name] = newSlidingWindows(limits)
+		}
+	}
+
+	return rtr.NewMiddleware().
+		SetName("Rate Limit").
+		SetHandler(rl.handler)
+}
+
+// checkRateLimitCounters returns an error if a counter is used by more than
+// one tier. Counters from NewKeyValueLimitCounter are exempt, as every tier
+// uses its own copy of them.
+func checkRateLimitCounters(config RateLimitConfig) error {
+	counters := make([]LimitCounter, 0, len(config.Limits)+1)
+	if len(config.Limits) == 0 {
+		counters = append(counters, config.Counter)
+	}
+	for _, limit := range config.Limits {
+		counters = append(counters, limit.Counter)
+	}
+	for _, limits := range config.RouteLimits {
+		for _, limit := range limits {
+			counters = append(counters, limit.Counter)
+		}
+	}
+
+	var seen []LimitCounter
+	for _, counter := range counters {
+		if counter == nil || !reflect.TypeOf(counter).Comparable() {
+			continue
+		}
+		if _, ok := counter.(*keyValueLimitCounter); ok {
+			continue
+		}
+		if slices.Contains(seen, counter) {
+			return fmt.Errorf("rate limit: a %T counter is used by more than one tier; give each tier its own", counter)
+		}
+		seen = append(seen, counter)
+	}
+	return nil
+}
+
+// newSlidingWindows creates one sliding window per tier.
+func newSlidingWindows(limits []RateLimit) []*slidingWindow {
+	windows := make([]*slidingWindow, 0, len(limits))
+	for _, limit := range limits {
+		windows = append(windows, newSlidingWindow(limit.Requests, limit.Window, limit.Counter))
+	}
+	return windows
+}
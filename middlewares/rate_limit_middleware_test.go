@@ -0,0 +1,381 @@
+package middlewares_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// fakeKeyValueStore is an in-process KeyValueStore used to simulate a shared
+// backend such as Redis.
+type fakeKeyValueStore struct {
+	mu      sync.Mutex
+	values  map[string]int64
+	expires map[string]time.Time
+	err     error
+	// delay simulates the network round-trip of each call.
+	delay time.Duration
+}
+
+func newFakeKeyValueStore() *fakeKeyValueStore {
+	return &fakeKeyValueStore{
+		values:  map[string]int64{},
+		expires: map[string]time.Time{},
+	}
+}
+
+func (s *fakeKeyValueStore) IncrBy(key string, amount int64, ttl time.Duration) (int64, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	if _, ok := s.values[key]; !ok {
+		s.expires[key] = time.Now().Add(ttl)
+	}
+	s.values[key] += amount
+	return s.values[key], nil
+}
+
+func (s *fakeKeyValueStore) Get(key string) (int64, error) {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	if exp, ok := s.expires[key]; ok && time.Now().After(exp) {
+		delete(s.values, key)
+		delete(s.expires, key)
+	}
+	return s.values[key], nil
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(h http.Handler, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("uses in-memory counter by default", func(t *testing.T) {
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 2,
+			Window:   time.Minute,
+		}).GetHandler()(handler)
+
+		for i := 0; i < 2; i++ {
+			if w := serve(h, "192.0.2.1"); w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d on request %d", http.StatusOK, w.Code, i+1)
+			}
+		}
+
+		if w := serve(h, "192.0.2.1"); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+		}
+	})
+
+	t.Run("sets rate limit headers", func(t *testing.T) {
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 1,
+			Window:   time.Minute,
+		}).GetHandler()(handler)
+
+		w := serve(h, "192.0.2.2")
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "1" {
+			t.Errorf("Expected X-RateLimit-Limit 1, got %q", got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+			t.Errorf("Expected X-RateLimit-Remaining 0, got %q", got)
+		}
+		reset, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			t.Fatalf("Expected numeric X-RateLimit-Reset, got %q", w.Header().Get("X-RateLimit-Reset"))
+		}
+		if until := time.Until(time.Unix(reset, 0)); until < -time.Second || until > time.Minute+time.Second {
+			t.Errorf("Expected X-RateLimit-Reset within the window, got %v from now", until)
+		}
+
+		w = serve(h, "192.0.2.2")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+		}
+		retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+		if err != nil || retryAfter < 1 || retryAfter > 60 {
+			t.Errorf("Expected Retry-After between 1 and 60, got %q", w.Header().Get("Retry-After"))
+		}
+	})
+
+	t.Run("shares limits between replicas through a key-value store", func(t *testing.T) {
+		store := newFakeKeyValueStore()
+		replica1 := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 2,
+			Window:   time.Minute,
+			Counter:  middlewares.NewKeyValueLimitCounter(store, "rl:"),
+		}).GetHandler()(handler)
+		replica2 := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 2,
+			Window:   time.Minute,
+			Counter:  middlewares.NewKeyValueLimitCounter(store, "rl:"),
+		}).GetHandler()(handler)
+
+		if w := serve(replica1, "192.0.2.3"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if w := serve(replica2, "192.0.2.3"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if w := serve(replica1, "192.0.2.3"); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+		}
+		if w := serve(replica2, "192.0.2.4"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d for a different IP, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("does not serialise requests with different keys", func(t *testing.T) {
+		store := newFakeKeyValueStore()
+		store.delay = 50 * time.Millisecond
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 10,
+			Window:   time.Minute,
+			Counter:  middlewares.NewKeyValueLimitCounter(store, "rl:"),
+		}).GetHandler()(handler)
+
+		// Each request makes three store calls, 150ms in all
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Go(func() {
+				if w := serve(h, "192.0.2."+strconv.Itoa(10+i)); w.Code != http.StatusOK {
+					t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+				}
+			})
+		}
+		wg.Wait()
+		if elapsed := time.Since(start); elapsed > 600*time.Millisecond {
+			t.Errorf("Expected requests with different keys to run concurrently, took %s", elapsed)
+		}
+	})
+
+	t.Run("allows requests when the store fails", func(t *testing.T) {
+		store := newFakeKeyValueStore()
+		store.err = errors.New("connection refused")
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 1,
+			Window:   time.Minute,
+			Counter:  middlewares.NewKeyValueLimitCounter(store, "rl:"),
+		}).GetHandler()(handler)
+
+		for i := 0; i < 3; i++ {
+			if w := serve(h, "192.0.2.5"); w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d on request %d", http.StatusOK, w.Code, i+1)
+			}
+		}
+	})
+}
+
+func TestRateLimitMiddlewareTiers(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+		Limits: []middlewares.RateLimit{
+			{Requests: 5, Window: time.Minute},
+			{Requests: 3, Window: time.Hour},
+		},
+	}).GetHandler()(handler)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.10:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d on request %d", http.StatusOK, w.Code, i+1)
+		}
+		// The hourly tier is closest to exhaustion and is reported.
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("Expected X-RateLimit-Limit 3, got %q", got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After")); retryAfter <= 60 {
+		t.Errorf("Expected Retry-After from the hourly tier, got %q", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestRateLimitMiddlewareSharedCounter(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	request := func(h http.Handler) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.10:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("key-value counter keeps tiers apart", func(t *testing.T) {
+		store := newFakeKeyValueStore()
+		counter := middlewares.NewKeyValueLimitCounter(store, "rl:")
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Limits: []middlewares.RateLimit{
+				{Requests: 5, Window: time.Minute, Counter: counter},
+				{Requests: 3, Window: time.Hour, Counter: counter},
+			},
+		}).GetHandler()(handler)
+
+		for i := 0; i < 3; i++ {
+			if code := request(h); code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d on request %d", http.StatusOK, code, i+1)
+			}
+		}
+		if code := request(h); code != http.StatusTooManyRequests {
+			t.Errorf("Expected the hourly tier to reject, got %d", code)
+		}
+
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		windows := map[string]int64{}
+		for key, value := range store.values {
+			switch {
+			case strings.Contains(key, ":1m0s:"):
+				windows["1m0s"] += value
+			case strings.Contains(key, ":1h0m0s:"):
+				windows["1h0m0s"] += value
+			}
+			if ttl := time.Until(store.expires[key]); strings.Contains(key, ":1h0m0s:") && ttl < time.Hour {
+				t.Errorf("Expected hourly keys to outlive the minute tier, got ttl %s", ttl)
+			}
+		}
+		if windows["1m0s"] != 3 || windows["1h0m0s"] != 3 {
+			t.Errorf("Expected each tier to be counted under its own window, got %v", windows)
+		}
+	})
+
+	t.Run("other counters are rejected", func(t *testing.T) {
+		counter := middlewares.NewMemoryLimitCounter()
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Limits: []middlewares.RateLimit{{Requests: 5, Window: time.Minute, Counter: counter}},
+			RouteLimits: map[string][]middlewares.RateLimit{
+				"login": {{Requests: 1, Window: time.Hour, Counter: counter}},
+			},
+		}).GetHandler()(handler)
+
+		if code := request(h); code != http.StatusInternalServerError {
+			t.Errorf("Expected a counter shared by tiers to be a configuration error, got %d", code)
+		}
+	})
+}
+
+func TestRateLimitMiddlewareRouteLimits(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	api := rtr.NewGroup().SetPrefix("/api").
+		AddRoute(rtr.Post("/login", ok).SetName("login")).
+		AddRoute(rtr.Get("/items", ok).SetName("items"))
+	api.AddBeforeMiddlewares([]rtr.MiddlewareInterface{
+		middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 10,
+			Window:   time.Minute,
+			RouteLimits: map[string][]middlewares.RateLimit{
+				"login": {{Requests: 1, Window: time.Minute}},
+			},
+		}),
+	})
+
+	router := rtr.NewRouter()
+	router.AddGroup(api)
+
+	serve := func(method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "192.0.2.11:1234"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := serve("POST", "/api/login"); code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, code)
+	}
+	if code := serve("POST", "/api/login"); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, code)
+	}
+	for i := 0; i < 5; i++ {
+		if code := serve("GET", "/api/items"); code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d on request %d", http.StatusOK, code, i+1)
+		}
+	}
+}
+
+type rateLimitUser struct{ id string }
+
+func (u rateLimitUser) GetID() string { return u.id }
+
+func TestRateLimitKeyFuncs(t *testing.T) {
+	type ctxKey struct{}
+
+	req := httptest.NewRequest("GET", "/items", nil)
+	req.RemoteAddr = "192.0.2.12:1234"
+	req.Header.Set("X-API-Key", "key-1")
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, rateLimitUser{id: "user-7"}))
+
+	keyFn := middlewares.KeyByAll(
+		middlewares.KeyByIP,
+		middlewares.KeyByContextValue(ctxKey{}),
+		middlewares.KeyByHeader("X-API-Key"),
+		middlewares.KeyByRouteName,
+	)
+
+	key, err := keyFn(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "192.0.2.12:user-7:key-1:/items"; key != expected {
+		t.Errorf("Expected key %q, got %q", expected, key)
+	}
+
+	t.Run("propagates key errors as 428", func(t *testing.T) {
+		h := middlewares.RateLimitMiddleware(middlewares.RateLimitConfig{
+			Requests: 1,
+			Window:   time.Minute,
+			KeyFunc: func(r *http.Request) (string, error) {
+				return "", errors.New("missing API key")
+			},
+		}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusPreconditionRequired {
+			t.Errorf("Expected status code %d, got %d", http.StatusPreconditionRequired, w.Code)
+		}
+	})
+}
@@ -1,134 +1,161 @@
 package middlewares
 
 import (
+	"fmt"
+	"hash/maphash"
+	"log/slog"
 	"math"
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/dracory/rtr"
 )
 
-// rateLimiter implements a sliding-window rate limiter using two fixed windows
-// (current and previous) with a weighted rate calculation. It is a stdlib-only
-// reimplementation of go-chi/httprate's RateLimiter.
+// RateLimitKeyFunc derives the key requests are counted under. Returning an
+// error rejects the request with 428 Precondition Required.
+type RateLimitKeyFunc func(r *http.Request) (string, error)
+
+// rateLimiter counts requests per key against one or more sliding windows. A
+// request is admitted only if every window admits it, and only admitted
+// requests are counted. It is a stdlib-only reimplementation of
+// go-chi/httprate's RateLimiter, extended with tiers and per-route limits.
 type rateLimiter struct {
+	keyFn  RateLimitKeyFunc
+	limits []*slidingWindow
+	// routeLimits replace limits for requests whose matched route has the
+	// given name.
+	routeLimits map[string][]*slidingWindow
+	// locks serialise the read-then-increment of requests sharing a key,
+	// striped so requests with other keys, possibly waiting on a network
+	// counter, are not held up.
+	locks [rateLimitLockStripes]sync.Mutex
+	seed  maphash.Seed
+}
+
+// rateLimitLockStripes is the number of locks keys are spread over.
+const rateLimitLockStripes = 256
+
+// slidingWindow is a single rate-limit tier using two fixed windows (current
+// and previous) with a weighted rate calculation.
+type slidingWindow struct {
 	requestLimit int
 	windowLength time.Duration
 	// windowOffset aligns windows to the limiter's start instant rather than
 	// the wall clock, so resets spread out instead of all snapping to the same
 	// instant.
 	windowOffset time.Duration
-	keyFn        func(*http.Request) (string, error)
-	counter      *limitCounter
-	mu           sync.Mutex
-}
-
-// limitCounter is an in-memory counter with two buckets (current and previous
-// window). It mirrors go-chi/httprate's localCounter.
-type limitCounter struct {
-	windowLength     time.Duration
-	latestWindow     time.Time
-	latestCounters   map[string]int
-	previousCounters map[string]int
-	mu               sync.RWMutex
+	counter      LimitCounter
 }
 
-// newLimitCounter creates a new in-memory limit counter.
-func newLimitCounter(windowLength time.Duration) *limitCounter {
-	return &limitCounter{
-		windowLength:     windowLength,
-		latestWindow:     time.Now().UTC(),
-		latestCounters:   make(map[string]int),
-		previousCounters: make(map[string]int),
+// newRateLimiter creates a single-tier rate limiter. A nil counter selects the
+// in-memory LimitCounter.
+func newRateLimiter(requestLimit int, windowLength time.Duration, keyFn RateLimitKeyFunc, counter LimitCounter) *rateLimiter {
+	return &rateLimiter{
+		keyFn:  keyFn,
+		limits: []*slidingWindow{newSlidingWindow(requestLimit, windowLength, counter)},
+		seed:   maphash.MakeSeed(),
 	}
 }
 
-// incrementBy adds amount to the counter for the given key in the current
-// window.
-func (c *limitCounter) incrementBy(key string, currentWindow time.Time, amount int) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	c.evict(currentWindow)
-
-	count := c.latestCounters[key]
-	c.latestCounters[key] = count + amount
+// lockFor returns the lock of the stripe key falls in.
+func (l *rateLimiter) lockFor(key string) *sync.Mutex {
+	return &l.locks[maphash.String(l.seed, key)%rateLimitLockStripes]
 }
 
-// get returns the counts for the current and previous windows for the given
-// key.
-func (c *limitCounter) get(key string, currentWindow, previousWindow time.Time) (int, int) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	if c.latestWindow.Equal(currentWindow) {
-		curr := c.latestCounters[key]
-		prev := c.previousCounters[key]
-		return curr, prev
-	}
-
-	if c.latestWindow.Equal(previousWindow) {
-		prev := c.latestCounters[key]
-		return 0, prev
+// newSlidingWindow creates a rate-limit tier. A nil counter selects the
+// in-memory LimitCounter.
+func newSlidingWindow(requestLimit int, windowLength time.Duration, counter LimitCounter) *slidingWindow {
+	var offset time.Duration
+	if counter == nil {
+		counter = NewMemoryLimitCounter()
+		start := time.Now().UTC()
+		offset = start.Sub(start.Truncate(windowLength))
 	}
+	// A shared counter is read and written by several processes, each with its
+	// own start instant, so its windows stay aligned to the wall clock.
 
-	return 0, 0
-}
-
-// evict shifts or clears the windows when the current window has advanced.
-func (c *limitCounter) evict(currentWindow time.Time) {
-	if c.latestWindow.Equal(currentWindow) {
-		return
+	// A key-value counter shared by several tiers gets a copy per tier, so
+	// each keeps its own window length.
+	if kv, ok := counter.(*keyValueLimitCounter); ok {
+		tier := *kv
+		counter = &tier
 	}
+	counter.Config(requestLimit, windowLength)
 
-	previousWindow := currentWindow.Add(-c.windowLength)
-	if c.latestWindow.Equal(previousWindow) {
-		c.latestWindow = currentWindow
-		// Shift the windows without map re-allocation.
-		clear(c.previousCounters)
-		c.latestCounters, c.previousCounters = c.previousCounters, c.latestCounters
-		return
-	}
-
-	c.latestWindow = currentWindow
-	clear(c.previousCounters)
-	clear(c.latestCounters)
-}
-
-// newRateLimiter creates a new rate limiter with the given configuration.
-func newRateLimiter(requestLimit int, windowLength time.Duration, keyFn func(*http.Request) (string, error)) *rateLimiter {
-	start := time.Now().UTC()
-	offset := start.Sub(start.Truncate(windowLength))
-
-	return &rateLimiter{
+	return &slidingWindow{
 		requestLimit: requestLimit,
 		windowLength: windowLength,
 		windowOffset: offset,
-		keyFn:        keyFn,
-		counter:      newLimitCounter(windowLength),
+		counter:      counter,
 	}
 }
 
 // currentWindow returns the start of the rate-limit window containing t,
 // aligned to windowOffset rather than the wall clock.
-func (l *rateLimiter) currentWindow(t time.Time) time.Time {
+func (l *slidingWindow) currentWindow(t time.Time) time.Time {
 	return t.Add(-l.windowOffset).Truncate(l.windowLength).Add(l.windowOffset)
 }
 
 // calculateRate computes the weighted rate across the current and previous
 // windows. The previous window's count is weighted by the fraction of time
 // remaining in it, giving a smooth sliding-window approximation.
-func (l *rateLimiter) calculateRate(key string) float64 {
-	now := time.Now().UTC()
+func (l *slidingWindow) calculateRate(key string, now time.Time) (float64, error) {
 	currentWindow := l.currentWindow(now)
 	previousWindow := currentWindow.Add(-l.windowLength)
 
-	currCount, prevCount := l.counter.get(key, currentWindow, previousWindow)
+	currCount, prevCount, err := l.counter.Get(key, currentWindow, previousWindow)
+	if err != nil {
+		return 0, err
+	}
 
 	diff := now.Sub(currentWindow)
 	rate := float64(prevCount)*(float64(l.windowLength)-float64(diff))/float64(l.windowLength) + float64(currCount)
-	return rate
+	return rate, nil
+}
+
+// setHeaders writes the X-RateLimit-* headers. Reset is the Unix time (in
+// seconds) at which the current window ends.
+func (l *slidingWindow) setHeaders(w http.ResponseWriter, remaining int, now time.Time) {
+	// Clamp remaining to 0; the sliding-window weighted rate can exceed
+	// the per-window limit after a burst in the previous window, which
+	// would otherwise produce a negative value.
+	if remaining < 0 {
+		remaining = 0
+	}
+	reset := l.currentWindow(now).Add(l.windowLength)
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.requestLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+}
+
+// retryAfter returns the number of whole seconds until the current window
+// ends, rounded up and never less than one.
+func (l *slidingWindow) retryAfter(now time.Time) int {
+	wait := l.currentWindow(now).Add(l.windowLength).Sub(now)
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// limitsFor returns the tiers that apply to the request: the route override if
+// the matched route's name has one, otherwise the default tiers.
+func (l *rateLimiter) limitsFor(r *http.Request) []*slidingWindow {
+	if len(l.routeLimits) == 0 {
+		return l.limits
+	}
+	if route := rtr.GetRoute(r); route != nil {
+		if limits, ok := l.routeLimits[route.GetName()]; ok {
+			return limits
+		}
+	}
+	return l.limits
 }
 
 // handler returns the middleware handler that enforces the rate limit.
@@ -140,46 +167,76 @@ func (l *rateLimiter) handler(next http.Handler) http.Handler {
 			return
 		}
 
-		l.mu.Lock()
-		rate := l.calculateRate(key)
-		rateInt := int(math.Round(rate))
-
-		if rateInt+1 > l.requestLimit {
-			l.mu.Unlock()
-			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.requestLimit))
-			// Clamp remaining to 0; the sliding-window weighted rate can exceed
-			// the per-window limit after a burst in the previous window, which
-			// would otherwise produce a negative value.
-			remaining := l.requestLimit - rateInt
-			if remaining < 0 {
-				remaining = 0
+		limits := l.limitsFor(r)
+		now := time.Now().UTC()
+
+		// The tier with the fewest requests left is reported in the headers;
+		// when rejecting, the tier that makes the client wait longest is.
+		var reported, rejected *slidingWindow
+		reportedRemaining := math.MaxInt
+		rejectedRemaining := 0
+
+		mu := l.lockFor(key)
+		mu.Lock()
+		for _, limit := range limits {
+			rate, err := limit.calculateRate(key, now)
+			if err != nil {
+				// A failing shared counter must not take the site down with it,
+				// so the request is let through.
+				mu.Unlock()
+				slog.Default().Error("rate limiter: counter read failed", slog.String("error", err.Error()))
+				next.ServeHTTP(w, r)
+				return
+			}
+			rateInt := int(math.Round(rate))
+			remaining := limit.requestLimit - rateInt - 1
+
+			if rateInt+1 > limit.requestLimit {
+				if rejected == nil || limit.retryAfter(now) > rejected.retryAfter(now) {
+					rejected = limit
+					rejectedRemaining = remaining + 1
+				}
+				continue
 			}
-			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
-			w.Header().Set("Retry-After", strconv.Itoa(int(l.windowLength.Seconds())))
+
+			if remaining < reportedRemaining {
+				reported = limit
+				reportedRemaining = remaining
+			}
+		}
+
+		if rejected != nil {
+			mu.Unlock()
+			rejected.setHeaders(w, rejectedRemaining, now)
+			w.Header().Set("Retry-After", strconv.Itoa(rejected.retryAfter(now)))
 			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 			return
 		}
 
-		currentWindow := l.currentWindow(time.Now().UTC())
-		l.counter.incrementBy(key, currentWindow, 1)
-		l.mu.Unlock()
+		for _, limit := range limits {
+			if err := limit.counter.IncrementBy(key, limit.currentWindow(now), 1); err != nil {
+				slog.Default().Error("rate limiter: counter increment failed", slog.String("error", err.Error()))
+			}
+		}
+		mu.Unlock()
 
-		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(l.requestLimit))
-		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(l.requestLimit-rateInt-1))
+		if reported != nil {
+			reported.setHeaders(w, reportedRemaining, now)
+		}
 
 		next.ServeHTTP(w, r)
 	})
 }
 
-// keyByRemoteAddr uses r.RemoteAddr (including port) as the rate-limit key.
-func keyByRemoteAddr(r *http.Request) (string, error) {
+// KeyByRemoteAddr uses r.RemoteAddr (including port) as the rate-limit key.
+func KeyByRemoteAddr(r *http.Request) (string, error) {
 	return r.RemoteAddr, nil
 }
 
-// keyByIP extracts the client IP from r.RemoteAddr (stripping the port) and
+// KeyByIP extracts the client IP from r.RemoteAddr (stripping the port) and
 // canonicalizes IPv6 addresses to their /64 prefix. It mirrors
 // go-chi/httprate's KeyByIP.
-func keyByIP(r *http.Request) (string, error) {
+func KeyByIP(r *http.Request) (string, error) {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		ip = r.RemoteAddr
@@ -187,6 +244,66 @@ func keyByIP(r *http.Request) (string, error) {
 	return canonicalizeIP(ip), nil
 }
 
+// KeyByHeader returns a key function that uses the value of the given request
+// header, e.g. an API key. A missing header yields an empty key part.
+func KeyByHeader(header string) RateLimitKeyFunc {
+	return func(r *http.Request) (string, error) {
+		return r.Header.Get(header), nil
+	}
+}
+
+// KeyByContextValue returns a key function that uses the value stored in the
+// request context under key, e.g. the authenticated user or user ID. Strings
+// are used as-is; values implementing GetID() string or fmt.Stringer are
+// converted with those methods. A missing value yields an empty key part.
+func KeyByContextValue(key any) RateLimitKeyFunc {
+	return func(r *http.Request) (string, error) {
+		switch v := r.Context().Value(key).(type) {
+		case nil:
+			return "", nil
+		case string:
+			return v, nil
+		case interface{ GetID() string }:
+			return v.GetID(), nil
+		case fmt.Stringer:
+			return v.String(), nil
+		default:
+			return fmt.Sprint(v), nil
+		}
+	}
+}
+
+// KeyByRouteName uses the name of the matched route as the key. Unnamed routes
+// fall back to their path pattern, and requests not dispatched by a router to
+// the request path.
+func KeyByRouteName(r *http.Request) (string, error) {
+	route := rtr.GetRoute(r)
+	if route == nil {
+		return r.URL.Path, nil
+	}
+	if name := route.GetName(); name != "" {
+		return name, nil
+	}
+	return route.GetPath(), nil
+}
+
+// KeyByAll combines several key functions into one, joining their keys with
+// ":". For example KeyByAll(KeyByIP, KeyByRouteName) limits each client per
+// route.
+func KeyByAll(keyFuncs ...RateLimitKeyFunc) RateLimitKeyFunc {
+	return func(r *http.Request) (string, error) {
+		keys := make([]string, 0, len(keyFuncs))
+		for _, keyFn := range keyFuncs {
+			key, err := keyFn(r)
+			if err != nil {
+				return "", err
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, ":"), nil
+	}
+}
+
 // canonicalizeIP normalizes a client IP string for use as a rate-limit key:
 //   - IPv4 addresses are returned unchanged.
 //   - IPv6 addresses are reduced to their /64 prefix.
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"context"
 	"net"
 	"net/http"
 	"strings"
@@ -16,11 +17,35 @@ var realIPHeaders = []string{
 	"X-Forwarded-For",
 }
 
+// realIPKey is the context key used to store the client IP resolved by
+// RealIPMiddlewareWithConfig.
+type realIPKey struct{}
+
+// peerIPKey is the context key used to store the IP of the direct peer,
+// before RealIPMiddlewareWithConfig rewrote r.RemoteAddr.
+type peerIPKey struct{}
+
+// RealIPConfig configures RealIPMiddlewareWithConfig.
+type RealIPConfig struct {
+	// TrustedProxies lists the CIDRs (e.g. "10.0.0.0/8") or single IPs of the
+	// proxies in front of the application. Forwarding headers are only read
+	// when the direct peer is one of them. Invalid entries are logged and
+	// ignored.
+	TrustedProxies []string
+}
+
 // RealIPMiddleware returns a middleware that sets the client's real IP address
 // in r.RemoteAddr based on proxy headers. It consults True-Client-IP,
 // X-Real-IP, and X-Forwarded-For (in that priority order) and takes the first
 // valid IP address. If none of the headers contain a valid IP, RemoteAddr is
 // left unchanged.
+//
+// The headers are believed whoever sends them, so any client can pick the IP
+// it is seen as. Only use this middleware behind a proxy that strips or
+// overwrites all three headers on every request; otherwise IP-based rate
+// limits, bans and allow-lists can be bypassed or turned against other
+// users. Prefer RealIPMiddlewareWithConfig, which only trusts the headers
+// from the configured proxies.
 func RealIPMiddleware() rtr.MiddlewareInterface {
 	return rtr.NewMiddleware().
 		SetName("Real IP").
@@ -64,3 +89,87 @@ func extractRealIP(r *http.Request) string {
 func isValidIP(s string) bool {
 	return net.ParseIP(s) != nil
 }
+
+// RealIPMiddlewareWithConfig returns a middleware that sets r.RemoteAddr to the
+// client's IP address as reported by the configured trusted proxies.
+//
+// The request is only rewritten when r.RemoteAddr is itself a trusted proxy.
+// The client IP is then taken from, in order:
+//  1. the RFC 7239 Forwarded header "for" parameters,
+//  2. X-Forwarded-For,
+//  3. X-Real-IP, then True-Client-IP.
+//
+// Hop lists are walked right-to-left, skipping trusted proxies, and the first
+// untrusted hop is used, so entries prepended by the client are ignored. The
+// resolved IP is also stored in the request context (see GetRealIP), where
+// JailBotsMiddleware picks it up, and so is the IP of the direct peer (see
+// GetPeerIP), which later middlewares trusting the same proxies, such as
+// NewHTTPSRedirectMiddleware, check instead of the rewritten RemoteAddr.
+func RealIPMiddlewareWithConfig(config RealIPConfig) rtr.MiddlewareInterface {
+	proxies := parseTrustedProxies(config.TrustedProxies)
+
+	return rtr.NewMiddleware().
+		SetName("Real IP").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip := remoteIP(r)
+				peer := peerIP(r)
+
+				if proxies.isTrustedPeer(r) {
+					if forwarded := extractTrustedRealIP(r, proxies); forwarded != "" {
+						ip = forwarded
+						r.RemoteAddr = forwarded
+					}
+				}
+
+				ctx := context.WithValue(r.Context(), realIPKey{}, ip)
+				ctx = context.WithValue(ctx, peerIPKey{}, peer)
+				next.ServeHTTP(w, r.WithContext(ctx))
+			})
+		})
+}
+
+// GetRealIP returns the client IP resolved by RealIPMiddlewareWithConfig, or an
+// empty string if the middleware did not run.
+func GetRealIP(ctx context.Context) string {
+	ip, _ := ctx.Value(realIPKey{}).(string)
+	return ip
+}
+
+// GetPeerIP returns the IP of the direct peer, i.e. the proxy in front of the
+// application, recorded by RealIPMiddlewareWithConfig before it rewrote
+// r.RemoteAddr, or an empty string if the middleware did not run.
+func GetPeerIP(ctx context.Context) string {
+	ip, _ := ctx.Value(peerIPKey{}).(string)
+	return ip
+}
+
+// extractTrustedRealIP returns the client IP reported by the trusted proxies
+// in front of r, or "" if the headers do not yield a valid IP.
+func extractTrustedRealIP(r *http.Request, proxies trustedProxies) string {
+	if elements := parseForwarded(r.Header); len(elements) > 0 {
+		hops := make([]string, 0, len(elements))
+		for _, element := range elements {
+			hops = append(hops, forwardedNodeIP(element["for"]))
+		}
+		return proxies.clientIP(hops)
+	}
+
+	if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		var hops []string
+		for _, value := range values {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return proxies.clientIP(hops)
+	}
+
+	for _, header := range []string{"X-Real-IP", "True-Client-IP"} {
+		if value := strings.TrimSpace(r.Header.Get(header)); isValidIP(value) {
+			return value
+		}
+	}
+
+	return ""
+}
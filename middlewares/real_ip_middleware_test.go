@@ -167,3 +167,106 @@ func TestRealIPMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestRealIPMiddlewareWithConfig(t *testing.T) {
+	middleware := middlewares.RealIPMiddlewareWithConfig(middlewares.RealIPConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::/48", "192.0.2.1"},
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{
+			name:       "ignores headers from untrusted peer",
+			remoteAddr: "203.0.113.5:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.2"},
+			expected:   "203.0.113.5:1234",
+		},
+		{
+			name:       "uses rightmost untrusted X-Forwarded-For hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.1, 10.0.0.2"},
+			expected:   "198.51.100.1",
+		},
+		{
+			name:       "uses leftmost hop when all hops are trusted",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "stops at invalid hop",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, garbage, 10.0.0.2"},
+			expected:   "10.0.0.1:1234",
+		},
+		{
+			name:       "trusts single IP entries",
+			remoteAddr: "192.0.2.1:1234",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.7"},
+			expected:   "198.51.100.7",
+		},
+		{
+			name:       "prefers Forwarded header",
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded":       `for=198.51.100.9;proto=https, for="10.0.0.5:8080"`,
+				"X-Forwarded-For": "198.51.100.1",
+			},
+			expected: "198.51.100.9",
+		},
+		{
+			name:       "parses IPv6 Forwarded nodes",
+			remoteAddr: "[2001:db8:ffff::1]:1234",
+			headers:    map[string]string{"Forwarded": `for="[2001:db8:cafe::17]:4711"`},
+			expected:   "2001:db8:cafe::17",
+		},
+		{
+			name:       "does not rewrite obfuscated Forwarded node",
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"Forwarded": "for=_hidden"},
+			expected:   "10.0.0.1:1234",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})
+
+			req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			middleware.GetHandler()(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.expected {
+				t.Errorf("Expected RemoteAddr %q, got %q", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("stores client IP in context", func(t *testing.T) {
+		var got string
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = middlewares.GetRealIP(r.Context())
+		})
+
+		req := httptest.NewRequest("GET", "http://example.com/foo", nil)
+		req.RemoteAddr = "203.0.113.5:1234"
+		req.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+		middleware.GetHandler()(handler).ServeHTTP(httptest.NewRecorder(), req)
+
+		if got != "203.0.113.5" {
+			t.Errorf("Expected context IP %q, got %q", "203.0.113.5", got)
+		}
+	})
+}
@@ -1,24 +1,103 @@
 package middlewares
 
 import (
-	"log"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/dracory/rtr"
 )
 
+// PanicReport describes a panic recovered by RecoveryMiddlewareWithConfig.
+type PanicReport struct {
+	// Value is the value passed to panic.
+	Value any
+	// Stack is the stack trace of the panicking goroutine.
+	Stack []byte
+	// Request is the request being served.
+	Request *http.Request
+}
+
+// PanicReporter receives recovered panics, e.g. to forward them to an error
+// tracker such as Sentry. ReportPanic is called synchronously before the
+// error response is written, so slow reporters should hand off the work.
+type PanicReporter interface {
+	ReportPanic(ctx context.Context, report PanicReport)
+}
+
+// PanicReporterFunc adapts a function to a PanicReporter.
+type PanicReporterFunc func(ctx context.Context, report PanicReport)
+
+// ReportPanic implements PanicReporter.
+func (f PanicReporterFunc) ReportPanic(ctx context.Context, report PanicReport) {
+	f(ctx, report)
+}
+
+// NewLogPanicReporter returns a PanicReporter logging panics with their
+// stack at error level. A nil logger uses slog.Default().
+func NewLogPanicReporter(logger *slog.Logger) PanicReporter {
+	return PanicReporterFunc(func(ctx context.Context, report PanicReport) {
+		l := logger
+		if l == nil {
+			l = slog.Default()
+		}
+		l.ErrorContext(ctx, "Recovered from panic",
+			"panic", fmt.Sprint(report.Value),
+			"method", report.Request.Method,
+			"path", report.Request.URL.Path,
+			"stack", string(report.Stack))
+	})
+}
+
+// RecoveryConfig configures RecoveryMiddlewareWithConfig.
+type RecoveryConfig struct {
+	// Reporters are notified of every recovered panic. Optional; defaults to
+	// logging with slog.Default(). Add NewLogPanicReporter(nil) to keep
+	// logging when setting other reporters.
+	Reporters []PanicReporter
+
+	// OnPanic writes the response for a recovered panic, if the handler has
+	// not started one. Optional; defaults to a 500 Internal Server Error
+	// rendered with rtr.RenderError, as JSON, HTML or text depending on the
+	// Accept header.
+	OnPanic func(w http.ResponseWriter, r *http.Request, value any, stack []byte)
+}
+
 // RecoveryMiddleware creates a new middleware that recovers from panics.
 // It logs the panic details and returns a 500 Internal Server Error response.
 // This should typically be added as one of the first middlewares in the chain.
 func RecoveryMiddleware() rtr.MiddlewareInterface {
+	return RecoveryMiddlewareWithConfig(RecoveryConfig{})
+}
+
+// RecoveryMiddlewareWithConfig creates a middleware that recovers from
+// panics, reports them and answers with an error response, see
+// RecoveryConfig. If the response has already started, it is left as is.
+//
+// A panic with http.ErrAbortHandler is not recovered, so the server can
+// abort the response as intended.
+func RecoveryMiddlewareWithConfig(config RecoveryConfig) rtr.MiddlewareInterface {
+	if len(config.Reporters) == 0 {
+		config.Reporters = []PanicReporter{NewLogPanicReporter(nil)}
+	}
+	if config.OnPanic == nil {
+		config.OnPanic = func(w http.ResponseWriter, r *http.Request, value any, stack []byte) {
+			rtr.RenderError(w, r, http.StatusInternalServerError, nil)
+		}
+	}
+
 	return rtr.NewMiddleware().
 		SetName("Recovery Middleware").
-		SetHandler(recoveryHandler())
+		SetHandler(recoveryHandler(config))
 }
 
-// recoveryHandler returns the default recovery handler
-func recoveryHandler() rtr.StdMiddleware {
+// recoveryHandler returns the recovery handler for config
+func recoveryHandler(config RecoveryConfig) rtr.StdMiddleware {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if w == nil || r == nil {
@@ -26,30 +105,31 @@ func recoveryHandler() rtr.StdMiddleware {
 				return
 			}
 
-			// Create a response recorder to capture the response
+			// Wrap the writer to know whether the response has started
 			rw := newResponseRecorder(w)
 
 			defer func() {
-				if err := recover(); err != nil {
-					// Log the error with stack trace
-					log.Printf("Recovered from panic: %v\n%s", err, string(debug.Stack()))
-
-					// Only write error response if nothing was written yet
-					if !rw.Written() {
-						http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-					}
+				value := recover()
+				if value == nil {
+					return
+				}
+				if err, ok := value.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(value)
 				}
-			}()
 
-			next.ServeHTTP(rw, r)
+				stack := debug.Stack()
+				report := PanicReport{Value: value, Stack: stack, Request: r}
+				for _, reporter := range config.Reporters {
+					reporter.ReportPanic(r.Context(), report)
+				}
 
-			// If the handler didn't write anything, ensure we have a status code
-			if !rw.Written() && rw.status == 0 {
-				rw.WriteHeader(http.StatusOK)
-			}
+				// Only write error response if nothing was written yet
+				if !rw.Written() {
+					config.OnPanic(w, r, value, stack)
+				}
+			}()
 
-			// Copy the response to the original writer if not already done
-			rw.WriteTo(w)
+			next.ServeHTTP(rw, r)
 		})
 	}
 }
@@ -58,53 +138,56 @@ func recoveryHandler() rtr.StdMiddleware {
 // and allows checking if anything was written
 type responseRecorder struct {
 	http.ResponseWriter
-	status int
-	size   int
-	header http.Header
+	status   int
+	size     int
+	hijacked bool
 }
 
 func newResponseRecorder(w http.ResponseWriter) *responseRecorder {
-	return &responseRecorder{
-		ResponseWriter: w,
-		header:         w.Header().Clone(),
-	}
+	return &responseRecorder{ResponseWriter: w}
 }
 
 func (r *responseRecorder) WriteHeader(code int) {
-	if !r.Written() {
+	if !r.Written() && code >= http.StatusOK {
 		r.status = code
-		r.ResponseWriter.WriteHeader(code)
 	}
+	r.ResponseWriter.WriteHeader(code)
 }
 
 func (r *responseRecorder) Write(b []byte) (int, error) {
-	r.size += len(b)
 	if !r.Written() {
 		r.status = http.StatusOK // Default status code if none was set
 	}
-	return r.ResponseWriter.Write(b)
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
 }
 
+// Written reports whether the response has started, or the connection was
+// hijacked, so that no error response can be written anymore.
 func (r *responseRecorder) Written() bool {
-	return r.status != 0
+	return r.status != 0 || r.hijacked
 }
 
-func (r *responseRecorder) WriteTo(w http.ResponseWriter) {
-	// If we already wrote to the original writer, don't write again
-	if r.Written() {
-		return
-	}
-
-	// Copy headers
-	headers := w.Header()
-	for k, v := range r.header {
-		headers[k] = v
+// Flush sends the response so far to the client, supporting streaming
+// through the recovery middleware.
+func (r *responseRecorder) Flush() {
+	if !r.Written() {
+		r.status = http.StatusOK
 	}
+	_ = http.NewResponseController(r.ResponseWriter).Flush()
+}
 
-	// Set status code if it was set
-	if r.status != 0 {
-		w.WriteHeader(r.status)
+// Hijack lets the handler take over the connection, e.g. for WebSockets.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(r.ResponseWriter).Hijack()
+	if err == nil {
+		r.hijacked = true
 	}
+	return conn, rw, err
 }
 
-
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
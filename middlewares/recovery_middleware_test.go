@@ -1,6 +1,9 @@
 package middlewares_test
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -136,3 +139,112 @@ func TestRecoveryMiddleware_EdgeCases(t *testing.T) {
 		}
 	})
 }
+
+func TestRecoveryMiddlewareWithConfig(t *testing.T) {
+	t.Run("reports panics and renders with the router", func(t *testing.T) {
+		var reports []middlewares.PanicReport
+		reporter := middlewares.PanicReporterFunc(func(ctx context.Context, report middlewares.PanicReport) {
+			reports = append(reports, report)
+		})
+
+		router := rtr.NewRouter()
+		router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.RecoveryMiddlewareWithConfig(middlewares.RecoveryConfig{
+			Reporters: []middlewares.PanicReporter{reporter},
+		})})
+		router.AddRoute(rtr.Get("/panic", func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/panic", nil)
+		req.Header.Set("Accept", "application/json")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError || rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("Expected a JSON 500, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		if len(reports) != 1 || reports[0].Value != "boom" || len(reports[0].Stack) == 0 || reports[0].Request.URL.Path != "/panic" {
+			t.Fatalf("Expected one report with value, stack and request, got %+v", reports)
+		}
+
+		router.SetErrorRenderer(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte("oops"))
+		})
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/panic", nil))
+		if rr.Body.String() != "oops" {
+			t.Errorf("Expected the router's error renderer, got %q", rr.Body.String())
+		}
+	})
+
+	t.Run("custom panic handler", func(t *testing.T) {
+		handler := middlewares.RecoveryMiddlewareWithConfig(middlewares.RecoveryConfig{
+			Reporters: []middlewares.PanicReporter{middlewares.PanicReporterFunc(func(context.Context, middlewares.PanicReport) {})},
+			OnPanic: func(w http.ResponseWriter, r *http.Request, value any, stack []byte) {
+				http.Error(w, fmt.Sprintf("recovered %v", value), http.StatusServiceUnavailable)
+			},
+		}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(42)
+		}))
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusServiceUnavailable || rr.Body.String() != "recovered 42\n" {
+			t.Errorf("Expected the custom response, got %d %q", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("re-panics on ErrAbortHandler", func(t *testing.T) {
+		reported := false
+		handler := middlewares.RecoveryMiddlewareWithConfig(middlewares.RecoveryConfig{
+			Reporters: []middlewares.PanicReporter{middlewares.PanicReporterFunc(func(context.Context, middlewares.PanicReport) {
+				reported = true
+			})},
+		}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}))
+
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler || reported {
+				t.Errorf("Expected ErrAbortHandler to propagate unreported, got %v (reported %v)", p, reported)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	t.Run("supports flushing and hijacking", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		middlewares.RecoveryMiddleware().GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Errorf("Flush failed: %v", err)
+			}
+			panic("after flush")
+		})).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if !rr.Flushed || rr.Code != http.StatusOK {
+			t.Errorf("Expected the flushed response to be kept, got flushed=%v code=%d", rr.Flushed, rr.Code)
+		}
+
+		server := httptest.NewServer(middlewares.RecoveryMiddleware().GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack failed: %v", err)
+				return
+			}
+			defer conn.Close()
+			_, _ = buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+			_ = buf.Flush()
+		})))
+		defer server.Close()
+
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "hijacked" {
+			t.Errorf("Expected the hijacked response, got %q", body)
+		}
+	})
+}
@@ -2,9 +2,14 @@ package middlewares
 
 import (
 	"context"
-	"fmt"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
 	"net/http"
+	"os"
+	"strconv"
 	"sync/atomic"
+	"time"
 
 	"github.com/dracory/rtr"
 )
@@ -21,25 +26,85 @@ var RequestIDKey = requestIDKey(struct{}{})
 // generate unique request IDs without external dependencies.
 var requestIDCounter uint64
 
+// RequestIDConfig configures RequestIDMiddlewareWithConfig.
+type RequestIDConfig struct {
+	// Header is the request and response header carrying the ID. Optional;
+	// defaults to "X-Request-Id".
+	Header string
+
+	// TrustIncoming reuses the ID of incoming requests, e.g. one set by the
+	// load balancer, if it passes Validate. Only enable it behind a proxy
+	// that sets or strips the header, as clients can choose any value.
+	TrustIncoming bool
+	// MaxLength is the longest incoming ID reused. Optional; defaults to
+	// 128.
+	MaxLength int
+	// Validate reports whether an incoming ID is reused. Optional; defaults
+	// to ValidRequestID.
+	Validate func(id string) bool
+
+	// Generator creates the IDs of requests without a reused one. Optional;
+	// defaults to CounterRequestID. IDs of other replicas collide with its
+	// counter values, so use UUIDv7RequestID, ULIDRequestID or
+	// HostnameCounterRequestID when running several.
+	Generator func() string
+}
+
 // RequestIDMiddleware returns a middleware that adds a unique request ID to the
 // context and response headers. The request ID can be retrieved using
 // GetRequestID(ctx).
 func RequestIDMiddleware() rtr.MiddlewareInterface {
+	return RequestIDMiddlewareWithConfig(RequestIDConfig{})
+}
+
+// RequestIDMiddlewareWithConfig returns a middleware that adds a request ID to
+// the context and the config.Header of the request and response, generating
+// it unless a trusted incoming one is reused. The request ID can be retrieved
+// using GetRequestID(ctx) and sent on with RequestIDTransport.
+func RequestIDMiddlewareWithConfig(config RequestIDConfig) rtr.MiddlewareInterface {
+	if config.Header == "" {
+		config.Header = "X-Request-Id"
+	}
+	if config.MaxLength <= 0 {
+		config.MaxLength = 128
+	}
+	if config.Validate == nil {
+		config.Validate = ValidRequestID
+	}
+	if config.Generator == nil {
+		config.Generator = CounterRequestID
+	}
+
 	return rtr.NewMiddleware().
 		SetName("Request ID").
 		SetHandler(func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				// Generate a new request ID from the atomic counter
-				reqID := fmt.Sprintf("%d", atomic.AddUint64(&requestIDCounter, 1))
+				// Reuse a trusted incoming ID, or generate a new one
+				reqID := ""
+				if config.TrustIncoming {
+					incoming := r.Header.Get(config.Header)
+					if incoming != "" && len(incoming) <= config.MaxLength && config.Validate(incoming) {
+						reqID = incoming
+					}
+				}
+				if reqID == "" {
+					reqID = config.Generator()
+				}
 
 				// Create a new context with the request ID
 				ctx := context.WithValue(r.Context(), RequestIDKey, reqID)
+				r = r.WithContext(ctx)
+
+				// Replace an untrusted incoming ID for handlers and proxies,
+				// on a copy so the caller's request is left alone
+				r.Header = r.Header.Clone()
+				r.Header.Set(config.Header, reqID)
 
 				// Set the request ID in the response header
-				w.Header().Set("X-Request-Id", reqID)
+				w.Header().Set(config.Header, reqID)
 
 				// Call the next handler with the new context
-				next.ServeHTTP(w, r.WithContext(ctx))
+				next.ServeHTTP(w, r)
 			})
 		})
 }
@@ -52,3 +117,135 @@ func GetRequestID(ctx context.Context) string {
 	}
 	return ""
 }
+
+// ValidRequestID reports whether id is non-empty and only contains ASCII
+// letters, digits and the characters "-_.:+/=", which covers UUIDs, ULIDs,
+// base64 values and the trace IDs of common load balancers, while keeping
+// log injection out.
+func ValidRequestID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '+', c == '/', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// CounterRequestID returns the next value of a process-wide counter, e.g.
+// "42". It is the default generator.
+func CounterRequestID() string {
+	return strconv.FormatUint(atomic.AddUint64(&requestIDCounter, 1), 10)
+}
+
+// HostnameCounterRequestID returns a generator of counter values prefixed
+// with the hostname, e.g. "web-7d9f-42", which are unique across replicas
+// but repeat after a restart.
+func HostnameCounterRequestID() func() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "localhost"
+	}
+	var counter uint64
+	return func() string {
+		return hostname + "-" + strconv.FormatUint(atomic.AddUint64(&counter, 1), 10)
+	}
+}
+
+// UUIDv7RequestID returns a random RFC 9562 version 7 UUID, e.g.
+// "01928f6e-5a3b-7c1d-9e2f-3a4b5c6d7e8f", whose prefix is the time in
+// milliseconds so IDs sort by creation time.
+func UUIDv7RequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	putMillis(b[:6], time.Now())
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// crockfordBase32 is the alphabet of ULIDs.
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDRequestID returns a random ULID, e.g. "01JAFKZ6W8T3X4Y5Z6A7B8C9DE",
+// whose first 10 characters are the time in milliseconds so IDs sort by
+// creation time.
+func ULIDRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[6:])
+	putMillis(b[:6], time.Now())
+
+	// Encode the 128 bits as 26 characters of 5 bits, the first holding 3
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+// putMillis writes the Unix time of t in milliseconds to the 6 bytes of b,
+// big endian.
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
+
+// RequestIDTransport is an http.RoundTripper adding the request ID of the
+// request's context, see GetRequestID, to outgoing requests, so calls to
+// other services can be correlated. Requests already carrying the header
+// are sent as is.
+//
+//	client := &http.Client{Transport: &middlewares.RequestIDTransport{}}
+//	req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+//	resp, err := client.Do(req)
+type RequestIDTransport struct {
+	// Base sends the requests. Optional; defaults to
+	// http.DefaultTransport.
+	Base http.RoundTripper
+	// Header carries the ID. Optional; defaults to "X-Request-Id".
+	Header string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RequestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = "X-Request-Id"
+	}
+
+	if reqID := GetRequestID(req.Context()); reqID != "" && req.Header.Get(header) == "" {
+		// A RoundTripper must not modify the caller's request
+		req = req.Clone(req.Context())
+		req.Header.Set(header, reqID)
+	}
+	return base.RoundTrip(req)
+}
+
+var _ http.RoundTripper = (*RequestIDTransport)(nil)
@@ -1,9 +1,14 @@
 package middlewares_test
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dracory/rtr/middlewares"
 )
@@ -42,3 +47,141 @@ func TestRequestIDMiddleware(t *testing.T) {
 		t.Fatal("Expected X-Request-Id header to be set")
 	}
 }
+
+func TestRequestIDMiddlewareWithConfig(t *testing.T) {
+	generate := func() string { return "generated" }
+
+	tests := []struct {
+		name     string
+		config   middlewares.RequestIDConfig
+		incoming string
+		want     string
+	}{
+		{"ignores incoming by default", middlewares.RequestIDConfig{Generator: generate}, "lb-123", "generated"},
+		{"trusts valid incoming", middlewares.RequestIDConfig{Generator: generate, TrustIncoming: true}, "lb-123", "lb-123"},
+		{"rejects invalid incoming", middlewares.RequestIDConfig{Generator: generate, TrustIncoming: true}, "a b\nfake log line", "generated"},
+		{"rejects long incoming", middlewares.RequestIDConfig{Generator: generate, TrustIncoming: true, MaxLength: 5}, "lb-123", "generated"},
+		{"custom validation", middlewares.RequestIDConfig{
+			Generator:     generate,
+			TrustIncoming: true,
+			Validate:      func(id string) bool { return strings.HasPrefix(id, "Root=") },
+		}, "Root=1-abc", "Root=1-abc"},
+		{"custom header", middlewares.RequestIDConfig{Generator: generate, TrustIncoming: true, Header: "X-Correlation-Id"}, "lb-123", "lb-123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.config.Header
+			if header == "" {
+				header = "X-Request-Id"
+			}
+			var seen, seenHeader string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = middlewares.GetRequestID(r.Context())
+				seenHeader = r.Header.Get(header)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(header, tt.incoming)
+			w := httptest.NewRecorder()
+			middlewares.RequestIDMiddlewareWithConfig(tt.config).GetHandler()(handler).ServeHTTP(w, req)
+
+			if seen != tt.want || seenHeader != tt.want || w.Header().Get(header) != tt.want {
+				t.Errorf("Expected request ID %q everywhere, got context %q, request %q, response %q",
+					tt.want, seen, seenHeader, w.Header().Get(header))
+			}
+			if got := req.Header.Get(header); got != tt.incoming {
+				t.Errorf("Expected the caller's request header to stay %q, got %q", tt.incoming, got)
+			}
+		})
+	}
+}
+
+func TestRequestIDGenerators(t *testing.T) {
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+
+	first, firstULID := middlewares.UUIDv7RequestID(), middlewares.ULIDRequestID()
+	time.Sleep(2 * time.Millisecond)
+	second, secondULID := middlewares.UUIDv7RequestID(), middlewares.ULIDRequestID()
+
+	if !uuid.MatchString(first) || !uuid.MatchString(second) {
+		t.Errorf("Expected version 7 UUIDs, got %q and %q", first, second)
+	}
+	if !ulid.MatchString(firstULID) || !ulid.MatchString(secondULID) {
+		t.Errorf("Expected ULIDs, got %q and %q", firstULID, secondULID)
+	}
+	if first >= second || firstULID >= secondULID {
+		t.Errorf("Expected IDs to sort by time, got %q, %q and %q, %q", first, second, firstULID, secondULID)
+	}
+	// The ULID's 48-bit timestamp is its first 10 characters
+	if ms := time.Now().UnixMilli(); !strings.HasPrefix(secondULID, encodeULIDTime(ms)[:8]) {
+		t.Errorf("Expected the ULID to start with the current time, got %q", secondULID)
+	}
+
+	generate := middlewares.HostnameCounterRequestID()
+	hostname, _ := os.Hostname()
+	if id := generate(); id != hostname+"-1" {
+		t.Errorf("Expected the hostname and counter, got %q", id)
+	}
+	if id := generate(); id != hostname+"-2" {
+		t.Errorf("Expected the counter to increase, got %q", id)
+	}
+}
+
+// encodeULIDTime encodes the timestamp part of a ULID.
+func encodeULIDTime(ms int64) string {
+	const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	var s [10]byte
+	for i := 9; i >= 0; i-- {
+		s[i] = alphabet[ms&0x1f]
+		ms >>= 5
+	}
+	return string(s[:])
+}
+
+func TestRequestIDTransport(t *testing.T) {
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Get("X-Correlation-Id"))
+	}))
+	defer upstream.Close()
+
+	client := &http.Client{Transport: &middlewares.RequestIDTransport{Header: "X-Correlation-Id"}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		if req.Header.Get("X-Correlation-Id") != "" {
+			t.Error("Expected the caller's request not to be modified")
+		}
+
+		req, _ = http.NewRequestWithContext(r.Context(), http.MethodGet, upstream.URL, nil)
+		req.Header.Set("X-Correlation-Id", "explicit")
+		resp, err = client.Do(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	})
+
+	middleware := middlewares.RequestIDMiddlewareWithConfig(middlewares.RequestIDConfig{
+		Header:    "X-Correlation-Id",
+		Generator: func() string { return "req-1" },
+	})
+	middleware.GetHandler()(handler).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, upstream.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if strings.Join(received, ",") != "req-1,explicit," {
+		t.Errorf("Expected the ID to be propagated unless set, got %q", received)
+	}
+}
@@ -0,0 +1,637 @@
+package middlewares
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr"
+	"golang.org/x/sync/singleflight"
+)
+
+// ResponseCacheConfig configures a ResponseCache.
+type ResponseCacheConfig struct {
+	// Store holds the cached responses. Optional; defaults to a
+	// NewMemoryResponseCacheStore of 64MB.
+	Store ResponseCacheStore
+
+	// QueryParams lists the query parameters that are part of the cache key;
+	// others are ignored. Optional; nil keys on all parameters, an empty
+	// slice ignores the query.
+	QueryParams []string
+	// VaryHeaders lists request headers that are always part of the cache
+	// key, in addition to those named by the response's Vary header.
+	VaryHeaders []string
+
+	// DefaultTTL is how long responses without max-age or s-maxage in their
+	// Cache-Control are fresh. Optional; zero only caches responses with
+	// an explicit lifetime.
+	DefaultTTL time.Duration
+	// StaleWhileRevalidate is how long a response may be served stale while
+	// it is refreshed in the background, unless its Cache-Control sets
+	// stale-while-revalidate. Optional; zero disables it.
+	StaleWhileRevalidate time.Duration
+
+	// Tags are added to every cached response, e.g. the group the middleware
+	// is attached to, for purging with ResponseCache.PurgeTag. Responses of
+	// named routes are also tagged with their route, see
+	// ResponseCache.PurgeRoute.
+	Tags []string
+
+	// MaxEntrySize is the largest response body cached. Optional; defaults
+	// to 1MB, negative means no limit.
+	MaxEntrySize int64
+
+	// SkipFunc reports whether a request bypasses the cache. Optional;
+	// defaults to bypassing requests carrying cookies, since responses are
+	// not keyed by them and a page rendered for one user's session would be
+	// served to everyone. Only replace it, e.g. to ignore analytics cookies,
+	// if no handler behind the cache reads the cookies it lets through.
+	SkipFunc func(r *http.Request) bool
+}
+
+// ResponseCache caches responses of GET and HEAD requests server side, see
+// Handler.
+type ResponseCache struct {
+	config ResponseCacheConfig
+
+	// fills holds the running misses by key, so concurrent misses of the
+	// same key are coalesced.
+	mu    sync.Mutex
+	fills map[string]*cacheFill
+	// revalidations ensures one background refresh per stale key.
+	revalidations singleflight.Group
+}
+
+// cacheFill is a running miss other requests for its key wait for.
+type cacheFill struct {
+	// ready is closed once the waiting requests can go on: when the headers
+	// of the response show it is not cacheable, or when it is complete.
+	ready     chan struct{}
+	readyOnce sync.Once
+	// response is the cacheable response, set before ready is closed, or
+	// nil if it was not cacheable.
+	response *CachedResponse
+	// key is the key response was stored under.
+	key string
+}
+
+// release lets the waiting requests go on.
+func (f *cacheFill) release() {
+	f.readyOnce.Do(func() { close(f.ready) })
+}
+
+// errCacheFillPanicked is logged when the handler refreshing a stale
+// response panicked.
+var errCacheFillPanicked = errors.New("response cache: handler panicked")
+
+// NewResponseCache returns a ResponseCache for config.
+func NewResponseCache(config ResponseCacheConfig) *ResponseCache {
+	if config.Store == nil {
+		config.Store = NewMemoryResponseCacheStore(0)
+	}
+	if config.MaxEntrySize == 0 {
+		config.MaxEntrySize = 1 << 20
+	}
+	vary := make([]string, len(config.VaryHeaders))
+	for i, name := range config.VaryHeaders {
+		vary[i] = http.CanonicalHeaderKey(name)
+	}
+	config.VaryHeaders = vary
+	if config.SkipFunc == nil {
+		config.SkipFunc = func(r *http.Request) bool { return r.Header.Get("Cookie") != "" }
+	}
+	return &ResponseCache{config: config, fills: make(map[string]*cacheFill)}
+}
+
+// ResponseCacheMiddleware returns a middleware caching responses, see
+// ResponseCache.Handler. Use NewResponseCache to keep a handle for purging.
+func ResponseCacheMiddleware(config ResponseCacheConfig) rtr.MiddlewareInterface {
+	return NewResponseCache(config).Middleware()
+}
+
+// Middleware returns the cache as a middleware.
+func (c *ResponseCache) Middleware() rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName("Response Cache").
+		SetHandler(c.Handler)
+}
+
+// PurgeRoute removes the cached responses of the route with the given name.
+func (c *ResponseCache) PurgeRoute(ctx context.Context, name string) error {
+	return c.config.Store.PurgeTag(ctx, responseCacheRouteTag(name))
+}
+
+// PurgeTag removes the cached responses tagged with tag, see
+// ResponseCacheConfig.Tags.
+func (c *ResponseCache) PurgeTag(ctx context.Context, tag string) error {
+	return c.config.Store.PurgeTag(ctx, tag)
+}
+
+// Handler caches the responses of next to GET and HEAD requests without an
+// Authorization header, cookies (see ResponseCacheConfig.SkipFunc), an
+// Upgrade header or an Accept header asking for an event stream. Responses
+// are keyed by method, host, path, the selected query parameters and request
+// headers, and the headers named by their Vary header; cookies are not part
+// of the key.
+//
+// What is cached is decided by the handler's Cache-Control header, as a
+// shared cache would: no-store, no-cache and private responses are not
+// cached, s-maxage takes precedence over max-age, and stale-while-revalidate
+// is honored. Responses setting cookies, with "Vary: *" or streaming events
+// are never cached. Request Cache-Control directives are ignored, so clients
+// cannot bypass the cache.
+//
+// A fresh response is served with an Age header and "X-Cache: HIT". A stale
+// one within its stale-while-revalidate window is served with
+// "X-Cache: STALE" while a single background request refreshes it. Concurrent
+// misses of the same key are coalesced, so only one of them runs next while
+// the others wait for its response. They stop waiting and run next
+// themselves as soon as its headers show it cannot be cached.
+func (c *ResponseCache) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.primaryKey(r)
+		cached, err := c.lookup(r.Context(), key, r)
+		if err != nil {
+			logResponseCacheError(r.Context(), "lookup", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if now := time.Now(); cached != nil && now.Before(cached.StaleUntil) {
+			if now.Before(cached.FreshUntil) {
+				serveCachedResponse(w, r, cached, "HIT")
+				return
+			}
+			c.revalidate(next, r, key)
+			serveCachedResponse(w, r, cached, "STALE")
+			return
+		}
+
+		c.fill(w, r, next, key)
+	})
+}
+
+// skip reports whether r bypasses the cache.
+func (c *ResponseCache) skip(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+	if r.Header.Get("Authorization") != "" || r.Header.Get("Upgrade") != "" {
+		return true
+	}
+	for _, accept := range r.Header.Values("Accept") {
+		if strings.Contains(strings.ToLower(accept), "text/event-stream") {
+			return true
+		}
+	}
+	return c.config.SkipFunc(r)
+}
+
+// fill serves a miss, coalescing concurrent misses of key: one request runs
+// next and the others are served its response if it is cacheable and
+// applies to them, or run next themselves otherwise.
+func (c *ResponseCache) fill(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	w.Header().Set("X-Cache", "MISS")
+
+	c.mu.Lock()
+	if fill, ok := c.fills[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-fill.ready:
+		case <-r.Context().Done():
+			return
+		}
+		if fill.response != nil && c.entryKey(key, fill.response.Vary, r) == fill.key {
+			serveCachedResponse(w, r, fill.response, "HIT")
+			return
+		}
+		next.ServeHTTP(w, r)
+		return
+	}
+	fill := &cacheFill{ready: make(chan struct{})}
+	c.fills[key] = fill
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.fills, key)
+		c.mu.Unlock()
+		fill.release()
+	}()
+
+	// Waiting requests are released as soon as the response turns out not
+	// to be cacheable, so slow or streaming responses are not serialised.
+	released := false
+	rec := newCacheRecorder(w, c.config.MaxEntrySize)
+	rec.onHeader = func() {
+		if _, ok := c.policy(rec.status, rec.header); !ok || rec.hijacked {
+			released = true
+			fill.release()
+		}
+	}
+	next.ServeHTTP(rec, r)
+	rec.finish()
+
+	saved := c.save(r.Context(), r, key, rec)
+	if !released {
+		fill.response, fill.key = saved.response, saved.key
+	}
+}
+
+// revalidate refreshes the stale response under key in the background,
+// unless a refresh is already running. The stale response is kept if the
+// refresh fails with a server error, and removed if the new response is not
+// cacheable.
+func (c *ResponseCache) revalidate(next http.Handler, r *http.Request, key string) {
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	c.revalidations.DoChan(key, func() (any, error) {
+		defer func() {
+			if p := recover(); p != nil {
+				logResponseCacheError(req.Context(), "revalidation", errCacheFillPanicked)
+			}
+		}()
+
+		rec := newCacheRecorder(nil, c.config.MaxEntrySize)
+		next.ServeHTTP(rec, req)
+		rec.finish()
+		if fill := c.save(req.Context(), req, key, rec); fill.response == nil && rec.status < http.StatusInternalServerError {
+			if err := c.config.Store.Delete(req.Context(), key); err != nil {
+				logResponseCacheError(req.Context(), "delete", err)
+			}
+		}
+		return nil, nil
+	})
+}
+
+// lookup returns the response cached for r under key, following Vary
+// markers, or nil.
+func (c *ResponseCache) lookup(ctx context.Context, key string, r *http.Request) (*CachedResponse, error) {
+	cached, err := c.config.Store.Get(ctx, key)
+	if err != nil || cached == nil || len(cached.Vary) == 0 {
+		return cached, err
+	}
+	return c.config.Store.Get(ctx, c.entryKey(key, cached.Vary, r))
+}
+
+// cachedFill is the outcome of filling the cache for a key.
+type cachedFill struct {
+	// response is the cacheable response, or nil if it was not cacheable.
+	response *CachedResponse
+	// key is the key response was stored under.
+	key string
+}
+
+// save stores the response recorded by rec if it is cacheable.
+func (c *ResponseCache) save(ctx context.Context, r *http.Request, key string, rec *cacheRecorder) cachedFill {
+	response := c.cacheable(r, rec)
+	if response == nil {
+		return cachedFill{}
+	}
+
+	entryKey := c.entryKey(key, response.Vary, r)
+	if entryKey != key {
+		marker := &CachedResponse{
+			StoredAt:   response.StoredAt,
+			FreshUntil: response.FreshUntil,
+			StaleUntil: response.StaleUntil,
+			Vary:       response.Vary,
+			Tags:       response.Tags,
+		}
+		if err := c.config.Store.Set(ctx, key, marker); err != nil {
+			logResponseCacheError(ctx, "store", err)
+			return cachedFill{}
+		}
+	}
+	if err := c.config.Store.Set(ctx, entryKey, response); err != nil {
+		logResponseCacheError(ctx, "store", err)
+		return cachedFill{}
+	}
+	return cachedFill{response: response, key: entryKey}
+}
+
+// cacheable returns the response recorded by rec as a CachedResponse, or
+// nil if it may not be cached.
+func (c *ResponseCache) cacheable(r *http.Request, rec *cacheRecorder) *CachedResponse {
+	if rec.hijacked || rec.tooLarge {
+		return nil
+	}
+	policy, ok := c.policy(rec.status, rec.header)
+	if !ok {
+		return nil
+	}
+
+	tags := slices.Clone(c.config.Tags)
+	if route := rtr.GetRoute(r); route != nil && route.GetName() != "" {
+		tags = append(tags, responseCacheRouteTag(route.GetName()))
+	}
+
+	now := time.Now()
+	return &CachedResponse{
+		Status:     rec.status,
+		Header:     rec.header,
+		Body:       rec.body,
+		StoredAt:   now,
+		FreshUntil: now.Add(policy.ttl),
+		StaleUntil: now.Add(policy.ttl + policy.stale),
+		Vary:       policy.vary,
+		Tags:       tags,
+	}
+}
+
+// responseCachePolicy is how long a response may be cached, and the request
+// headers it varies on.
+type responseCachePolicy struct {
+	ttl   time.Duration
+	stale time.Duration
+	vary  []string
+}
+
+// policy returns how a response with status and header may be cached, or
+// false if it may not be, as decided by its headers alone.
+func (c *ResponseCache) policy(status int, header http.Header) (responseCachePolicy, bool) {
+	if !responseCacheableStatus(status) || len(header.Values("Set-Cookie")) > 0 {
+		return responseCachePolicy{}, false
+	}
+	if mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type")); mediaType == "text/event-stream" {
+		return responseCachePolicy{}, false
+	}
+
+	var vary []string
+	for _, value := range header.Values("Vary") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return responseCachePolicy{}, false
+			}
+			if name != "" && !slices.Contains(vary, name) {
+				vary = append(vary, name)
+			}
+		}
+	}
+	slices.Sort(vary)
+
+	directives := parseCacheControl(strings.Join(header.Values("Cache-Control"), ","))
+	if _, ok := directives["no-store"]; ok {
+		return responseCachePolicy{}, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return responseCachePolicy{}, false
+	}
+	if _, ok := directives["private"]; ok {
+		return responseCachePolicy{}, false
+	}
+
+	ttl := c.config.DefaultTTL
+	if seconds, ok := cacheControlSeconds(directives, "s-maxage"); ok {
+		ttl = seconds
+	} else if seconds, ok := cacheControlSeconds(directives, "max-age"); ok {
+		ttl = seconds
+	}
+	if ttl <= 0 {
+		return responseCachePolicy{}, false
+	}
+	stale := c.config.StaleWhileRevalidate
+	if seconds, ok := cacheControlSeconds(directives, "stale-while-revalidate"); ok {
+		stale = seconds
+	}
+
+	return responseCachePolicy{ttl: ttl, stale: max(stale, 0), vary: vary}, true
+}
+
+// primaryKey returns the cache key of r before applying response Vary
+// headers.
+func (c *ResponseCache) primaryKey(r *http.Request) string {
+	query := r.URL.Query()
+	if c.config.QueryParams != nil {
+		selected := url.Values{}
+		for _, name := range c.config.QueryParams {
+			if values, ok := query[name]; ok {
+				selected[name] = values
+			}
+		}
+		query = selected
+	}
+
+	var b strings.Builder
+	b.WriteString(r.Method)
+	b.WriteString(" ")
+	b.WriteString(strings.ToLower(r.Host))
+	b.WriteString(r.URL.EscapedPath())
+	b.WriteString("?")
+	b.WriteString(query.Encode())
+	writeVaryValues(&b, c.config.VaryHeaders, r)
+	return b.String()
+}
+
+// entryKey returns the key of the response for r under key, given the
+// headers the response varies on.
+func (c *ResponseCache) entryKey(key string, vary []string, r *http.Request) string {
+	if len(vary) == 0 {
+		return key
+	}
+	var b strings.Builder
+	b.WriteString(key)
+	b.WriteString("\nvary")
+	writeVaryValues(&b, vary, r)
+	return b.String()
+}
+
+// writeVaryValues appends the values of the named request headers to b.
+func writeVaryValues(b *strings.Builder, names []string, r *http.Request) {
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(": ")
+		b.WriteString(strings.Join(r.Header.Values(name), ", "))
+	}
+}
+
+// serveCachedResponse writes cached to w, marking it with an Age and an
+// X-Cache header.
+func serveCachedResponse(w http.ResponseWriter, r *http.Request, cached *CachedResponse, status string) {
+	h := w.Header()
+	for name, values := range cached.Header {
+		h[name] = slices.Clone(values)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+	h.Set("X-Cache", status)
+	w.WriteHeader(cached.Status)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(cached.Body)
+	}
+}
+
+// responseCacheableStatus reports whether responses with status may be
+// cached, following the statuses cacheable by default in RFC 9110.
+func responseCacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusPermanentRedirect,
+		http.StatusNotFound, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+// responseCacheRouteTag returns the tag of responses of the named route.
+func responseCacheRouteTag(name string) string {
+	return "route:" + name
+}
+
+// parseCacheControl parses a Cache-Control header into its lowercased
+// directives and their unquoted values.
+func parseCacheControl(header string) map[string]string {
+	directives := map[string]string{}
+	for part := range strings.SplitSeq(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+		directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+	}
+	return directives
+}
+
+// cacheControlSeconds returns the duration of a delta-seconds directive.
+func cacheControlSeconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// logResponseCacheError logs a failed store operation.
+func logResponseCacheError(ctx context.Context, operation string, err error) {
+	slog.Default().ErrorContext(ctx, "response cache: "+operation+" failed", "error", err.Error())
+}
+
+// cacheRecorder records a response for the cache while writing it to w, if
+// any. It only buffers the body up to maxSize.
+type cacheRecorder struct {
+	w http.ResponseWriter
+	// header holds the headers set by the handler, separate from those set
+	// by outer middlewares on w.
+	header   http.Header
+	status   int
+	body     []byte
+	maxSize  int64
+	tooLarge bool
+	hijacked bool
+	// onHeader, if set, is called once the status and headers are known,
+	// or the connection was hijacked.
+	onHeader func()
+}
+
+func newCacheRecorder(w http.ResponseWriter, maxSize int64) *cacheRecorder {
+	return &cacheRecorder{w: w, header: http.Header{}, maxSize: maxSize}
+}
+
+func (rec *cacheRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *cacheRecorder) WriteHeader(code int) {
+	if rec.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		if rec.w != nil {
+			rec.copyHeader()
+			rec.w.WriteHeader(code)
+		}
+		return
+	}
+	rec.status = code
+	rec.header = rec.header.Clone()
+	if rec.onHeader != nil {
+		rec.onHeader()
+	}
+	if rec.w != nil {
+		rec.copyHeader()
+		rec.w.WriteHeader(code)
+	}
+}
+
+// copyHeader copies the handler's headers to w.
+func (rec *cacheRecorder) copyHeader() {
+	h := rec.w.Header()
+	for name, values := range rec.header {
+		h[name] = slices.Clone(values)
+	}
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.tooLarge {
+		if rec.maxSize >= 0 && int64(len(rec.body)+len(b)) > rec.maxSize {
+			rec.tooLarge = true
+			rec.body = nil
+		} else {
+			rec.body = append(rec.body, b...)
+		}
+	}
+	if rec.w == nil {
+		return len(b), nil
+	}
+	return rec.w.Write(b)
+}
+
+// finish completes a response the handler did not write.
+func (rec *cacheRecorder) finish() {
+	if rec.status == 0 && !rec.hijacked {
+		rec.WriteHeader(http.StatusOK)
+	}
+}
+
+// Flush sends the response so far to the client.
+func (rec *cacheRecorder) Flush() {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if rec.w != nil {
+		_ = http.NewResponseController(rec.w).Flush()
+	}
+}
+
+// Hijack lets the handler take over the connection. Such responses are not
+// cached.
+func (rec *cacheRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if rec.w == nil {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := http.NewResponseController(rec.w).Hijack()
+	if err == nil {
+		rec.hijacked = true
+		if rec.onHeader != nil {
+			rec.onHeader()
+		}
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.w
+}
@@ -0,0 +1,352 @@
+package middlewares_test
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// agingResponseCacheStore is a memory store whose entries can be aged.
+type agingResponseCacheStore struct {
+	middlewares.ResponseCacheStore
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func newAgingResponseCacheStore() *agingResponseCacheStore {
+	return &agingResponseCacheStore{
+		ResponseCacheStore: middlewares.NewMemoryResponseCacheStore(0),
+		keys:               map[string]bool{},
+	}
+}
+
+func (s *agingResponseCacheStore) Set(ctx context.Context, key string, response *middlewares.CachedResponse) error {
+	s.mu.Lock()
+	s.keys[key] = true
+	s.mu.Unlock()
+	return s.ResponseCacheStore.Set(ctx, key, response)
+}
+
+// age moves the freshness of every entry d into the past.
+func (s *agingResponseCacheStore) age(d time.Duration) {
+	s.mu.Lock()
+	keys := slices.Collect(maps.Keys(s.keys))
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	for _, key := range keys {
+		cached, _ := s.Get(ctx, key)
+		if cached == nil {
+			continue
+		}
+		aged := *cached
+		aged.StoredAt = aged.StoredAt.Add(-d)
+		aged.FreshUntil = aged.FreshUntil.Add(-d)
+		aged.StaleUntil = aged.StaleUntil.Add(-d)
+		_ = s.Set(ctx, key, &aged)
+	}
+}
+
+// cacheRequest serves a GET request for target, returning the recorder.
+func cacheRequest(handler http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestResponseCacheMiddleware_CachesByKey(t *testing.T) {
+	var calls atomic.Int32
+	handler := middlewares.ResponseCacheMiddleware(middlewares.ResponseCacheConfig{
+		QueryParams: []string{"page"},
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%s %s #%d", r.Host, r.URL.Query().Get("page"), n)
+	}))
+
+	miss := cacheRequest(handler, "http://example.com/list?page=1&utm_source=mail")
+	if miss.Header().Get("X-Cache") != "MISS" || miss.Body.String() != "example.com 1 #1" {
+		t.Fatalf("Expected a miss, got %q %q", miss.Header().Get("X-Cache"), miss.Body.String())
+	}
+
+	hit := cacheRequest(handler, "http://EXAMPLE.com/list?utm_source=web&page=1")
+	if hit.Header().Get("X-Cache") != "HIT" || hit.Body.String() != "example.com 1 #1" {
+		t.Errorf("Expected a hit ignoring unselected params, got %q %q", hit.Header().Get("X-Cache"), hit.Body.String())
+	}
+	if hit.Header().Get("Age") != "0" || hit.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Expected cached headers and an Age, got %v", hit.Header())
+	}
+
+	for _, target := range []string{"http://example.com/list?page=2", "http://example.org/list?page=1", "http://example.com/other?page=1"} {
+		if rr := cacheRequest(handler, target); rr.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("Expected %s to be a different key", target)
+		}
+	}
+	if calls.Load() != 4 {
+		t.Errorf("Expected 4 handler calls, got %d", calls.Load())
+	}
+}
+
+func TestResponseCacheMiddleware_CacheControl(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     middlewares.ResponseCacheConfig
+		header     map[string]string
+		status     int
+		request    []string
+		wantCached bool
+	}{
+		{name: "max-age", header: map[string]string{"Cache-Control": "max-age=60"}, wantCached: true},
+		{name: "s-maxage", header: map[string]string{"Cache-Control": "max-age=0, s-maxage=60"}, wantCached: true},
+		{name: "no lifetime", wantCached: false},
+		{name: "default ttl", config: middlewares.ResponseCacheConfig{DefaultTTL: time.Minute}, wantCached: true},
+		{name: "no-store", config: middlewares.ResponseCacheConfig{DefaultTTL: time.Minute}, header: map[string]string{"Cache-Control": "no-store"}},
+		{name: "no-cache", header: map[string]string{"Cache-Control": "no-cache, max-age=60"}},
+		{name: "private", header: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{name: "set-cookie", header: map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "id=1"}},
+		{name: "vary star", header: map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}},
+		{name: "not found", header: map[string]string{"Cache-Control": "max-age=60"}, status: http.StatusNotFound, wantCached: true},
+		{name: "server error", header: map[string]string{"Cache-Control": "max-age=60"}, status: http.StatusInternalServerError},
+		{name: "authorization", header: map[string]string{"Cache-Control": "max-age=60"}, request: []string{"Authorization", "Bearer x"}},
+		{name: "cookie", header: map[string]string{"Cache-Control": "max-age=60"}, request: []string{"Cookie", "session=1"}},
+		{name: "cookie allowed by skip func", config: middlewares.ResponseCacheConfig{SkipFunc: func(r *http.Request) bool { return false }},
+			header: map[string]string{"Cache-Control": "max-age=60"}, request: []string{"Cookie", "_ga=1"}, wantCached: true},
+		{name: "event stream request", header: map[string]string{"Cache-Control": "max-age=60"}, request: []string{"Accept", "text/event-stream"}},
+		{name: "upgrade request", header: map[string]string{"Cache-Control": "max-age=60"}, request: []string{"Upgrade", "websocket"}},
+		{name: "event stream response", header: map[string]string{"Cache-Control": "max-age=60", "Content-Type": "text/event-stream"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			handler := middlewares.ResponseCacheMiddleware(tt.config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				_, _ = w.Write([]byte("body"))
+			}))
+
+			cacheRequest(handler, "/page", tt.request...)
+			rr := cacheRequest(handler, "/page", tt.request...)
+			if cached := calls.Load() == 1; cached != tt.wantCached {
+				t.Errorf("Expected cached %v, got %d handler calls", tt.wantCached, calls.Load())
+			}
+			if rr.Body.String() != "body" {
+				t.Errorf("Expected the body to be served, got %q", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestResponseCacheMiddleware_Vary(t *testing.T) {
+	var calls atomic.Int32
+	vary := []string{"x-tenant"}
+	handler := middlewares.ResponseCacheMiddleware(middlewares.ResponseCacheConfig{
+		VaryHeaders: vary,
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		fmt.Fprint(w, r.Header.Get("X-Tenant")+":"+r.Header.Get("Accept-Language"))
+	}))
+
+	for _, tc := range []struct {
+		tenant, language, want string
+	}{
+		{"a", "en", "a:en"},
+		{"a", "de", "a:de"},
+		{"b", "en", "b:en"},
+		{"a", "en", "a:en"},
+		{"a", "de", "a:de"},
+	} {
+		rr := cacheRequest(handler, "/page", "X-Tenant", tc.tenant, "Accept-Language", tc.language)
+		if rr.Body.String() != tc.want {
+			t.Errorf("Expected %q, got %q", tc.want, rr.Body.String())
+		}
+	}
+	if calls.Load() != 3 {
+		t.Errorf("Expected one handler call per variant, got %d", calls.Load())
+	}
+	if vary[0] != "x-tenant" {
+		t.Errorf("Expected the caller's VaryHeaders to be left alone, got %q", vary[0])
+	}
+}
+
+func TestResponseCacheMiddleware_StaleWhileRevalidate(t *testing.T) {
+	store := newAgingResponseCacheStore()
+	var calls, status atomic.Int32
+	status.Store(http.StatusOK)
+	handler := middlewares.ResponseCacheMiddleware(middlewares.ResponseCacheConfig{
+		Store: store,
+	}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60, stale-while-revalidate=30")
+		w.WriteHeader(int(status.Load()))
+		fmt.Fprintf(w, "#%d", n)
+	}))
+
+	cacheRequest(handler, "/page")
+
+	store.age(70 * time.Second)
+	rr := cacheRequest(handler, "/page")
+	if rr.Header().Get("X-Cache") != "STALE" || rr.Body.String() != "#1" || rr.Header().Get("Age") != "70" {
+		t.Fatalf("Expected the stale response, got %q %q age %q", rr.Header().Get("X-Cache"), rr.Body.String(), rr.Header().Get("Age"))
+	}
+	waitFor(t, func() bool {
+		rr := cacheRequest(handler, "/page")
+		return rr.Header().Get("X-Cache") == "HIT" && rr.Body.String() != "#1"
+	})
+
+	status.Store(http.StatusInternalServerError)
+	before := calls.Load()
+	store.age(70 * time.Second)
+	cacheRequest(handler, "/page")
+	waitFor(t, func() bool { return calls.Load() > before })
+	time.Sleep(20 * time.Millisecond)
+	if rr := cacheRequest(handler, "/page"); rr.Code != http.StatusOK || rr.Header().Get("X-Cache") != "STALE" {
+		t.Errorf("Expected a failed revalidation to keep the stale response, got %d %q", rr.Code, rr.Header().Get("X-Cache"))
+	}
+
+	store.age(time.Hour)
+	if rr := cacheRequest(handler, "/page"); rr.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected a response past its stale window to be a miss, got %q", rr.Header().Get("X-Cache"))
+	}
+}
+
+func TestResponseCacheMiddleware_Coalescing(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	handler := middlewares.ResponseCacheMiddleware(middlewares.ResponseCacheConfig{}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprint(w, "expensive")
+	}))
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i] = cacheRequest(handler, "/report").Body.String()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected concurrent misses to be coalesced, got %d handler calls", calls.Load())
+	}
+	for _, body := range bodies {
+		if body != "expensive" {
+			t.Errorf("Expected every request to get the response, got %q", body)
+		}
+	}
+}
+
+func TestResponseCacheMiddleware_CoalescingReleasesUncacheable(t *testing.T) {
+	release := make(chan struct{})
+	var calls atomic.Int32
+	handler := middlewares.ResponseCacheMiddleware(middlewares.ResponseCacheConfig{}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// The first request streams until released, without a lifetime
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-release
+		}
+		fmt.Fprint(w, "live")
+	}))
+
+	leader := make(chan struct{})
+	go func() {
+		defer close(leader)
+		cacheRequest(handler, "/feed")
+	}()
+	waitFor(t, func() bool { return calls.Load() == 1 })
+
+	done := make(chan string, 1)
+	go func() { done <- cacheRequest(handler, "/feed").Body.String() }()
+	select {
+	case body := <-done:
+		if body != "live" {
+			t.Errorf("Expected the follower to run the handler, got %q", body)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a follower not to wait for an uncacheable response")
+	}
+	close(release)
+	<-leader
+}
+
+func TestResponseCache_Purge(t *testing.T) {
+	var calls atomic.Int32
+	cache := middlewares.NewResponseCache(middlewares.ResponseCacheConfig{Tags: []string{"blog"}})
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{cache.Middleware()})
+	for _, name := range []string{"posts", "authors"} {
+		router.AddRoute(rtr.Get("/"+name, func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			fmt.Fprint(w, name)
+		}).SetName(name))
+	}
+
+	warm := func() {
+		cacheRequest(router, "/posts")
+		cacheRequest(router, "/authors")
+	}
+	warm()
+	warm()
+	if calls.Load() != 2 {
+		t.Fatalf("Expected both routes to be cached, got %d handler calls", calls.Load())
+	}
+
+	if err := cache.PurgeRoute(context.Background(), "posts"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	warm()
+	if calls.Load() != 3 {
+		t.Errorf("Expected only the purged route to be refreshed, got %d handler calls", calls.Load())
+	}
+
+	if err := cache.PurgeTag(context.Background(), "blog"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	warm()
+	if calls.Load() != 5 {
+		t.Errorf("Expected the purged tag to refresh both routes, got %d handler calls", calls.Load())
+	}
+}
+
+// waitFor polls condition until it holds or a second has passed.
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,181 @@
+package middlewares
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a response stored by ResponseCacheMiddleware.
+type CachedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+	// StoredAt is when the response was generated.
+	StoredAt time.Time `json:"stored_at"`
+	// FreshUntil is when the response becomes stale.
+	FreshUntil time.Time `json:"fresh_until"`
+	// StaleUntil is when the response may no longer be served stale while it
+	// is revalidated. Stores may drop the response after it.
+	StaleUntil time.Time `json:"stale_until"`
+	// Vary lists the request headers the response varies on. A response with
+	// Vary is stored under its primary key as a marker without body, and in
+	// full under a key including the values of these headers.
+	Vary []string `json:"vary,omitempty"`
+	// Tags are the purge tags of the response, see
+	// ResponseCacheStore.PurgeTag.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// size returns the approximate memory used by the response.
+func (c *CachedResponse) size() int64 {
+	size := int64(len(c.Body)) + 64
+	for k, vv := range c.Header {
+		size += int64(len(k))
+		for _, v := range vv {
+			size += int64(len(v))
+		}
+	}
+	for _, s := range c.Vary {
+		size += int64(len(s))
+	}
+	for _, s := range c.Tags {
+		size += int64(len(s))
+	}
+	return size
+}
+
+// ResponseCacheStore stores responses for ResponseCacheMiddleware.
+// Implementations must be safe for concurrent use. Sharing a store between
+// several cache middlewares, e.g. one per group, lets them be purged
+// together.
+type ResponseCacheStore interface {
+	// Get returns the response stored under key, or nil if there is none or
+	// it is past its StaleUntil.
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	// Set stores response under key, replacing any previous one. The
+	// response must not be modified afterwards.
+	Set(ctx context.Context, key string, response *CachedResponse) error
+	// Delete removes the response stored under key. Deleting a missing key
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+	// PurgeTag removes every response tagged with tag.
+	PurgeTag(ctx context.Context, tag string) error
+}
+
+// NewMemoryResponseCacheStore returns an in-process ResponseCacheStore
+// holding up to maxBytes of responses, evicting the least recently used
+// ones beyond that. A maxBytes of zero or less defaults to 64MB.
+func NewMemoryResponseCacheStore(maxBytes int64) ResponseCacheStore {
+	if maxBytes <= 0 {
+		maxBytes = 64 << 20
+	}
+	return &memoryResponseCacheStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		tags:     make(map[string]map[string]struct{}),
+	}
+}
+
+// memoryResponseCacheStore is an LRU ResponseCacheStore with a byte budget.
+type memoryResponseCacheStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	// lru holds *memoryResponseCacheEntry, most recently used first.
+	lru     *list.List
+	entries map[string]*list.Element
+	// tags maps each tag to the keys tagged with it.
+	tags map[string]map[string]struct{}
+}
+
+// memoryResponseCacheEntry is an element of the LRU list.
+type memoryResponseCacheEntry struct {
+	key      string
+	response *CachedResponse
+	size     int64
+}
+
+func (s *memoryResponseCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := elem.Value.(*memoryResponseCacheEntry)
+	if time.Now().After(entry.response.StaleUntil) {
+		s.removeLocked(elem)
+		return nil, nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.response, nil
+}
+
+func (s *memoryResponseCacheStore) Set(ctx context.Context, key string, response *CachedResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.removeLocked(elem)
+	}
+
+	entry := &memoryResponseCacheEntry{key: key, response: response, size: response.size() + int64(len(key))}
+	if entry.size > s.maxBytes {
+		return nil
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	s.size += entry.size
+	for _, tag := range response.Tags {
+		if s.tags[tag] == nil {
+			s.tags[tag] = make(map[string]struct{})
+		}
+		s.tags[tag][key] = struct{}{}
+	}
+
+	for s.size > s.maxBytes {
+		s.removeLocked(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *memoryResponseCacheStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		s.removeLocked(elem)
+	}
+	return nil
+}
+
+func (s *memoryResponseCacheStore) PurgeTag(ctx context.Context, tag string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.tags[tag] {
+		if elem, ok := s.entries[key]; ok {
+			s.removeLocked(elem)
+		}
+	}
+	delete(s.tags, tag)
+	return nil
+}
+
+// removeLocked removes elem from the store. The caller must hold s.mu.
+func (s *memoryResponseCacheStore) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*memoryResponseCacheEntry)
+	s.lru.Remove(elem)
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+	for _, tag := range entry.response.Tags {
+		delete(s.tags[tag], entry.key)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+}
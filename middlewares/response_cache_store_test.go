@@ -0,0 +1,64 @@
+package middlewares_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr/middlewares"
+)
+
+func TestMemoryResponseCacheStore(t *testing.T) {
+	ctx := context.Background()
+	store := middlewares.NewMemoryResponseCacheStore(1000)
+	entry := func(tags ...string) *middlewares.CachedResponse {
+		return &middlewares.CachedResponse{
+			Status:     200,
+			Body:       bytes.Repeat([]byte("x"), 300),
+			StaleUntil: time.Now().Add(time.Hour),
+			Tags:       tags,
+		}
+	}
+
+	_ = store.Set(ctx, "a", entry("pages"))
+	_ = store.Set(ctx, "b", entry("pages"))
+	if cached, _ := store.Get(ctx, "a"); cached == nil {
+		t.Fatal("Expected a to be cached")
+	}
+	_ = store.Set(ctx, "c", entry())
+
+	if cached, _ := store.Get(ctx, "b"); cached != nil {
+		t.Error("Expected the least recently used entry to be evicted over the byte budget")
+	}
+	if cached, _ := store.Get(ctx, "a"); cached == nil {
+		t.Error("Expected a recently used entry to be kept")
+	}
+
+	_ = store.Set(ctx, "huge", &middlewares.CachedResponse{Body: make([]byte, 2000), StaleUntil: time.Now().Add(time.Hour)})
+	if cached, _ := store.Get(ctx, "huge"); cached != nil {
+		t.Error("Expected an entry over the budget not to be stored")
+	}
+
+	if err := store.PurgeTag(ctx, "pages"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cached, _ := store.Get(ctx, "a"); cached != nil {
+		t.Error("Expected tagged entries to be purged")
+	}
+	if cached, _ := store.Get(ctx, "c"); cached == nil {
+		t.Error("Expected untagged entries to be kept")
+	}
+
+	expired := entry()
+	expired.StaleUntil = time.Now().Add(-time.Second)
+	_ = store.Set(ctx, "expired", expired)
+	if cached, _ := store.Get(ctx, "expired"); cached != nil {
+		t.Error("Expected an entry past StaleUntil not to be returned")
+	}
+
+	_ = store.Delete(ctx, "c")
+	if cached, _ := store.Get(ctx, "c"); cached != nil {
+		t.Error("Expected a deleted entry to be gone")
+	}
+}
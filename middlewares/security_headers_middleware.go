@@ -1,13 +1,20 @@
 package middlewares
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/dracory/rtr"
 )
 
+// cspNonceKey is the context key used to store the per-request CSP nonce.
+type cspNonceKey struct{}
+
 // SecurityHeadersConfig provides configuration for security headers middleware
 type SecurityHeadersConfig struct {
 	// Content Security Policy configuration
@@ -26,6 +33,14 @@ type SecurityHeadersConfig struct {
 	PermissionsPolicy map[string][]string
 	// Custom headers allows adding custom security headers
 	CustomHeaders map[string]string
+	// RouteCSP replaces CSP for requests whose matched route has the given
+	// name (see rtr.RouteInterface.SetName). A nil or disabled entry removes
+	// the CSP header for that route.
+	RouteCSP map[string]*CSPConfig
+	// CSPReportURI is the path of a CSPReportRoute. It fills in ReportURI, and
+	// ReportToURL when ReportTo is set, for every policy that does not set
+	// its own.
+	CSPReportURI string
 }
 
 // CSPConfig configures Content Security Policy
@@ -43,6 +58,28 @@ type CSPConfig struct {
 	WorkerSrc               []string
 	ManifestSrc             []string
 	UpgradeInsecureRequests bool
+
+	// Nonce generates a cryptographically random nonce per request and adds
+	// 'nonce-…' to script-src and style-src. Templates read it with
+	// GetCSPNonce. An empty ScriptSrc or StyleSrc starts from DefaultSrc, so
+	// the sources it allows keep working. Browsers ignore 'unsafe-inline' in
+	// a directive with a nonce, so inline scripts and styles then need the
+	// nonce attribute.
+	Nonce bool
+	// StrictDynamic adds 'strict-dynamic' to script-src, letting scripts
+	// loaded by nonced scripts run. Use it together with Nonce.
+	StrictDynamic bool
+	// ReportOnly sends the policy as Content-Security-Policy-Report-Only, so
+	// violations are reported but not blocked.
+	ReportOnly bool
+	// ReportURI adds a report-uri directive.
+	ReportURI string
+	// ReportTo adds a report-to directive naming a Reporting API endpoint
+	// group.
+	ReportTo string
+	// ReportToURL, when set together with ReportTo, emits a
+	// Reporting-Endpoints header mapping the ReportTo group to this URL.
+	ReportToURL string
 }
 
 // HSTSConfig configures HTTP Strict Transport Security
@@ -138,14 +175,7 @@ func NewSecurityHeadersMiddleware(config *SecurityHeadersConfig) rtr.MiddlewareI
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				// Set HSTS header
 				if config.HSTS != nil && config.HSTS.Enabled {
-					hstsValue := fmt.Sprintf("max-age=%d", config.HSTS.MaxAge)
-					if config.HSTS.IncludeSubDomains {
-						hstsValue += "; includeSubDomains"
-					}
-					if config.HSTS.Preload {
-						hstsValue += "; preload"
-					}
-					w.Header().Set("Strict-Transport-Security", hstsValue)
+					w.Header().Set("Strict-Transport-Security", buildHSTSValue(config.HSTS))
 				}
 
 				// Set Frame Options header
@@ -186,10 +216,43 @@ func NewSecurityHeadersMiddleware(config *SecurityHeadersConfig) rtr.MiddlewareI
 				}
 
 				// Set CSP header
-				if config.CSP != nil && config.CSP.Enabled {
-					cspValue := buildCSPValue(config.CSP)
+				csp := config.CSP
+				if len(config.RouteCSP) > 0 {
+					if route := rtr.GetRoute(r); route != nil {
+						if routeCSP, ok := config.RouteCSP[route.GetName()]; ok {
+							csp = routeCSP
+						}
+					}
+				}
+				if csp != nil && csp.Enabled {
+					if config.CSPReportURI != "" && (csp.ReportURI == "" || (csp.ReportTo != "" && csp.ReportToURL == "")) {
+						filled := *csp
+						if filled.ReportURI == "" {
+							filled.ReportURI = config.CSPReportURI
+						}
+						if filled.ReportTo != "" && filled.ReportToURL == "" {
+							filled.ReportToURL = config.CSPReportURI
+						}
+						csp = &filled
+					}
+
+					nonce := ""
+					if csp.Nonce {
+						nonce = generateCSPNonce()
+						r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+					}
+
+					cspValue := buildCSP(csp, nonce)
 					if cspValue != "" {
-						w.Header().Set("Content-Security-Policy", cspValue)
+						headerName := "Content-Security-Policy"
+						if csp.ReportOnly {
+							headerName = "Content-Security-Policy-Report-Only"
+						}
+						w.Header().Set(headerName, cspValue)
+					}
+
+					if csp.ReportTo != "" && csp.ReportToURL != "" {
+						w.Header().Set("Reporting-Endpoints", csp.ReportTo+"="+strconv.Quote(csp.ReportToURL))
 					}
 				}
 
@@ -203,18 +266,72 @@ func NewSecurityHeadersMiddleware(config *SecurityHeadersConfig) rtr.MiddlewareI
 		})
 }
 
+// GetCSPNonce returns the CSP nonce generated for the request, to be placed
+// in nonce attributes of inline <script> and <style> tags. It returns an empty
+// string if nonces are not enabled.
+func GetCSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// generateCSPNonce returns a base64-encoded 128-bit random nonce.
+func generateCSPNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// buildHSTSValue constructs the Strict-Transport-Security header value from
+// configuration
+func buildHSTSValue(config *HSTSConfig) string {
+	value := fmt.Sprintf("max-age=%d", config.MaxAge)
+	if config.IncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+	if config.Preload {
+		value += "; preload"
+	}
+	return value
+}
+
 // buildCSPValue constructs the CSP header value from configuration
 func buildCSPValue(config *CSPConfig) string {
+	return buildCSP(config, "")
+}
+
+// buildCSP constructs the CSP header value from configuration, adding the
+// nonce (if not empty) to script-src and style-src.
+func buildCSP(config *CSPConfig, nonce string) string {
 	var directives []string
 
+	// script-src and style-src override default-src, so an empty one that
+	// sources are added to starts from default-src rather than allowing
+	// nothing else.
+	scriptSrc := config.ScriptSrc
+	if len(scriptSrc) == 0 && (nonce != "" || config.StrictDynamic) {
+		scriptSrc = config.DefaultSrc
+	}
+	styleSrc := config.StyleSrc
+	if len(styleSrc) == 0 && nonce != "" {
+		styleSrc = config.DefaultSrc
+	}
+	if nonce != "" {
+		nonceSource := "'nonce-" + nonce + "'"
+		scriptSrc = append(scriptSrc[:len(scriptSrc):len(scriptSrc)], nonceSource)
+		styleSrc = append(styleSrc[:len(styleSrc):len(styleSrc)], nonceSource)
+	}
+	if config.StrictDynamic {
+		scriptSrc = append(scriptSrc[:len(scriptSrc):len(scriptSrc)], "'strict-dynamic'")
+	}
+
 	if len(config.DefaultSrc) > 0 {
 		directives = append(directives, "default-src "+strings.Join(config.DefaultSrc, " "))
 	}
-	if len(config.ScriptSrc) > 0 {
-		directives = append(directives, "script-src "+strings.Join(config.ScriptSrc, " "))
+	if len(scriptSrc) > 0 {
+		directives = append(directives, "script-src "+strings.Join(scriptSrc, " "))
 	}
-	if len(config.StyleSrc) > 0 {
-		directives = append(directives, "style-src "+strings.Join(config.StyleSrc, " "))
+	if len(styleSrc) > 0 {
+		directives = append(directives, "style-src "+strings.Join(styleSrc, " "))
 	}
 	if len(config.FontSrc) > 0 {
 		directives = append(directives, "font-src "+strings.Join(config.FontSrc, " "))
@@ -243,6 +360,12 @@ func buildCSPValue(config *CSPConfig) string {
 	if config.UpgradeInsecureRequests {
 		directives = append(directives, "upgrade-insecure-requests")
 	}
+	if config.ReportURI != "" {
+		directives = append(directives, "report-uri "+config.ReportURI)
+	}
+	if config.ReportTo != "" {
+		directives = append(directives, "report-to "+config.ReportTo)
+	}
 
 	return strings.Join(directives, "; ")
 }
@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/dracory/rtr"
 )
 
 func TestSecurityHeadersMiddleware_DefaultConfig(t *testing.T) {
@@ -212,6 +214,124 @@ func TestBuildCSPValue(t *testing.T) {
 	}
 }
 
+func TestSecurityHeadersMiddleware_CSPNonce(t *testing.T) {
+	config := &SecurityHeadersConfig{
+		CSP: &CSPConfig{
+			Enabled:       true,
+			ScriptSrc:     []string{"'self'"},
+			StyleSrc:      []string{"'self'"},
+			Nonce:         true,
+			StrictDynamic: true,
+		},
+	}
+
+	var nonces []string
+	handler := NewSecurityHeadersMiddleware(config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, GetCSPNonce(r.Context()))
+	}))
+
+	var headers []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		headers = append(headers, rr.Header().Get("Content-Security-Policy"))
+	}
+
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Fatalf("Expected a distinct nonce per request, got %q", nonces)
+	}
+
+	expected := "script-src 'self' 'nonce-" + nonces[0] + "' 'strict-dynamic'; style-src 'self' 'nonce-" + nonces[0] + "'"
+	if headers[0] != expected {
+		t.Errorf("Expected CSP %q, got %q", expected, headers[0])
+	}
+	if len(config.CSP.ScriptSrc) != 1 {
+		t.Errorf("Expected config to be left untouched, got %v", config.CSP.ScriptSrc)
+	}
+}
+
+func TestSecurityHeadersMiddleware_CSPNonceKeepsDefaultSrc(t *testing.T) {
+	config := &SecurityHeadersConfig{
+		CSP: &CSPConfig{
+			Enabled:    true,
+			DefaultSrc: []string{"'self'"},
+			ImgSrc:     []string{"'self'", "data:"},
+			Nonce:      true,
+		},
+	}
+
+	var nonce string
+	rr := httptest.NewRecorder()
+	NewSecurityHeadersMiddleware(config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = GetCSPNonce(r.Context())
+	})).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	expected := "default-src 'self'; script-src 'self' 'nonce-" + nonce + "'; style-src 'self' 'nonce-" + nonce + "'; img-src 'self' data:"
+	if got := rr.Header().Get("Content-Security-Policy"); got != expected {
+		t.Errorf("Expected CSP %q, got %q", expected, got)
+	}
+	if len(config.CSP.DefaultSrc) != 1 {
+		t.Errorf("Expected config to be left untouched, got %v", config.CSP.DefaultSrc)
+	}
+}
+
+func TestSecurityHeadersMiddleware_CSPReporting(t *testing.T) {
+	config := &SecurityHeadersConfig{
+		CSP: &CSPConfig{
+			Enabled:     true,
+			DefaultSrc:  []string{"'self'"},
+			ReportOnly:  true,
+			ReportURI:   "/csp-report",
+			ReportTo:    "csp-endpoint",
+			ReportToURL: "https://example.com/csp-report",
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	NewSecurityHeadersMiddleware(config).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+	if got := rr.Header().Get("Content-Security-Policy"); got != "" {
+		t.Errorf("Expected no enforcing CSP header, got %q", got)
+	}
+	expected := "default-src 'self'; report-uri /csp-report; report-to csp-endpoint"
+	if got := rr.Header().Get("Content-Security-Policy-Report-Only"); got != expected {
+		t.Errorf("Expected report-only CSP %q, got %q", expected, got)
+	}
+	if got := rr.Header().Get("Reporting-Endpoints"); got != `csp-endpoint="https://example.com/csp-report"` {
+		t.Errorf("Unexpected Reporting-Endpoints header %q", got)
+	}
+}
+
+func TestSecurityHeadersMiddleware_RouteCSP(t *testing.T) {
+	config := &SecurityHeadersConfig{
+		CSP: &CSPConfig{Enabled: true, DefaultSrc: []string{"'self'"}},
+		RouteCSP: map[string]*CSPConfig{
+			"embed":  {Enabled: true, DefaultSrc: []string{"*"}},
+			"legacy": nil,
+		},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{NewSecurityHeadersMiddleware(config)})
+	router.AddRoute(rtr.Get("/", ok).SetName("home"))
+	router.AddRoute(rtr.Get("/embed", ok).SetName("embed"))
+	router.AddRoute(rtr.Get("/legacy", ok).SetName("legacy"))
+
+	for path, expected := range map[string]string{
+		"/":       "default-src 'self'",
+		"/embed":  "default-src *",
+		"/legacy": "",
+	} {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if got := rr.Header().Get("Content-Security-Policy"); got != expected {
+			t.Errorf("Expected CSP %q for %s, got %q", expected, path, got)
+		}
+	}
+}
+
 // Helper function to check if a string contains a substring
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(substr) == 0 ||
@@ -1,6 +1,9 @@
 package middlewares
 
 import (
+	"math"
+	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/dracory/rtr"
@@ -10,9 +13,105 @@ import (
 // time window. It uses the client's RemoteAddr (including port) to track request
 // counts.
 // This is a stdlib-only reimplementation of go-chi/httprate's Limit function.
+// For a true throttle that caps concurrent requests see
+// ConcurrencyThrottleMiddleware.
 func ThrottleMiddleware(requests int, window time.Duration) rtr.MiddlewareInterface {
-	rl := newRateLimiter(requests, window, keyByRemoteAddr)
+	rl := newRateLimiter(requests, window, KeyByRemoteAddr, nil)
 	return rtr.NewMiddleware().
 		SetName("Throttle").
 		SetHandler(rl.handler)
 }
+
+// ConcurrencyThrottleConfig configures ConcurrencyThrottleMiddleware.
+type ConcurrencyThrottleConfig struct {
+	// Limit is the maximum number of requests processed at the same time.
+	// Required.
+	Limit int
+	// BacklogLimit is the number of requests allowed to wait for a free slot.
+	// Optional; defaults to 0 (no waiting).
+	BacklogLimit int
+	// BacklogTimeout is how long a request may wait in the backlog. Optional;
+	// defaults to 60 seconds.
+	BacklogTimeout time.Duration
+	// RetryAfter is the value sent in the Retry-After header when the throttle
+	// is saturated, rounded up to whole seconds. Optional; defaults to 1
+	// second.
+	RetryAfter time.Duration
+}
+
+// ConcurrencyThrottleMiddleware returns a middleware that caps the number of
+// in-flight requests across all clients. Requests over the limit wait in a
+// bounded backlog queue for up to BacklogTimeout; when the backlog is full or
+// the wait times out, 503 Service Unavailable is returned with a Retry-After
+// header. Requests whose context is cancelled while waiting are dropped
+// without a response.
+// This is a stdlib-only reimplementation of go-chi's middleware.ThrottleBacklog.
+//
+// Attach it to a group to throttle only that group's routes.
+func ConcurrencyThrottleMiddleware(config ConcurrencyThrottleConfig) rtr.MiddlewareInterface {
+	if config.Limit < 1 {
+		config.Limit = 1
+	}
+	if config.BacklogLimit < 0 {
+		config.BacklogLimit = 0
+	}
+	if config.BacklogTimeout <= 0 {
+		config.BacklogTimeout = 60 * time.Second
+	}
+	retryAfter := int(math.Ceil(config.RetryAfter.Seconds()))
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+
+	t := &concurrencyThrottle{
+		tokens:         make(chan struct{}, config.Limit),
+		backlogTokens:  make(chan struct{}, config.Limit+config.BacklogLimit),
+		backlogTimeout: config.BacklogTimeout,
+		retryAfter:     strconv.Itoa(retryAfter),
+	}
+
+	return rtr.NewMiddleware().
+		SetName("Concurrency Throttle").
+		SetHandler(t.handler)
+}
+
+// concurrencyThrottle holds the semaphores used by
+// ConcurrencyThrottleMiddleware. backlogTokens admits a request into the
+// system (processing or waiting); tokens admits it into processing.
+type concurrencyThrottle struct {
+	tokens         chan struct{}
+	backlogTokens  chan struct{}
+	backlogTimeout time.Duration
+	retryAfter     string
+}
+
+// handler returns the middleware handler that enforces the concurrency limit.
+func (t *concurrencyThrottle) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case t.backlogTokens <- struct{}{}:
+		default:
+			t.reject(w, "Server capacity exceeded")
+			return
+		}
+		defer func() { <-t.backlogTokens }()
+
+		timer := time.NewTimer(t.backlogTimeout)
+		defer timer.Stop()
+
+		select {
+		case t.tokens <- struct{}{}:
+			defer func() { <-t.tokens }()
+			next.ServeHTTP(w, r)
+		case <-timer.C:
+			t.reject(w, "Timed out while waiting for a pending request to complete")
+		case <-r.Context().Done():
+		}
+	})
+}
+
+// reject writes a 503 response with the Retry-After header.
+func (t *concurrencyThrottle) reject(w http.ResponseWriter, message string) {
+	w.Header().Set("Retry-After", t.retryAfter)
+	http.Error(w, message, http.StatusServiceUnavailable)
+}
@@ -97,3 +97,96 @@ func TestThrottleMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestConcurrencyThrottleMiddleware(t *testing.T) {
+	// blockingHandler signals when a request is being processed and holds it
+	// until release is closed.
+	blockingHandler := func(started chan<- struct{}, release <-chan struct{}) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			<-release
+			w.WriteHeader(http.StatusOK)
+		})
+	}
+
+	t.Run("rejects with 503 when backlog is full", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		h := middlewares.ConcurrencyThrottleMiddleware(middlewares.ConcurrencyThrottleConfig{
+			Limit:      1,
+			RetryAfter: 5 * time.Second,
+		}).GetHandler()(blockingHandler(started, release))
+
+		done := make(chan int)
+		go func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+			done <- w.Code
+		}()
+		<-started
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != "5" {
+			t.Errorf("Expected Retry-After 5, got %q", got)
+		}
+
+		close(release)
+		if code := <-done; code != http.StatusOK {
+			t.Errorf("Expected first request status %d, got %d", http.StatusOK, code)
+		}
+	})
+
+	t.Run("queues requests in the backlog", func(t *testing.T) {
+		started := make(chan struct{}, 2)
+		release := make(chan struct{})
+		h := middlewares.ConcurrencyThrottleMiddleware(middlewares.ConcurrencyThrottleConfig{
+			Limit:        1,
+			BacklogLimit: 1,
+		}).GetHandler()(blockingHandler(started, release))
+
+		done := make(chan int, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+				done <- w.Code
+			}()
+		}
+		<-started
+
+		close(release)
+		for i := 0; i < 2; i++ {
+			if code := <-done; code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d", http.StatusOK, code)
+			}
+		}
+	})
+
+	t.Run("times out requests waiting in the backlog", func(t *testing.T) {
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		h := middlewares.ConcurrencyThrottleMiddleware(middlewares.ConcurrencyThrottleConfig{
+			Limit:          1,
+			BacklogLimit:   1,
+			BacklogTimeout: 50 * time.Millisecond,
+		}).GetHandler()(blockingHandler(started, release))
+
+		go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		<-started
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		close(release)
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status code %d, got %d", http.StatusServiceUnavailable, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != "1" {
+			t.Errorf("Expected default Retry-After 1, got %q", got)
+		}
+	})
+}
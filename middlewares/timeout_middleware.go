@@ -1,13 +1,38 @@
 package middlewares
 
 import (
+	"bytes"
 	"context"
 	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/dracory/rtr"
 )
 
+// TimeoutConfig configures TimeoutMiddlewareWithConfig.
+type TimeoutConfig struct {
+	// Timeout is the time a request may take. Optional; zero or negative
+	// means requests have no timeout unless set in RouteTimeouts.
+	Timeout time.Duration
+	// RouteTimeouts overrides Timeout by route name (see
+	// rtr.RouteInterface.SetName). A negative duration disables the timeout
+	// for that route.
+	RouteTimeouts map[string]time.Duration
+
+	// ExemptPaths are served without timeout or buffering, e.g. streaming
+	// endpoints. Patterns follow JailBotsConfig.ExcludePaths: "/events*" is a
+	// prefix match, "/ws" matches "/ws" and "/ws/...".
+	ExemptPaths []string
+	// ExemptRoutes lists route names served without timeout or buffering.
+	ExemptRoutes []string
+
+	// OnTimeout writes the response sent when the deadline is reached.
+	// Optional; defaults to 504 Gateway Timeout.
+	OnTimeout func(w http.ResponseWriter, r *http.Request)
+}
+
 // TimeoutMiddleware returns a middleware that adds a timeout to the request context.
 // If the request takes longer than the specified duration, the context is canceled
 // and a 504 Gateway Timeout response is written.
@@ -21,7 +46,8 @@ import (
 // silently suppressed by the standard http.ResponseWriter (which only honors
 // the first WriteHeader call). This matches chi's behaviour.
 //
-// Behaviour mirrors chi's middleware.Timeout.
+// Behaviour mirrors chi's middleware.Timeout. Use TimeoutMiddlewareWithConfig
+// to enforce the timeout on handlers that ignore the context.
 func TimeoutMiddleware(timeout time.Duration) rtr.MiddlewareInterface {
 	return rtr.NewMiddleware().
 		SetName("Timeout").
@@ -46,3 +72,147 @@ func timeoutHandler(timeout time.Duration) func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// TimeoutMiddlewareWithConfig returns a middleware that enforces a timeout
+// the way http.TimeoutHandler does: the handler runs with a deadline on its
+// context and its response is buffered. If it finishes in time, the buffered
+// response is sent; otherwise OnTimeout's response is sent at the deadline
+// and any later writes by the handler fail with http.ErrHandlerTimeout.
+//
+// As the whole response is buffered, the handler's ResponseWriter supports
+// neither flushing nor hijacking; exempt streaming and WebSocket routes with
+// ExemptPaths or ExemptRoutes. A panic in the handler is re-raised in the
+// serving goroutine, so recovery middlewares still see it.
+func TimeoutMiddlewareWithConfig(config TimeoutConfig) rtr.MiddlewareInterface {
+	if config.OnTimeout == nil {
+		config.OnTimeout = func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+		}
+	}
+
+	return rtr.NewMiddleware().
+		SetName("Timeout").
+		SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				timeout := timeoutFor(r, config)
+				if timeout <= 0 {
+					next.ServeHTTP(w, r)
+					return
+				}
+				serveWithTimeout(w, r, next, timeout, config.OnTimeout)
+			})
+		})
+}
+
+// timeoutFor returns the timeout of r, or zero if it has none.
+func timeoutFor(r *http.Request, config TimeoutConfig) time.Duration {
+	if pathMatchesAny(r.URL.Path, config.ExemptPaths) {
+		return 0
+	}
+	route := rtr.GetRoute(r)
+	if route == nil {
+		return config.Timeout
+	}
+	if slices.Contains(config.ExemptRoutes, route.GetName()) {
+		return 0
+	}
+	if timeout, ok := config.RouteTimeouts[route.GetName()]; ok {
+		return timeout
+	}
+	return config.Timeout
+}
+
+// serveWithTimeout runs next in its own goroutine with a buffered writer and
+// sends either its response or the timeout response, whichever comes first.
+func serveWithTimeout(w http.ResponseWriter, r *http.Request, next http.Handler, timeout time.Duration, onTimeout func(w http.ResponseWriter, r *http.Request)) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	tw := &timeoutWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	panicChan := make(chan any, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicChan <- p
+			}
+		}()
+		next.ServeHTTP(tw, r)
+		close(done)
+	}()
+
+	select {
+	case p := <-panicChan:
+		panic(p)
+	case <-done:
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		dst := w.Header()
+		for k, vv := range tw.header {
+			dst[k] = vv
+		}
+		if !tw.wroteHeader {
+			tw.code = http.StatusOK
+		}
+		w.WriteHeader(tw.code)
+		_, _ = w.Write(tw.buf.Bytes())
+	case <-ctx.Done():
+		tw.mu.Lock()
+		defer tw.mu.Unlock()
+		tw.err = ctx.Err()
+		if tw.err == context.DeadlineExceeded {
+			tw.err = http.ErrHandlerTimeout
+			onTimeout(w, r)
+		}
+		// Otherwise the client went away; there is no one to answer.
+	}
+}
+
+// timeoutWriter buffers a handler's response until it completes. Once the
+// request has timed out, writes fail with http.ErrHandlerTimeout.
+//
+// It deliberately has no Unwrap method: reaching the real ResponseWriter
+// would bypass the buffer and race with the timeout response.
+type timeoutWriter struct {
+	// header is only used by the handler goroutine until it completes.
+	header http.Header
+
+	mu          sync.Mutex
+	buf         bytes.Buffer
+	err         error
+	code        int
+	wroteHeader bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil {
+		return 0, tw.err
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.err != nil || tw.wroteHeader || code < http.StatusOK {
+		// Informational responses cannot be relayed from a buffer.
+		return
+	}
+	tw.writeHeaderLocked(code)
+}
+
+// writeHeaderLocked records the status code. The caller must hold tw.mu.
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.wroteHeader = true
+	tw.code = code
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/dracory/rtr"
 	"github.com/dracory/rtr/middlewares"
 )
 
@@ -118,3 +119,125 @@ func TestTimeoutMiddleware(t *testing.T) {
 		}
 	})
 }
+
+func TestTimeoutMiddlewareWithConfig(t *testing.T) {
+	t.Run("sends the buffered response when in time", func(t *testing.T) {
+		handler := middlewares.TimeoutMiddlewareWithConfig(middlewares.TimeoutConfig{Timeout: time.Second}).
+			GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Test", "yes")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("created"))
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusCreated || w.Body.String() != "created" || w.Header().Get("X-Test") != "yes" {
+			t.Errorf("Expected the handler's response, got %d %q %v", w.Code, w.Body.String(), w.Header())
+		}
+	})
+
+	t.Run("answers at the deadline and discards late writes", func(t *testing.T) {
+		lateWrite := make(chan error, 1)
+		handler := middlewares.TimeoutMiddlewareWithConfig(middlewares.TimeoutConfig{Timeout: 20 * time.Millisecond}).
+			GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Ignores the context.
+			time.Sleep(100 * time.Millisecond)
+			_, err := w.Write([]byte("too late"))
+			lateWrite <- err
+		}))
+
+		w := httptest.NewRecorder()
+		start := time.Now()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if elapsed := time.Since(start); elapsed > 80*time.Millisecond {
+			t.Errorf("Expected the response at the deadline, took %v", elapsed)
+		}
+		if w.Code != http.StatusGatewayTimeout {
+			t.Errorf("Expected status %d, got %d", http.StatusGatewayTimeout, w.Code)
+		}
+		if err := <-lateWrite; err != http.ErrHandlerTimeout {
+			t.Errorf("Expected late write to fail with ErrHandlerTimeout, got %v", err)
+		}
+		if body := w.Body.String(); body != "Gateway Timeout\n" {
+			t.Errorf("Expected the late write to be discarded, got %q", body)
+		}
+	})
+
+	t.Run("custom timeout response", func(t *testing.T) {
+		handler := middlewares.TimeoutMiddlewareWithConfig(middlewares.TimeoutConfig{
+			Timeout: 10 * time.Millisecond,
+			OnTimeout: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "5")
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+			},
+		}).GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+			t.Errorf("Expected the custom response, got %d %v", w.Code, w.Header())
+		}
+	})
+
+	t.Run("re-raises panics", func(t *testing.T) {
+		handler := middlewares.TimeoutMiddlewareWithConfig(middlewares.TimeoutConfig{Timeout: time.Second}).
+			GetHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}))
+
+		defer func() {
+			if p := recover(); p != "boom" {
+				t.Errorf("Expected the handler's panic, got %v", p)
+			}
+		}()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	t.Run("per-route timeouts and exemptions", func(t *testing.T) {
+		slow := func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+			if err := http.NewResponseController(w).Flush(); err != nil && r.URL.Path != "/default" {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+
+		router := rtr.NewRouter()
+		router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.TimeoutMiddlewareWithConfig(middlewares.TimeoutConfig{
+			Timeout:       10 * time.Millisecond,
+			RouteTimeouts: map[string]time.Duration{"report": time.Second, "import": -1},
+			ExemptRoutes:  []string{"events"},
+			ExemptPaths:   []string{"/ws*"},
+		})})
+		router.AddRoute(rtr.Get("/default", slow))
+		router.AddRoute(rtr.Get("/report", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(50 * time.Millisecond)
+		}).SetName("report"))
+		router.AddRoute(rtr.Get("/import", slow).SetName("import"))
+		router.AddRoute(rtr.Get("/events", slow).SetName("events"))
+		router.AddRoute(rtr.Get("/ws/chat", slow))
+
+		tests := []struct {
+			path     string
+			expected int
+		}{
+			{"/default", http.StatusGatewayTimeout},
+			{"/report", http.StatusOK},
+			{"/import", http.StatusOK},
+			{"/events", http.StatusOK},
+			{"/ws/chat", http.StatusOK},
+		}
+
+		for _, tt := range tests {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.expected {
+				t.Errorf("%s: expected status %d, got %d", tt.path, tt.expected, w.Code)
+			}
+		}
+	})
+}
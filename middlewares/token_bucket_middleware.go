@@ -0,0 +1,152 @@
+package middlewares
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// TokenBucketConfig configures TokenBucketMiddleware.
+type TokenBucketConfig struct {
+	// Rate is the number of tokens added to each bucket per second. Required.
+	Rate float64
+	// Burst is the bucket capacity, i.e. the largest burst allowed after a
+	// quiet period. Optional; defaults to 1.
+	Burst int
+	// KeyFunc derives the bucket a request draws from. Optional; defaults to
+	// KeyByIP.
+	KeyFunc RateLimitKeyFunc
+}
+
+// TokenBucketMiddleware returns a middleware that rate limits requests with a
+// token bucket per key. Unlike the sliding window of RateLimitMiddleware, a
+// bucket refills continuously, smoothing bursts: a client may send Burst
+// requests at once and then Rate requests per second.
+//
+// Responses carry X-RateLimit-Limit (the burst size) and X-RateLimit-Remaining
+// headers; rejected requests get 429 Too Many Requests with a Retry-After
+// header. Attach it to a group to limit only that group's routes.
+func TokenBucketMiddleware(config TokenBucketConfig) rtr.MiddlewareInterface {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = KeyByIP
+	}
+
+	tb := &tokenBucketLimiter{
+		rate:    config.Rate,
+		burst:   float64(config.Burst),
+		keyFn:   config.KeyFunc,
+		buckets: make(map[string]*tokenBucket),
+	}
+
+	return rtr.NewMiddleware().
+		SetName("Token Bucket").
+		SetHandler(tb.handler)
+}
+
+// tokenBucket is the state of a single bucket at the time of its last update.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// tokenBucketLimiter holds the buckets used by TokenBucketMiddleware.
+type tokenBucketLimiter struct {
+	rate      float64
+	burst     float64
+	keyFn     RateLimitKeyFunc
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// take refills the bucket for key and removes one token from it if available.
+// It returns whether a token was taken, the tokens left and, when rejected,
+// how long until the next token is available.
+func (l *tokenBucketLimiter) take(key string, now time.Time) (bool, float64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = l.refill(bucket, now)
+	bucket.updated = now
+
+	if bucket.tokens < 1 {
+		if l.rate <= 0 {
+			return false, bucket.tokens, time.Duration(math.MaxInt64)
+		}
+		wait := time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+		return false, bucket.tokens, wait
+	}
+
+	bucket.tokens--
+	return true, bucket.tokens, 0
+}
+
+// refill returns the tokens in bucket at time now, capped at the burst size.
+func (l *tokenBucketLimiter) refill(bucket *tokenBucket, now time.Time) float64 {
+	elapsed := now.Sub(bucket.updated).Seconds()
+	return math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+}
+
+// sweep drops buckets that have refilled completely, as they are
+// indistinguishable from new ones. It runs at most once per full refill period
+// so that the map does not grow with every client ever seen.
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	if l.rate <= 0 {
+		return
+	}
+
+	period := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < period {
+		return
+	}
+	l.lastSweep = now
+
+	for key, bucket := range l.buckets {
+		if l.refill(bucket, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// handler returns the middleware handler that enforces the token bucket.
+func (l *tokenBucketLimiter) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := l.keyFn(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+			return
+		}
+
+		ok, remaining, wait := l.take(key, time.Now())
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(l.burst)))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(math.Floor(remaining))))
+
+		if !ok {
+			retryAfter := int64(math.Ceil(wait.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
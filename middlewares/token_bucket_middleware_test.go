@@ -0,0 +1,85 @@
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr/middlewares"
+)
+
+func TestTokenBucketMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(h http.Handler, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = ip + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("allows a burst then rejects", func(t *testing.T) {
+		h := middlewares.TokenBucketMiddleware(middlewares.TokenBucketConfig{
+			Rate:  0.5,
+			Burst: 3,
+		}).GetHandler()(handler)
+
+		for i := 0; i < 3; i++ {
+			w := serve(h, "192.0.2.1")
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status code %d, got %d on request %d", http.StatusOK, w.Code, i+1)
+			}
+			if got := w.Header().Get("X-RateLimit-Limit"); got != "3" {
+				t.Errorf("Expected X-RateLimit-Limit 3, got %q", got)
+			}
+		}
+
+		w := serve(h, "192.0.2.1")
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+		}
+		if got := w.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("Expected Retry-After 2, got %q", got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != "0" {
+			t.Errorf("Expected X-RateLimit-Remaining 0, got %q", got)
+		}
+	})
+
+	t.Run("refills over time", func(t *testing.T) {
+		h := middlewares.TokenBucketMiddleware(middlewares.TokenBucketConfig{
+			Rate:  20,
+			Burst: 1,
+		}).GetHandler()(handler)
+
+		if w := serve(h, "192.0.2.2"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if w := serve(h, "192.0.2.2"); w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected status code %d, got %d", http.StatusTooManyRequests, w.Code)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+
+		if w := serve(h, "192.0.2.2"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d after refill, got %d", http.StatusOK, w.Code)
+		}
+	})
+
+	t.Run("keeps separate buckets per key", func(t *testing.T) {
+		h := middlewares.TokenBucketMiddleware(middlewares.TokenBucketConfig{
+			Rate: 0.1,
+		}).GetHandler()(handler)
+
+		if w := serve(h, "192.0.2.3"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+		if w := serve(h, "192.0.2.4"); w.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d for a different IP, got %d", http.StatusOK, w.Code)
+		}
+	})
+}
@@ -0,0 +1,186 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies is a parsed list of proxy networks whose forwarding headers
+// may be believed.
+type trustedProxies []netip.Prefix
+
+// parseTrustedProxies parses CIDRs such as "10.0.0.0/8" and bare IPs such as
+// "192.0.2.1". Invalid entries are logged and skipped, so a typo never widens
+// the set of trusted peers.
+func parseTrustedProxies(entries []string) trustedProxies {
+	proxies := make(trustedProxies, 0, len(entries))
+	for _, entry := range entries {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		prefix, err := parseIPPrefix(entry)
+		if err != nil {
+			slog.Default().Error("trusted proxies: invalid entry", slog.String("entry", entry), slog.String("error", err.Error()))
+			continue
+		}
+		proxies = append(proxies, prefix)
+	}
+	return proxies
+}
+
+// parseIPPrefix parses a CIDR such as "10.0.0.0/8" or a bare IP such as
+// "192.0.2.1", which becomes a single-address prefix.
+func parseIPPrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// contains reports whether ip (without port) belongs to a trusted network.
+func (p trustedProxies) contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the IP part of r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// peerIP returns the IP of the direct peer of the request: the one recorded
+// by RealIPMiddlewareWithConfig, which rewrites r.RemoteAddr to the client,
+// or else the IP part of r.RemoteAddr.
+func peerIP(r *http.Request) string {
+	if ip := GetPeerIP(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteIP(r)
+}
+
+// isTrustedPeer reports whether the direct peer of the request is a trusted
+// proxy, i.e. whether its forwarding headers may be used.
+func (p trustedProxies) isTrustedPeer(r *http.Request) bool {
+	return len(p) > 0 && p.contains(peerIP(r))
+}
+
+// clientIP walks the hop list right-to-left, skipping trusted proxies, and
+// returns the first untrusted address. If every hop is trusted, the leftmost
+// one is returned. An invalid hop stops the walk, since nothing to its left
+// can be verified, and yields "".
+func (p trustedProxies) clientIP(hops []string) string {
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := hops[i]
+		if !isValidIP(hop) {
+			return ""
+		}
+		if i == 0 || !p.contains(hop) {
+			return hop
+		}
+	}
+	return ""
+}
+
+// forwardedElement is one comma-separated element of an RFC 7239 Forwarded
+// header, with parameter names lower-cased and quotes removed.
+type forwardedElement map[string]string
+
+// parseForwarded parses every Forwarded header line on h into its elements,
+// in order from the first (closest to the client) to the last.
+func parseForwarded(h http.Header) []forwardedElement {
+	var elements []forwardedElement
+	for _, line := range h.Values("Forwarded") {
+		for _, part := range splitQuoted(line, ',') {
+			element := forwardedElement{}
+			for _, pair := range splitQuoted(part, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				value = strings.TrimSpace(value)
+				if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+					value = strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`)
+				}
+				element[strings.ToLower(strings.TrimSpace(name))] = value
+			}
+			if len(element) > 0 {
+				elements = append(elements, element)
+			}
+		}
+	}
+	return elements
+}
+
+// forwardedNodeIP extracts the IP from a Forwarded "for" or "by" node such as
+// "192.0.2.60", "192.0.2.60:4711" or "[2001:db8::17]:4711". Obfuscated or
+// "unknown" nodes yield "".
+func forwardedNodeIP(node string) string {
+	if strings.HasPrefix(node, "[") {
+		end := strings.IndexByte(node, ']')
+		if end < 0 {
+			return ""
+		}
+		node = node[1:end]
+	} else if strings.Count(node, ":") == 1 {
+		node = node[:strings.IndexByte(node, ':')]
+	}
+	if !isValidIP(node) {
+		return ""
+	}
+	return node
+}
+
+// splitQuoted splits s on sep, ignoring separators inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if inQuotes {
+				i++
+			}
+		case '"':
+			inQuotes = !inQuotes
+		case sep:
+			if !inQuotes {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,28 @@
+package rtr
+
+import (
+	"context"
+	"net/http"
+)
+
+// GetRoute returns the route that matched the request, or nil if the request
+// was not dispatched by a router. It is available to every middleware in the
+// chain, which lets middlewares key behaviour off the route name.
+func GetRoute(r *http.Request) RouteInterface {
+	if r == nil {
+		return nil
+	}
+
+	route, _ := r.Context().Value(RouteKey).(RouteInterface)
+	return route
+}
+
+// withRouteContext returns a shallow copy of req carrying the matched route and,
+// if any, its path parameters.
+func withRouteContext(req *http.Request, route RouteInterface, params map[string]string) *http.Request {
+	ctx := context.WithValue(req.Context(), RouteKey, route)
+	if len(params) > 0 {
+		ctx = context.WithValue(ctx, ParamsKey, params)
+	}
+	return req.WithContext(ctx)
+}
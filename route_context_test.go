@@ -0,0 +1,72 @@
+package rtr_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dracory/rtr"
+)
+
+func TestGetRoute(t *testing.T) {
+	t.Run("returns nil outside a router", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/", nil)
+		if route := rtr.GetRoute(req); route != nil {
+			t.Fatalf("Expected nil route, got %v", route)
+		}
+		if route := rtr.GetRoute(nil); route != nil {
+			t.Fatalf("Expected nil route for nil request, got %v", route)
+		}
+	})
+
+	t.Run("exposes the matched route to middlewares and handler", func(t *testing.T) {
+		var middlewareRoute, handlerRoute string
+		mw := rtr.NewMiddleware().SetHandler(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if route := rtr.GetRoute(r); route != nil {
+					middlewareRoute = route.GetName()
+				}
+				next.ServeHTTP(w, r)
+			})
+		})
+
+		route := rtr.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+			if route := rtr.GetRoute(r); route != nil {
+				handlerRoute = route.GetName()
+			}
+			if id, _ := rtr.GetParam(r, "id"); id != "42" {
+				t.Errorf("Expected param id 42, got %q", id)
+			}
+		}).SetName("user-show")
+
+		router := rtr.NewRouter()
+		router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{mw})
+		router.AddGroup(rtr.NewGroup().SetPrefix("/api").AddRoute(route))
+
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/users/42", nil))
+
+		if middlewareRoute != "user-show" {
+			t.Errorf("Expected middleware to see route %q, got %q", "user-show", middlewareRoute)
+		}
+		if handlerRoute != "user-show" {
+			t.Errorf("Expected handler to see route %q, got %q", "user-show", handlerRoute)
+		}
+	})
+
+	t.Run("is set for domain routes", func(t *testing.T) {
+		var name string
+		router := rtr.NewRouter()
+		router.AddDomain(rtr.NewDomain("example.com").AddRoute(
+			rtr.Get("/", func(w http.ResponseWriter, r *http.Request) {
+				name = rtr.GetRoute(r).GetName()
+			}).SetName("home"),
+		))
+
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+
+		if name != "home" {
+			t.Errorf("Expected route %q, got %q", "home", name)
+		}
+	})
+}
@@ -47,6 +47,15 @@ type routeImpl struct {
 	// jsHandler is the JavaScript handler function that returns JavaScript string
 	jsHandler JSHandler
 
+	// sseHandler is the server-sent events handler function that streams events
+	sseHandler SSEHandler
+
+	// webSocketHandler is the WebSocket handler function that serves upgraded connections
+	webSocketHandler WebSocketHandler
+
+	// webSocketConfig configures the WebSocket handshake and connections
+	webSocketConfig WebSocketConfig
+
 	// staticHandler is the static handler function that serves static files
 	staticHandler StaticHandler
 
@@ -286,6 +295,16 @@ func (r *routeImpl) GetHandler() StdHandler {
 		}
 	}
 
+	// Priority 14: SSEHandler - convert to standard Handler streaming events
+	if r.sseHandler != nil {
+		return SSEHandlerToHandler(r.sseHandler)
+	}
+
+	// Priority 15: WebSocketHandler - convert to standard Handler performing the WebSocket handshake
+	if r.webSocketHandler != nil {
+		return WebSocketHandlerToHandler(r.webSocketConfig, r.webSocketHandler)
+	}
+
 	// No handler found
 	return nil
 }
@@ -396,6 +415,47 @@ func (r *routeImpl) SetJSHandler(handler JSHandler) RouteInterface {
 	return r
 }
 
+// GetSSEHandler returns the server-sent events handler function associated with this route.
+// Returns the SSEHandler function that will be called when this route is matched.
+func (r *routeImpl) GetSSEHandler() SSEHandler {
+	return r.sseHandler
+}
+
+// SetSSEHandler sets the server-sent events handler function for this route.
+// This method supports method chaining by returning the RouteInterface.
+// The handler parameter should be a function that sends events until it is done or the client disconnects.
+func (r *routeImpl) SetSSEHandler(handler SSEHandler) RouteInterface {
+	r.sseHandler = handler
+	return r
+}
+
+// GetWebSocketHandler returns the WebSocket handler function associated with this route.
+// Returns the WebSocketHandler function that will be called when this route is matched.
+func (r *routeImpl) GetWebSocketHandler() WebSocketHandler {
+	return r.webSocketHandler
+}
+
+// SetWebSocketHandler sets the WebSocket handler function for this route.
+// This method supports method chaining by returning the RouteInterface.
+// The handler parameter should be a function serving the connection until it is done or closed.
+func (r *routeImpl) SetWebSocketHandler(handler WebSocketHandler) RouteInterface {
+	r.webSocketHandler = handler
+	return r
+}
+
+// GetWebSocketConfig returns the WebSocket configuration of this route.
+func (r *routeImpl) GetWebSocketConfig() WebSocketConfig {
+	return r.webSocketConfig
+}
+
+// SetWebSocketConfig sets the WebSocket configuration of this route, such as
+// subprotocols, allowed origins and the message size limit.
+// This method supports method chaining by returning the RouteInterface.
+func (r *routeImpl) SetWebSocketConfig(config WebSocketConfig) RouteInterface {
+	r.webSocketConfig = config
+	return r
+}
+
 // GetStaticHandler returns the static handler function associated with this route.
 // Returns the StaticHandler function that will be called when this route is matched.
 func (r *routeImpl) GetStaticHandler() StaticHandler {
@@ -590,6 +650,18 @@ func GetText(path string, handler TextHandler) RouteInterface {
 	return NewRoute().SetMethod(http.MethodGet).SetPath(path).SetTextHandler(handler)
 }
 
+// GetSSE creates a new GET route with the given path and server-sent events handler
+// It is a shortcut method that combines setting the method to GET, path, and SSE handler.
+func GetSSE(path string, handler SSEHandler) RouteInterface {
+	return NewRoute().SetMethod(http.MethodGet).SetPath(path).SetSSEHandler(handler)
+}
+
+// WebSocket creates a new GET route with the given path and WebSocket handler
+// It is a shortcut method that combines setting the method to GET, path, and WebSocket handler.
+func WebSocket(path string, handler WebSocketHandler) RouteInterface {
+	return NewRoute().SetMethod(http.MethodGet).SetPath(path).SetWebSocketHandler(handler)
+}
+
 // GetStatic creates a new GET route with the given path and static handler
 // It is a shortcut method that combines setting the method to GET, path, and static handler.
 func GetStatic(path string, handler StaticHandler) RouteInterface {
@@ -1,7 +1,6 @@
 package rtr
 
 import (
-	"context"
 	"net/http"
 )
 
@@ -40,11 +39,8 @@ func (r *routerImpl) findMatchingRouteInDomain(domain DomainInterface, req *http
 			// Create a copy of the request to avoid mutating the original
 			reqCopy := req.Clone(req.Context())
 
-			// Add params to request context if any
-			if len(params) > 0 {
-				ctx := context.WithValue(reqCopy.Context(), ParamsKey, params)
-				reqCopy = reqCopy.WithContext(ctx)
-			}
+			// Add the route and any params to the request context
+			reqCopy = withRouteContext(reqCopy, route, params)
 
 			// Build the handler with the updated request
 			handler := r.buildHandler(route, nil, domain)
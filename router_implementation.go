@@ -1,7 +1,6 @@
 package rtr
 
 import (
-	"context"
 	"net/http"
 	"strings"
 )
@@ -43,6 +42,8 @@ type routerImpl struct {
 	beforeMiddlewares []MiddlewareInterface
 	// afterMiddlewares are middleware functions that will be executed after any route handler
 	afterMiddlewares []MiddlewareInterface
+	// errorRenderer renders error responses, see SetErrorRenderer
+	errorRenderer ErrorRenderer
 }
 
 var _ RouterInterface = (*routerImpl)(nil)
@@ -128,7 +129,24 @@ func (r *routerImpl) GetAfterMiddlewares() []MiddlewareInterface {
 	return r.afterMiddlewares
 }
 
+// SetErrorRenderer sets the renderer used for the router's own errors, such
+// as 404 Not Found, and by middlewares calling RenderError.
+// Returns the router for method chaining.
+func (r *routerImpl) SetErrorRenderer(renderer ErrorRenderer) RouterInterface {
+	r.errorRenderer = renderer
+	return r
+}
+
+// GetErrorRenderer returns the renderer set with SetErrorRenderer, or nil.
+func (r *routerImpl) GetErrorRenderer() ErrorRenderer {
+	return r.errorRenderer
+}
+
 func (r *routerImpl) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if r.errorRenderer != nil {
+		req = withErrorRenderer(req, r.errorRenderer)
+	}
+
 	// Find a matching route
 	route, handler := r.findMatchingRoute(req)
 
@@ -144,6 +162,10 @@ func (r *routerImpl) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	// If still no route found, return 404
 	if route == nil {
+		if r.errorRenderer != nil {
+			r.errorRenderer(w, req, http.StatusNotFound, nil)
+			return
+		}
 		http.NotFound(w, req)
 		return
 	}
@@ -261,11 +283,8 @@ func (r *routerImpl) findMatchingRoute(req *http.Request) (RouteInterface, http.
 		rc := reqCopy.Clone(reqCopy.Context())
 
 		if match, params := r.routeMatches(route, rc); match {
-			// Add params to request context if any
-			if len(params) > 0 {
-				ctx := context.WithValue(rc.Context(), ParamsKey, params)
-				rc = rc.WithContext(ctx)
-			}
+			// Add the route and any params to the request context
+			rc = withRouteContext(rc, route, params)
 
 			// Create a handler that will use the correct request with parameters
 			handler := r.buildHandler(route, nil, nil)
@@ -313,11 +332,8 @@ func (r *routerImpl) findMatchingRouteInGroup(group GroupInterface, req *http.Re
 		reqCopy := req.Clone(req.Context())
 
 		if match, params := r.routeMatches(tempRoute, reqCopy); match {
-			// Create a new request with the updated context containing the parameters
-			if len(params) > 0 {
-				ctx := context.WithValue(reqCopy.Context(), ParamsKey, params)
-				reqCopy = reqCopy.WithContext(ctx)
-			}
+			// Create a new request with the updated context containing the route and parameters
+			reqCopy = withRouteContext(reqCopy, route, params)
 
 			// Find the domain that matches this request (if any)
 			var domain DomainInterface
@@ -0,0 +1,324 @@
+package rtr
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ServerListener is an additional address served by a Server, e.g. an
+// admin port with its own router.
+type ServerListener struct {
+	// Name identifies the listener in logs and Server.Addr. Optional;
+	// defaults to its address.
+	Name string
+	// Addr is the TCP address to listen on, e.g. ":9090".
+	Addr string
+	// Handler serves the listener's requests. Optional; defaults to the
+	// server's router.
+	Handler http.Handler
+	// CertFile and KeyFile enable TLS with the certificate and key in these
+	// PEM files.
+	CertFile string
+	KeyFile  string
+	// TLSConfig configures TLS, e.g. client certificates. Optional; setting
+	// it enables TLS, with the certificates of TLSConfig.Certificates or
+	// GetCertificate when CertFile and KeyFile are empty.
+	TLSConfig *tls.Config
+}
+
+// ServerConfig configures a Server. The zero value serves on ":8080".
+type ServerConfig struct {
+	// Addr is the TCP address of the main listener. Optional; defaults to
+	// ":8080".
+	Addr string
+	// CertFile and KeyFile enable TLS on the main listener with the
+	// certificate and key in these PEM files.
+	CertFile string
+	KeyFile  string
+	// TLSConfig configures TLS on the main listener. Optional; setting it
+	// enables TLS, see ServerListener.TLSConfig.
+	TLSConfig *tls.Config
+
+	// Listeners are served alongside the main listener.
+	Listeners []ServerListener
+
+	// ReadHeaderTimeout limits reading the request headers. Optional;
+	// defaults to 10 seconds, negative means no limit.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout limits reading the whole request. Optional; defaults to
+	// 30 seconds, negative means no limit.
+	ReadTimeout time.Duration
+	// WriteTimeout limits writing the response. Optional; zero means no
+	// limit, as long-lived responses such as server-sent events need.
+	WriteTimeout time.Duration
+	// IdleTimeout limits how long keep-alive connections wait for the next
+	// request. Optional; defaults to 2 minutes, negative means no limit.
+	IdleTimeout time.Duration
+
+	// Signals start a graceful shutdown. Optional; defaults to SIGINT and
+	// SIGTERM.
+	Signals []os.Signal
+	// ShutdownDelay is how long the server keeps serving after reporting not
+	// ready, so load balancers stop sending traffic before listeners close.
+	// Event streams (see SSEHandler) are ended once it has passed, so their
+	// clients reconnect elsewhere instead of holding up the drain. Optional;
+	// zero closes listeners right away.
+	ShutdownDelay time.Duration
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// during shutdown, after which their connections are closed. Optional;
+	// defaults to 30 seconds.
+	ShutdownTimeout time.Duration
+
+	// OnStart is called before listening. An error aborts ListenAndServe.
+	OnStart func(ctx context.Context) error
+	// OnShutdown is called when shutdown starts, after the delay, with the
+	// drain deadline, e.g. to close WebSocket connections, which the server
+	// does not track.
+	OnShutdown func(ctx context.Context)
+	// OnStop is called once all requests have finished or the drain deadline
+	// has passed, e.g. to close database connections.
+	OnStop func()
+}
+
+// Server runs a router with timeouts and graceful shutdown, see
+// ListenAndServe.
+type Server struct {
+	handler http.Handler
+	config  ServerConfig
+
+	started  atomic.Bool
+	ready    atomic.Bool
+	shutdown chan struct{}
+	// draining is closed after ShutdownDelay, when the listeners close.
+	draining chan struct{}
+
+	mu    sync.Mutex
+	addrs map[string]net.Addr
+}
+
+// serverDrainingKey is the request context key of the channel closed when
+// the Server serving the request drains.
+type serverDrainingKey struct{}
+
+// serverDraining returns the channel closed when the Server serving the
+// request with ctx drains, or nil if it is not served by a Server.
+func serverDraining(ctx context.Context) <-chan struct{} {
+	draining, _ := ctx.Value(serverDrainingKey{}).(chan struct{})
+	return draining
+}
+
+// serverListener is a bound listener with its server.
+type serverListener struct {
+	config   ServerListener
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewServer returns a Server for router, usually a RouterInterface.
+func NewServer(router http.Handler, config ServerConfig) *Server {
+	if config.Addr == "" {
+		config.Addr = ":8080"
+	}
+	if config.ReadHeaderTimeout == 0 {
+		config.ReadHeaderTimeout = 10 * time.Second
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = 30 * time.Second
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = 2 * time.Minute
+	}
+	if len(config.Signals) == 0 {
+		config.Signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = 30 * time.Second
+	}
+	return &Server{
+		handler:  router,
+		config:   config,
+		shutdown: make(chan struct{}),
+		draining: make(chan struct{}),
+		addrs:    make(map[string]net.Addr),
+	}
+}
+
+// Ready reports whether the server is listening and not shutting down, for
+// readiness probes.
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+// ShuttingDown returns a channel closed when shutdown starts, so
+// long-lived handlers such as WebSocket handlers can end and let the server
+// drain. Event streams end on their own, see ServerConfig.ShutdownDelay.
+func (s *Server) ShuttingDown() <-chan struct{} {
+	return s.shutdown
+}
+
+// Addr returns the address the named listener is bound to, e.g. to find
+// the port chosen for ":0". The main listener is named "main". It is nil
+// until the listener is bound.
+func (s *Server) Addr(name string) net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addrs[name]
+}
+
+// ListenAndServe serves all listeners until ctx is canceled, a shutdown
+// signal is received or a listener fails. It then shuts down gracefully:
+// Ready turns false, and after ShutdownDelay the listeners stop accepting
+// connections while in-flight requests get up to ShutdownTimeout to finish.
+//
+// A second signal during the shutdown is no longer caught, so it ends the
+// process as usual, e.g. when an operator presses Ctrl+C again.
+//
+// It returns nil after a clean shutdown, or the errors of failed listeners
+// and of an incomplete drain. A Server can only be started once.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	if !s.started.CompareAndSwap(false, true) {
+		return errors.New("rtr: server already started")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, s.config.Signals...)
+	defer stop()
+
+	if s.config.OnStart != nil {
+		if err := s.config.OnStart(ctx); err != nil {
+			return err
+		}
+	}
+
+	listeners, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() {
+			var err error
+			if l.config.CertFile != "" || l.config.KeyFile != "" || l.config.TLSConfig != nil {
+				err = l.server.ServeTLS(l.listener, l.config.CertFile, l.config.KeyFile)
+			} else {
+				err = l.server.Serve(l.listener)
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				errs <- fmt.Errorf("rtr: listener %s: %w", l.config.Name, err)
+			}
+		}()
+		slog.Default().Info("Server listening", "listener", l.config.Name, "addr", l.listener.Addr().String())
+	}
+	s.ready.Store(true)
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-errs:
+	}
+	// Restore the default signal handling, so a second signal force-quits
+	// a drain that takes too long.
+	stop()
+	return errors.Join(serveErr, s.drain(listeners))
+}
+
+// listen binds all listeners, so an address in use fails before anything
+// is served.
+func (s *Server) listen() ([]*serverListener, error) {
+	configs := append([]ServerListener{{
+		Name:      "main",
+		Addr:      s.config.Addr,
+		CertFile:  s.config.CertFile,
+		KeyFile:   s.config.KeyFile,
+		TLSConfig: s.config.TLSConfig,
+	}}, s.config.Listeners...)
+
+	var listeners []*serverListener
+	for _, config := range configs {
+		if config.Name == "" {
+			config.Name = config.Addr
+		}
+		if config.Handler == nil {
+			config.Handler = s.handler
+		}
+
+		ln, err := net.Listen("tcp", config.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.listener.Close()
+			}
+			return nil, fmt.Errorf("rtr: listener %s: %w", config.Name, err)
+		}
+
+		s.mu.Lock()
+		s.addrs[config.Name] = ln.Addr()
+		s.mu.Unlock()
+
+		listeners = append(listeners, &serverListener{
+			config:   config,
+			listener: ln,
+			server: &http.Server{
+				Handler:           config.Handler,
+				TLSConfig:         config.TLSConfig,
+				ReadHeaderTimeout: max(s.config.ReadHeaderTimeout, 0),
+				ReadTimeout:       max(s.config.ReadTimeout, 0),
+				WriteTimeout:      max(s.config.WriteTimeout, 0),
+				IdleTimeout:       max(s.config.IdleTimeout, 0),
+				BaseContext: func(net.Listener) context.Context {
+					return context.WithValue(context.Background(), serverDrainingKey{}, s.draining)
+				},
+			},
+		})
+	}
+	return listeners, nil
+}
+
+// drain shuts the listeners down gracefully, closing the connections of
+// requests still running at the deadline.
+func (s *Server) drain(listeners []*serverListener) error {
+	s.ready.Store(false)
+	close(s.shutdown)
+	slog.Default().Info("Server shutting down", "delay", s.config.ShutdownDelay.String(), "timeout", s.config.ShutdownTimeout.String())
+	time.Sleep(s.config.ShutdownDelay)
+	close(s.draining)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	if s.config.OnShutdown != nil {
+		s.config.OnShutdown(ctx)
+	}
+
+	errs := make([]error, len(listeners))
+	var wg sync.WaitGroup
+	for i, l := range listeners {
+		wg.Go(func() {
+			if err := l.server.Shutdown(ctx); err != nil {
+				_ = l.server.Close()
+				errs[i] = fmt.Errorf("rtr: listener %s: %w", l.config.Name, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if s.config.OnStop != nil {
+		s.config.OnStop()
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Default().Warn("Server stopped before requests finished", "error", err.Error())
+	} else {
+		slog.Default().Info("Server stopped")
+	}
+	return err
+}
@@ -0,0 +1,306 @@
+package rtr_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+)
+
+// startServer runs server in the background until it is ready, returning
+// the channel ListenAndServe's result is sent on.
+func startServer(t *testing.T, ctx context.Context, server *rtr.Server) <-chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() { result <- server.ListenAndServe(ctx) }()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !server.Ready() {
+		select {
+		case err := <-result:
+			t.Fatalf("Server stopped before becoming ready: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the server to become ready")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return result
+}
+
+func waitServer(t *testing.T, result <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the server to stop")
+		return nil
+	}
+}
+
+func serverGet(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestServer_ListenersAndHooks(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.GetText("/", func(w http.ResponseWriter, r *http.Request) string { return "app" }))
+	admin := rtr.NewRouter()
+	admin.AddRoute(rtr.GetText("/", func(w http.ResponseWriter, r *http.Request) string { return "admin" }))
+
+	var mu sync.Mutex
+	var hooks []string
+	hook := func(name string) {
+		mu.Lock()
+		defer mu.Unlock()
+		hooks = append(hooks, name)
+	}
+
+	var server *rtr.Server
+	server = rtr.NewServer(router, rtr.ServerConfig{
+		Addr:      "127.0.0.1:0",
+		Listeners: []rtr.ServerListener{{Name: "admin", Addr: "127.0.0.1:0", Handler: admin}},
+		OnStart: func(ctx context.Context) error {
+			hook("start")
+			return nil
+		},
+		OnShutdown: func(ctx context.Context) {
+			if server.Ready() {
+				t.Error("Expected the server to report not ready during shutdown")
+			}
+			hook("shutdown")
+		},
+		OnStop: func() { hook("stop") },
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := startServer(t, ctx, server)
+
+	if body := serverGet(t, http.DefaultClient, "http://"+server.Addr("main").String()); body != "app" {
+		t.Errorf("Expected the main router, got %q", body)
+	}
+	if body := serverGet(t, http.DefaultClient, "http://"+server.Addr("admin").String()); body != "admin" {
+		t.Errorf("Expected the admin router, got %q", body)
+	}
+
+	cancel()
+	if err := waitServer(t, result); err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+	if strings.Join(hooks, ",") != "start,shutdown,stop" {
+		t.Errorf("Expected the hooks in order, got %v", hooks)
+	}
+	if _, err := net.Dial("tcp", server.Addr("main").String()); err == nil {
+		t.Error("Expected the listener to be closed")
+	}
+	if err := server.ListenAndServe(context.Background()); err == nil {
+		t.Error("Expected a server not to start twice")
+	}
+}
+
+func TestServer_GracefulShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte("done"))
+	}))
+
+	for _, tt := range []struct {
+		name    string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{"drained", time.Second, false},
+		{"deadline", 50 * time.Millisecond, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := rtr.NewServer(router, rtr.ServerConfig{Addr: "127.0.0.1:0", ShutdownTimeout: tt.timeout})
+			ctx, cancel := context.WithCancel(context.Background())
+			result := startServer(t, ctx, server)
+
+			response := make(chan string, 1)
+			go func() {
+				resp, err := http.Get("http://" + server.Addr("main").String() + "/slow")
+				if err != nil {
+					response <- err.Error()
+					return
+				}
+				defer resp.Body.Close()
+				body, _ := io.ReadAll(resp.Body)
+				response <- string(body)
+			}()
+			<-started
+			cancel()
+
+			err := waitServer(t, result)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if body := <-response; (body == "done") == tt.wantErr {
+				t.Errorf("Unexpected in-flight response %q", body)
+			}
+		})
+	}
+}
+
+func TestServer_Signal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals cannot be sent to the own process on Windows")
+	}
+	server := rtr.NewServer(rtr.NewRouter(), rtr.ServerConfig{Addr: "127.0.0.1:0"})
+	result := startServer(t, context.Background(), server)
+
+	process, _ := os.FindProcess(os.Getpid())
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := waitServer(t, result); err != nil {
+		t.Errorf("Expected SIGTERM to shut the server down cleanly, got %v", err)
+	}
+	select {
+	case <-server.ShuttingDown():
+	default:
+		t.Error("Expected ShuttingDown to be closed")
+	}
+}
+
+func TestServer_TLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.GetText("/", func(w http.ResponseWriter, r *http.Request) string { return r.Proto }))
+	server := rtr.NewServer(router, rtr.ServerConfig{Addr: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startServer(t, ctx, server)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+	if body := serverGet(t, client, "https://"+server.Addr("main").String()); body != "HTTP/2.0" {
+		t.Errorf("Expected to be served over TLS with HTTP/2, got %q", body)
+	}
+}
+
+func TestServer_TLSConfig(t *testing.T) {
+	cert, err := tls.LoadX509KeyPair(writeTestCertificate(t))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.GetText("/", func(w http.ResponseWriter, r *http.Request) string { return "secure" }))
+	server := rtr.NewServer(router, rtr.ServerConfig{
+		Addr:      "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startServer(t, ctx, server)
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	if body := serverGet(t, client, "https://"+server.Addr("main").String()); body != "secure" {
+		t.Errorf("Expected TLSConfig alone to enable TLS, got %q", body)
+	}
+}
+
+func TestServer_EndsEventStreams(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.GetSSE("/events", func(ctx context.Context, stream *rtr.EventStream) error {
+		_ = stream.Comment("open")
+		<-ctx.Done()
+		return nil
+	}))
+	server := rtr.NewServer(router, rtr.ServerConfig{Addr: "127.0.0.1:0", ShutdownTimeout: 5 * time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	result := startServer(t, ctx, server)
+
+	resp, err := http.Get("http://" + server.Addr("main").String() + "/events")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); line != ": open\n" {
+		t.Fatalf("Expected the stream to start, got %q", line)
+	}
+
+	start := time.Now()
+	cancel()
+	if err := waitServer(t, result); err != nil {
+		t.Errorf("Expected the stream to end and the server to drain cleanly, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the stream not to hold up the drain, took %s", elapsed)
+	}
+}
+
+func TestServer_ListenError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer taken.Close()
+
+	server := rtr.NewServer(rtr.NewRouter(), rtr.ServerConfig{
+		Addr:      "127.0.0.1:0",
+		Listeners: []rtr.ServerListener{{Name: "admin", Addr: taken.Addr().String()}},
+	})
+	if err := server.ListenAndServe(context.Background()); err == nil || !strings.Contains(err.Error(), "admin") {
+		t.Errorf("Expected the listener error, got %v", err)
+	}
+}
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1,
+// returning the certificate and key file paths.
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	keyDER, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	_ = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
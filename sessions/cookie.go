@@ -0,0 +1,154 @@
+package sessions
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// maxCookieSize is the size browsers are guaranteed to store for a cookie,
+// name and value included.
+const maxCookieSize = 4096
+
+// minSecretKeyLength is the minimum length of a secret key in bytes.
+const minSecretKeyLength = 32
+
+// errCookieTooLarge is returned when a sealed session does not fit in a
+// cookie.
+var errCookieTooLarge = errors.New("sessions: session too large for a cookie; use a Store")
+
+// cookieCodec seals session records into cookie values. Values are signed
+// with HMAC-SHA256, or encrypted with AES-256-GCM when encrypt is set. The
+// first key seals; every key is tried when opening, so keys can be rotated
+// by prepending a new one.
+type cookieCodec struct {
+	name    string
+	encrypt bool
+	keys    []cookieKeys
+}
+
+// cookieKeys are the keys derived from one secret.
+type cookieKeys struct {
+	aead cipher.AEAD
+	mac  []byte
+}
+
+// newCookieCodec derives the keys for cookie name from secrets.
+func newCookieCodec(name string, secrets [][]byte, encrypt bool) (*cookieCodec, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("sessions: SecretKeys are required for cookie sessions")
+	}
+
+	codec := &cookieCodec{name: name, encrypt: encrypt}
+	for _, secret := range secrets {
+		if len(secret) < minSecretKeyLength {
+			return nil, errors.New("sessions: secret keys must be at least 32 bytes")
+		}
+		block, err := aes.NewCipher(deriveKey(secret, "encrypt"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.keys = append(codec.keys, cookieKeys{aead: aead, mac: deriveKey(secret, "sign")})
+	}
+	return codec, nil
+}
+
+// seal encodes record into a cookie value.
+func (c *cookieCodec) seal(record Record) (string, error) {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+
+	var value string
+	if c.encrypt {
+		aead := c.keys[0].aead
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		value = base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, payload, []byte(c.name)))
+	} else {
+		encoded := base64.RawURLEncoding.EncodeToString(payload)
+		value = encoded + "." + base64.RawURLEncoding.EncodeToString(c.mac(c.keys[0].mac, encoded))
+	}
+
+	if len(c.name)+1+len(value) > maxCookieSize {
+		return "", errCookieTooLarge
+	}
+	return value, nil
+}
+
+// open decodes a cookie value sealed by seal. It returns nil if the value
+// was tampered with, sealed with an unknown key or is malformed.
+func (c *cookieCodec) open(value string) *Record {
+	var payload []byte
+	if c.encrypt {
+		sealed, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil
+		}
+		for _, keys := range c.keys {
+			nonceSize := keys.aead.NonceSize()
+			if len(sealed) < nonceSize {
+				return nil
+			}
+			if opened, err := keys.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], []byte(c.name)); err == nil {
+				payload = opened
+				break
+			}
+		}
+	} else {
+		encoded, sig, ok := strings.Cut(value, ".")
+		if !ok {
+			return nil
+		}
+		mac, err := base64.RawURLEncoding.DecodeString(sig)
+		if err != nil {
+			return nil
+		}
+		for _, keys := range c.keys {
+			if hmac.Equal(mac, c.mac(keys.mac, encoded)) {
+				payload, _ = base64.RawURLEncoding.DecodeString(encoded)
+				break
+			}
+		}
+	}
+	if payload == nil {
+		return nil
+	}
+
+	var record Record
+	if err := json.Unmarshal(payload, &record); err != nil {
+		return nil
+	}
+	return &record
+}
+
+// mac signs the cookie name and encoded payload, so a value cannot be moved
+// to another cookie.
+func (c *cookieCodec) mac(key []byte, encoded string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(c.name))
+	h.Write([]byte{0})
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+// deriveKey derives a 32-byte key for purpose from secret, so the same
+// secret is never used for both signing and encryption.
+func deriveKey(secret []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte("rtr/sessions " + purpose))
+	return h.Sum(nil)
+}
@@ -0,0 +1,321 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+const (
+	defaultCookieName      = "session"
+	defaultIdleTimeout     = 30 * time.Minute
+	defaultAbsoluteTimeout = 24 * time.Hour
+
+	// touchInterval is how often the last-seen time of an unchanged session
+	// is refreshed, to avoid a write on every request.
+	touchInterval = time.Minute
+)
+
+// Config configures a Manager.
+type Config struct {
+	// Store keeps sessions server-side, with only the session ID in the
+	// cookie. Optional; if nil, the whole session is kept in the cookie,
+	// which must then stay under 4KB.
+	Store Store
+
+	// SecretKeys sign or encrypt cookie sessions. Required if Store is nil.
+	// Keys must be at least 32 random bytes. The first key seals new
+	// cookies; the others are only used to open existing ones, so a key can
+	// be rotated by prepending a new one and dropping the old one later.
+	SecretKeys [][]byte
+	// Encrypt encrypts cookie sessions with AES-GCM. Otherwise they are only
+	// signed and the client can read, but not change, their content.
+	Encrypt bool
+
+	// CookieName is the name of the session cookie. Default "session".
+	CookieName string
+	// CookiePath is the path of the session cookie. Default "/".
+	CookiePath string
+	// CookieDomain is the domain of the session cookie. Optional.
+	CookieDomain string
+	// CookieSecure restricts the session cookie to HTTPS.
+	CookieSecure bool
+	// CookieSameSite is the SameSite mode of the session cookie. Default
+	// http.SameSiteLaxMode.
+	CookieSameSite http.SameSite
+
+	// IdleTimeout expires sessions not used for this long. Default 30
+	// minutes; negative disables it.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout expires sessions this long after they were created,
+	// however active. Default 24 hours; negative disables it.
+	AbsoluteTimeout time.Duration
+}
+
+// Manager creates, loads and saves sessions. It is created by NewManager
+// and attached to a router with Middleware.
+//
+// A Manager also implements middlewares.AuthSessionStore, so it can be
+// passed as the SessionStore of middlewares.AuthMiddleware together with
+// the same cookie name.
+type Manager struct {
+	config Config
+	codec  *cookieCodec
+}
+
+// NewManager creates a Manager. It returns an error if cookie sessions are
+// configured without valid SecretKeys.
+func NewManager(config Config) (*Manager, error) {
+	if config.CookieName == "" {
+		config.CookieName = defaultCookieName
+	}
+	if config.CookiePath == "" {
+		config.CookiePath = "/"
+	}
+	if config.CookieSameSite == 0 {
+		config.CookieSameSite = http.SameSiteLaxMode
+	}
+	if config.IdleTimeout == 0 {
+		config.IdleTimeout = defaultIdleTimeout
+	}
+	if config.AbsoluteTimeout == 0 {
+		config.AbsoluteTimeout = defaultAbsoluteTimeout
+	}
+
+	m := &Manager{config: config}
+	if config.Store == nil {
+		codec, err := newCookieCodec(config.CookieName, config.SecretKeys, config.Encrypt)
+		if err != nil {
+			return nil, err
+		}
+		m.codec = codec
+	}
+	return m, nil
+}
+
+// CookieName returns the name of the session cookie.
+func (m *Manager) CookieName() string {
+	return m.config.CookieName
+}
+
+// Middleware returns a middleware that loads the session of each request
+// into its context, see FromContext, and saves it before the response is
+// written. Expired or invalid sessions are replaced by new, empty ones.
+//
+// If the Store fails to load a session, the request is answered with 500
+// Internal Server Error.
+func (m *Manager) Middleware() rtr.MiddlewareInterface {
+	return rtr.NewMiddleware().
+		SetName("Sessions").
+		SetHandler(m.Handler)
+}
+
+// Handler is the middleware handler managing sessions.
+func (m *Manager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := m.load(r)
+		if err != nil {
+			slog.Default().Error("sessions: loading session failed", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		sw := &sessionResponseWriter{ResponseWriter: w, manager: m, request: r, session: session}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session)))
+		sw.commit()
+	})
+}
+
+// SessionFindByKey returns the session whose cookie value is key, or nil if
+// there is none. Expired sessions are returned, so callers can check
+// IsExpired. It implements middlewares.AuthSessionStore.
+func (m *Manager) SessionFindByKey(ctx context.Context, key string) (middlewares.AuthSession, error) {
+	record, err := m.find(ctx, key)
+	if err != nil || record == nil {
+		// Return an untyped nil, not a nil *Session.
+		return nil, err
+	}
+	return m.sessionFromRecord(*record), nil
+}
+
+// load returns the session of r, or a new one.
+func (m *Manager) load(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(m.config.CookieName)
+	if err != nil {
+		return m.newSession(), nil
+	}
+
+	record, err := m.find(r.Context(), cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	var session *Session
+	if record != nil {
+		session = m.sessionFromRecord(*record)
+		if session.expiredAt(time.Now()) {
+			replacement := m.newSession()
+			replacement.staleIDs = []string{record.ID}
+			session = replacement
+		}
+	} else {
+		session = m.newSession()
+	}
+	session.hadCookie = true
+	return session, nil
+}
+
+// find returns the record of cookie value key, or nil if there is none.
+func (m *Manager) find(ctx context.Context, key string) (*Record, error) {
+	if m.codec != nil {
+		return m.codec.open(key), nil
+	}
+	if !validID(key) {
+		return nil, nil
+	}
+	return m.config.Store.Load(ctx, key)
+}
+
+// newSession returns an empty session using the configured timeouts.
+func (m *Manager) newSession() *Session {
+	return newSession(m.config.IdleTimeout, m.config.AbsoluteTimeout)
+}
+
+// sessionFromRecord returns an existing session holding record.
+func (m *Manager) sessionFromRecord(record Record) *Session {
+	if record.Values == nil {
+		record.Values = map[string]any{}
+	}
+	return &Session{
+		record:          record,
+		idleTimeout:     m.config.IdleTimeout,
+		absoluteTimeout: m.config.AbsoluteTimeout,
+	}
+}
+
+// save persists session, if needed, and sets or expires its cookie.
+func (m *Manager) save(w http.ResponseWriter, r *http.Request, session *Session) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	var errs []error
+	if m.config.Store != nil {
+		for _, id := range session.staleIDs {
+			errs = append(errs, m.config.Store.Delete(r.Context(), id))
+		}
+	}
+	session.staleIDs = nil
+
+	if session.destroyed {
+		if session.hadCookie {
+			m.expireCookie(w)
+		}
+		return errors.Join(errs...)
+	}
+
+	now := time.Now()
+	if !session.isNew && now.Sub(session.record.LastSeenAt) >= touchInterval {
+		session.record.LastSeenAt = now
+		session.dirty = true
+	}
+	if !session.dirty {
+		if session.isNew && session.hadCookie {
+			// The cookie held an invalid or expired session.
+			m.expireCookie(w)
+		}
+		return errors.Join(errs...)
+	}
+
+	expiresAt := session.expiresAt()
+	var ttl time.Duration
+	if !expiresAt.IsZero() {
+		ttl = expiresAt.Sub(now)
+	}
+
+	value := session.record.ID
+	if m.config.Store != nil {
+		if err := m.config.Store.Save(r.Context(), session.record, ttl); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	} else {
+		sealed, err := m.codec.seal(session.record)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		value = sealed
+	}
+
+	cookie := m.cookie(value)
+	if !expiresAt.IsZero() {
+		cookie.Expires = expiresAt
+	}
+	http.SetCookie(w, cookie)
+	session.dirty = false
+	session.isNew = false
+	return errors.Join(errs...)
+}
+
+// cookie returns the session cookie holding value.
+func (m *Manager) cookie(value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.config.CookieName,
+		Value:    value,
+		Path:     m.config.CookiePath,
+		Domain:   m.config.CookieDomain,
+		Secure:   m.config.CookieSecure,
+		HttpOnly: true,
+		SameSite: m.config.CookieSameSite,
+	}
+}
+
+// expireCookie tells the client to delete the session cookie.
+func (m *Manager) expireCookie(w http.ResponseWriter) {
+	cookie := m.cookie("")
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// sessionResponseWriter saves the session before the response headers are
+// sent, as the cookie must be among them.
+type sessionResponseWriter struct {
+	http.ResponseWriter
+	manager *Manager
+	request *http.Request
+	session *Session
+	once    sync.Once
+}
+
+// commit saves the session once. Errors are logged, as the response can no
+// longer be changed.
+func (w *sessionResponseWriter) commit() {
+	w.once.Do(func() {
+		if err := w.manager.save(w.ResponseWriter, w.request, w.session); err != nil {
+			slog.Default().Error("sessions: saving session failed", "error", err)
+		}
+	})
+}
+
+func (w *sessionResponseWriter) WriteHeader(statusCode int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *sessionResponseWriter) Write(b []byte) (int, error) {
+	w.commit()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionResponseWriter) Flush() {
+	w.commit()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *sessionResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,286 @@
+package sessions_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+	"github.com/dracory/rtr/sessions"
+)
+
+var (
+	testKey    = bytes.Repeat([]byte("k"), 32)
+	testOldKey = bytes.Repeat([]byte("o"), 32)
+)
+
+// newSessionRouter returns a router exercising the session API.
+func newSessionRouter(manager *sessions.Manager) rtr.RouterInterface {
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{manager.Middleware()})
+	router.AddRoute(rtr.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.FromContext(r.Context())
+		session.Login("user-1")
+		session.AddFlash("success", "Welcome")
+	}))
+	router.AddRoute(rtr.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.FromContext(r.Context())
+		flashes := session.Flashes()
+		fmt.Fprint(w, session.GetUserID())
+		for _, flash := range flashes {
+			fmt.Fprint(w, "|"+flash.Type+":"+flash.Message)
+		}
+	}))
+	router.AddRoute(rtr.Get("/cart", func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.FromContext(r.Context())
+		if item := r.URL.Query().Get("add"); item != "" {
+			session.Set("cart", item)
+		}
+		fmt.Fprint(w, session.GetString("cart"))
+	}))
+	router.AddRoute(rtr.Get("/big", func(w http.ResponseWriter, r *http.Request) {
+		sessions.FromContext(r.Context()).Set("big", strings.Repeat("x", 5000))
+	}))
+	router.AddRoute(rtr.Get("/logout", func(w http.ResponseWriter, r *http.Request) {
+		session := sessions.FromContext(r.Context())
+		session.Destroy()
+		session.AddFlash("info", "Bye")
+	}))
+	return router
+}
+
+// sessionRequest sends a GET request with cookie, returning the body and the
+// session cookie set by the response, if any.
+func sessionRequest(handler http.Handler, path string, cookie *http.Cookie) (string, *http.Cookie) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == "session" {
+			return rr.Body.String(), c
+		}
+	}
+	return rr.Body.String(), nil
+}
+
+func TestManager_Sessions(t *testing.T) {
+	managers := map[string]func(t *testing.T) *sessions.Manager{
+		"memory": func(t *testing.T) *sessions.Manager {
+			return mustManager(t, sessions.Config{Store: newMemoryStore(t)})
+		},
+		"file": func(t *testing.T) *sessions.Manager {
+			store, err := sessions.NewFileStore(filepath.Join(t.TempDir(), "sessions"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return mustManager(t, sessions.Config{Store: store})
+		},
+		"signed cookie": func(t *testing.T) *sessions.Manager {
+			return mustManager(t, sessions.Config{SecretKeys: [][]byte{testKey}})
+		},
+		"encrypted cookie": func(t *testing.T) *sessions.Manager {
+			return mustManager(t, sessions.Config{SecretKeys: [][]byte{testKey}, Encrypt: true})
+		},
+	}
+
+	for name, newManager := range managers {
+		t.Run(name, func(t *testing.T) {
+			router := newSessionRouter(newManager(t))
+			serverSide := !strings.HasSuffix(name, "cookie")
+
+			if body, cookie := sessionRequest(router, "/me", nil); body != "" || cookie != nil {
+				t.Fatalf("Expected an unused session not to be saved, got %q, %v", body, cookie)
+			}
+
+			_, anonymous := sessionRequest(router, "/cart?add=book", nil)
+			if anonymous == nil || !anonymous.HttpOnly || anonymous.SameSite != http.SameSiteLaxMode {
+				t.Fatalf("Expected an HttpOnly, SameSite session cookie, got %v", anonymous)
+			}
+
+			_, loggedIn := sessionRequest(router, "/login", anonymous)
+			if loggedIn == nil || loggedIn.Value == anonymous.Value {
+				t.Fatalf("Expected login to issue a new session cookie, got %v", loggedIn)
+			}
+			if body, _ := sessionRequest(router, "/cart", loggedIn); body != "book" {
+				t.Errorf("Expected data to survive login, got %q", body)
+			}
+			if serverSide {
+				if body, _ := sessionRequest(router, "/me", anonymous); body != "" {
+					t.Errorf("Expected the pre-login session ID to be invalidated, got %q", body)
+				}
+			}
+
+			body, consumed := sessionRequest(router, "/me", loggedIn)
+			if body != "user-1|success:Welcome" {
+				t.Errorf("Expected user and flash, got %q", body)
+			}
+			if consumed != nil {
+				loggedIn = consumed
+			}
+			if body, _ := sessionRequest(router, "/me", loggedIn); body != "user-1" {
+				t.Errorf("Expected flash to be shown once, got %q", body)
+			}
+
+			body, loggedOut := sessionRequest(router, "/logout", loggedIn)
+			if loggedOut == nil || loggedOut.Value == loggedIn.Value {
+				t.Fatalf("Expected logout to replace the session cookie, got %v", loggedOut)
+			}
+			if body, _ := sessionRequest(router, "/me", loggedOut); body != "|info:Bye" {
+				t.Errorf("Expected a new session with the logout flash, got %q", body)
+			}
+			if serverSide {
+				if body, _ := sessionRequest(router, "/me", loggedIn); body != "" {
+					t.Errorf("Expected the destroyed session to be gone, got %q", body)
+				}
+			}
+		})
+	}
+}
+
+func TestManager_Timeouts(t *testing.T) {
+	ctx := context.Background()
+	store := newMemoryStore(t)
+	router := newSessionRouter(mustManager(t, sessions.Config{Store: store}))
+
+	tests := []struct {
+		name string
+		age  func(record *sessions.Record)
+	}{
+		{"idle", func(record *sessions.Record) { record.LastSeenAt = time.Now().Add(-31 * time.Minute) }},
+		{"absolute", func(record *sessions.Record) { record.CreatedAt = time.Now().Add(-25 * time.Hour) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cookie := sessionRequest(router, "/login", nil)
+
+			record, err := store.Load(ctx, cookie.Value)
+			if err != nil || record == nil {
+				t.Fatalf("Expected stored session, got %v, %v", record, err)
+			}
+			tt.age(record)
+			_ = store.Save(ctx, *record, time.Hour)
+
+			body, expired := sessionRequest(router, "/me", cookie)
+			if body != "" {
+				t.Errorf("Expected an expired session to be replaced, got %q", body)
+			}
+			if expired == nil || expired.MaxAge >= 0 {
+				t.Errorf("Expected the cookie to be expired, got %v", expired)
+			}
+			if record, _ := store.Load(ctx, cookie.Value); record != nil {
+				t.Error("Expected the expired session to be deleted")
+			}
+		})
+	}
+}
+
+func TestManager_CookieKeys(t *testing.T) {
+	for _, config := range []sessions.Config{
+		{},
+		{SecretKeys: [][]byte{[]byte("too short")}},
+	} {
+		if _, err := sessions.NewManager(config); err == nil {
+			t.Errorf("Expected an error for keys %q", config.SecretKeys)
+		}
+	}
+
+	old := newSessionRouter(mustManager(t, sessions.Config{SecretKeys: [][]byte{testOldKey}}))
+	rotated := newSessionRouter(mustManager(t, sessions.Config{SecretKeys: [][]byte{testKey, testOldKey}}))
+	current := newSessionRouter(mustManager(t, sessions.Config{SecretKeys: [][]byte{testKey}}))
+
+	_, cookie := sessionRequest(old, "/login", nil)
+	if body, _ := sessionRequest(rotated, "/me", cookie); !strings.HasPrefix(body, "user-1") {
+		t.Errorf("Expected an old key to still open cookies, got %q", body)
+	}
+	if body, _ := sessionRequest(current, "/me", cookie); body != "" {
+		t.Errorf("Expected a dropped key to no longer open cookies, got %q", body)
+	}
+
+	tampered := *cookie
+	payload, sig, _ := strings.Cut(cookie.Value, ".")
+	decoded, _ := base64.RawURLEncoding.DecodeString(payload)
+	forged := strings.Replace(string(decoded), "user-1", "admin", 1)
+	tampered.Value = base64.RawURLEncoding.EncodeToString([]byte(forged)) + "." + sig
+	if body, _ := sessionRequest(old, "/me", &tampered); body != "" {
+		t.Errorf("Expected a tampered cookie to be rejected, got %q", body)
+	}
+
+	encrypted := newSessionRouter(mustManager(t, sessions.Config{SecretKeys: [][]byte{testKey}, Encrypt: true}))
+	_, cookie = sessionRequest(encrypted, "/login", nil)
+	decoded, _ = base64.RawURLEncoding.DecodeString(cookie.Value)
+	if bytes.Contains(decoded, []byte("user-1")) {
+		t.Error("Expected encrypted cookies not to reveal their content")
+	}
+
+	if _, cookie := sessionRequest(current, "/big", nil); cookie != nil {
+		t.Errorf("Expected a session over 4KB not to be set as a cookie, got %d bytes", len(cookie.Value))
+	}
+}
+
+// testUser implements middlewares.AuthUser.
+type testUser struct{ id string }
+
+func (u *testUser) IsActive() bool                { return true }
+func (u *testUser) IsAdministrator() bool         { return false }
+func (u *testUser) IsSuperuser() bool             { return false }
+func (u *testUser) IsRegistrationCompleted() bool { return true }
+
+type testUserStore struct{}
+
+func (testUserStore) UserFindByID(ctx context.Context, id string) (middlewares.AuthUser, error) {
+	return &testUser{id: id}, nil
+}
+
+type testContextKey string
+
+func TestManager_AuthMiddleware(t *testing.T) {
+	for name, config := range map[string]sessions.Config{
+		"store":  {Store: newMemoryStore(t)},
+		"cookie": {SecretKeys: [][]byte{testKey}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			manager := mustManager(t, config)
+			router := newSessionRouter(manager)
+			router.AddRoute(rtr.Get("/profile", func(w http.ResponseWriter, r *http.Request) {
+				if user, ok := r.Context().Value(testContextKey("user")).(*testUser); ok {
+					fmt.Fprint(w, user.id)
+				}
+			}).AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.AuthMiddleware(middlewares.AuthMiddlewareConfig{
+				SessionStore:      manager,
+				UserStore:         testUserStore{},
+				ContextKeyUser:    testContextKey("user"),
+				ContextKeySession: testContextKey("session"),
+				CookieName:        manager.CookieName(),
+			})}))
+
+			_, cookie := sessionRequest(router, "/login", nil)
+			if body, _ := sessionRequest(router, "/profile", cookie); body != "user-1" {
+				t.Errorf("Expected AuthMiddleware to resolve the user, got %q", body)
+			}
+			if body, _ := sessionRequest(router, "/profile", &http.Cookie{Name: "session", Value: "forged"}); body != "" {
+				t.Errorf("Expected an unknown session to be anonymous, got %q", body)
+			}
+		})
+	}
+}
+
+func mustManager(t *testing.T, config sessions.Config) *sessions.Manager {
+	t.Helper()
+	manager, err := sessions.NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	return manager
+}
@@ -0,0 +1,273 @@
+// Package sessions provides HTTP sessions for rtr routers, kept either in a
+// signed or encrypted cookie or in a server-side Store.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"sync"
+	"time"
+
+	"github.com/dracory/rtr/middlewares"
+)
+
+// sessionIDBytes is the number of random bytes in a session ID.
+const sessionIDBytes = 32
+
+// Compile-time checks that sessions plug into AuthMiddleware.
+var (
+	_ middlewares.AuthSession      = (*Session)(nil)
+	_ middlewares.AuthSessionStore = (*Manager)(nil)
+)
+
+// sessionContextKey is the context key for the current *Session.
+type sessionContextKey struct{}
+
+// FromContext returns the session loaded by Manager.Middleware, or nil if the
+// middleware did not run.
+func FromContext(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionContextKey{}).(*Session)
+	return session
+}
+
+// Flash is a one-time message shown on the next page, e.g. after a redirect.
+type Flash struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// Session is the session of the current request. It is safe for concurrent
+// use. Changes are saved just before the response headers are sent; changes
+// made after that are lost.
+//
+// Values are stored as JSON, so after a round trip numbers come back as
+// float64 and structs as map[string]any.
+type Session struct {
+	mu sync.Mutex
+
+	record          Record
+	idleTimeout     time.Duration
+	absoluteTimeout time.Duration
+
+	// isNew is set for sessions created during this request.
+	isNew bool
+	// dirty is set when the record must be saved.
+	dirty bool
+	// destroyed is set by Destroy.
+	destroyed bool
+	// staleIDs are IDs to delete from the store on commit, after RenewID or
+	// when an expired session was replaced.
+	staleIDs []string
+	// hadCookie is set when the request carried a session cookie, which must
+	// be expired if the session is not saved.
+	hadCookie bool
+}
+
+// newSession creates an empty session with a fresh ID.
+func newSession(idleTimeout, absoluteTimeout time.Duration) *Session {
+	now := time.Now()
+	return &Session{
+		record: Record{
+			ID:         newSessionID(),
+			Values:     map[string]any{},
+			CreatedAt:  now,
+			LastSeenAt: now,
+		},
+		idleTimeout:     idleTimeout,
+		absoluteTimeout: absoluteTimeout,
+		isNew:           true,
+	}
+}
+
+// ID returns the session ID. It changes after RenewID.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.ID
+}
+
+// Get returns the value stored under key, or nil.
+func (s *Session) Get(key string) any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.Values[key]
+}
+
+// GetString returns the value stored under key if it is a string, or "".
+func (s *Session) GetString(key string) string {
+	value, _ := s.Get(key).(string)
+	return value
+}
+
+// Set stores value under key. The value must be JSON-encodable.
+func (s *Session) Set(key string, value any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reviveLocked()
+	s.record.Values[key] = value
+	s.dirty = true
+}
+
+// Delete removes the value stored under key.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.record.Values[key]; ok {
+		delete(s.record.Values, key)
+		s.dirty = true
+	}
+}
+
+// GetUserID returns the ID of the logged in user, or "". It implements
+// middlewares.AuthSession.
+func (s *Session) GetUserID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.UserID
+}
+
+// SetUserID sets the ID of the logged in user. Call it on login together
+// with RenewID, and with "" on logout.
+func (s *Session) SetUserID(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reviveLocked()
+	s.record.UserID = userID
+	s.dirty = true
+}
+
+// Login sets the logged in user and renews the session ID, preventing
+// session fixation.
+func (s *Session) Login(userID string) {
+	s.SetUserID(userID)
+	s.RenewID()
+}
+
+// CreatedAt returns when the session was created. It is kept by RenewID.
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.record.CreatedAt
+}
+
+// IsExpired reports whether the session has been idle longer than the idle
+// timeout or has outlived the absolute timeout. It implements
+// middlewares.AuthSession.
+func (s *Session) IsExpired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expiredAt(time.Now())
+}
+
+// expiredAt reports whether the session is expired at now. The caller must
+// hold s.mu.
+func (s *Session) expiredAt(now time.Time) bool {
+	expiresAt := s.expiresAt()
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
+
+// expiresAt returns when the session expires if not used again, or the zero
+// time if it never does. The caller must hold s.mu.
+func (s *Session) expiresAt() time.Time {
+	var expiresAt time.Time
+	if s.idleTimeout > 0 {
+		expiresAt = s.record.LastSeenAt.Add(s.idleTimeout)
+	}
+	if s.absoluteTimeout > 0 {
+		absolute := s.record.CreatedAt.Add(s.absoluteTimeout)
+		if expiresAt.IsZero() || absolute.Before(expiresAt) {
+			expiresAt = absolute
+		}
+	}
+	return expiresAt
+}
+
+// AddFlash queues a flash message for the next call to Flashes.
+func (s *Session) AddFlash(flashType, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reviveLocked()
+	s.record.Flashes = append(s.record.Flashes, Flash{Type: flashType, Message: message})
+	s.dirty = true
+}
+
+// Flashes returns and removes the queued flash messages. Call it before
+// writing the response, or the removal is not saved.
+func (s *Session) Flashes() []Flash {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flashes := s.record.Flashes
+	if len(flashes) > 0 {
+		s.record.Flashes = nil
+		s.dirty = true
+	}
+	return flashes
+}
+
+// RenewID gives the session a new ID, keeping its data, and invalidates the
+// old one. Call it whenever the privilege level changes, e.g. on login.
+func (s *Session) RenewID() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew {
+		s.staleIDs = append(s.staleIDs, s.record.ID)
+	}
+	s.record.ID = newSessionID()
+	s.isNew = true
+	s.dirty = true
+}
+
+// Destroy deletes the session and expires its cookie, e.g. on logout. A
+// later Set starts a new, empty session.
+//
+// Cookie sessions cannot be revoked server-side: a copy of the old cookie
+// stays valid until it times out.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew {
+		s.staleIDs = append(s.staleIDs, s.record.ID)
+	}
+	s.destroyed = true
+}
+
+// reviveLocked replaces a destroyed session with a new, empty one so it can
+// be written to again. The caller must hold s.mu.
+func (s *Session) reviveLocked() {
+	if !s.destroyed {
+		if s.record.Values == nil {
+			s.record.Values = map[string]any{}
+		}
+		return
+	}
+	now := time.Now()
+	s.record = Record{
+		ID:         newSessionID(),
+		Values:     map[string]any{},
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	s.isNew = true
+	s.destroyed = false
+}
+
+// newSessionID returns a random, URL-safe session ID.
+func newSessionID() string {
+	b := make([]byte, sessionIDBytes)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validID reports whether id looks like an ID from newSessionID.
+func validID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(sessionIDBytes) {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,242 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// Record is the persisted state of a session.
+type Record struct {
+	ID         string         `json:"id"`
+	UserID     string         `json:"user_id,omitempty"`
+	Values     map[string]any `json:"values,omitempty"`
+	Flashes    []Flash        `json:"flashes,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	LastSeenAt time.Time      `json:"last_seen_at"`
+}
+
+// Store keeps server-side sessions by ID. Implementations must be safe for
+// concurrent use. Records are JSON-encodable, so a store backed by a
+// database or Redis can simply marshal them.
+type Store interface {
+	// Load returns the record with the given ID, or nil if there is none or
+	// it has expired.
+	Load(ctx context.Context, id string) (*Record, error)
+	// Save stores record, replacing any record with the same ID. The record
+	// may be discarded after ttl; a ttl of 0 means it does not expire.
+	Save(ctx context.Context, record Record, ttl time.Duration) error
+	// Delete removes the record with the given ID. Deleting a missing record
+	// is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// NewMemoryStore returns an in-process Store. Sessions are lost on restart
+// and are not shared between processes. Expired sessions are removed in the
+// background until Close is called.
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		cache: ttlcache.New[string, []byte](ttlcache.WithDisableTouchOnHit[string, []byte]()),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go store.removeExpired()
+	return store
+}
+
+// memoryStoreCleanupInterval is how often MemoryStore removes expired
+// sessions.
+const memoryStoreCleanupInterval = time.Minute
+
+// MemoryStore is a Store backed by a TTL cache. It is created by
+// NewMemoryStore. Records are kept encoded, so callers never share maps with
+// the store.
+type MemoryStore struct {
+	cache *ttlcache.Cache[string, []byte]
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// Close stops removing expired sessions in the background. The store stays
+// usable, but expired sessions are only dropped when loaded. Calling Close
+// more than once is safe.
+func (s *MemoryStore) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+	return nil
+}
+
+// removeExpired periodically deletes expired sessions until Close is called.
+func (s *MemoryStore) removeExpired() {
+	defer close(s.done)
+	ticker := time.NewTicker(memoryStoreCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.cache.DeleteExpired()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Load implements Store.
+func (s *MemoryStore) Load(ctx context.Context, id string) (*Record, error) {
+	item := s.cache.Get(id)
+	if item == nil || item.IsExpired() {
+		return nil, nil
+	}
+	var record Record
+	if err := json.Unmarshal(item.Value(), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Save implements Store.
+func (s *MemoryStore) Save(ctx context.Context, record Record, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = ttlcache.NoTTL
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	s.cache.Set(record.ID, data, ttl)
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.cache.Delete(id)
+	return nil
+}
+
+// NewFileStore returns a Store that keeps each session in its own JSON file
+// in dir, which is created if needed. Expired files are removed when loaded
+// and by Cleanup. It is meant for single-instance deployments.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// FileStore is a Store keeping sessions as files. It is created by
+// NewFileStore.
+type FileStore struct {
+	dir string
+}
+
+// fileRecord is the on-disk form of a session.
+type fileRecord struct {
+	Record    Record    `json:"record"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+}
+
+// Load implements Store.
+func (s *FileStore) Load(ctx context.Context, id string) (*Record, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stored fileRecord
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if !stored.ExpiresAt.IsZero() && !time.Now().Before(stored.ExpiresAt) {
+		_ = os.Remove(path)
+		return nil, nil
+	}
+	return &stored.Record, nil
+}
+
+// Save implements Store. The file is written atomically.
+func (s *FileStore) Save(ctx context.Context, record Record, ttl time.Duration) error {
+	path, err := s.path(record.ID)
+	if err != nil {
+		return err
+	}
+
+	stored := fileRecord{Record: record}
+	if ttl > 0 {
+		stored.ExpiresAt = time.Now().Add(ttl)
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".session-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Delete implements Store.
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Cleanup removes the files of expired sessions. Call it periodically.
+func (s *FileStore) Cleanup(ctx context.Context) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		if _, err := s.Load(ctx, strings.TrimSuffix(entry.Name(), ".json")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the file for session id. IDs are generated by the manager,
+// but they also arrive in cookies, so anything that is not a plain
+// base64url token is rejected to keep paths inside dir.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", errors.New("sessions: invalid session ID")
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
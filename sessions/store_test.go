@@ -0,0 +1,128 @@
+package sessions_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr/sessions"
+)
+
+// newMemoryStore returns a MemoryStore closed when the test ends.
+func newMemoryStore(t *testing.T) *sessions.MemoryStore {
+	t.Helper()
+	store := sessions.NewMemoryStore()
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) sessions.Store{
+		"memory": func(t *testing.T) sessions.Store {
+			return newMemoryStore(t)
+		},
+		"file": func(t *testing.T) sessions.Store {
+			store, err := sessions.NewFileStore(filepath.Join(t.TempDir(), "sessions"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return store
+		},
+	}
+
+	const id = "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	const expiredID = "BBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBBB"
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+
+			record := sessions.Record{ID: id, UserID: "user-1", Values: map[string]any{"theme": "dark"}, CreatedAt: time.Now()}
+			if err := store.Save(ctx, record, time.Hour); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Save(ctx, sessions.Record{ID: expiredID}, time.Nanosecond); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+
+			loaded, err := store.Load(ctx, id)
+			if err != nil || loaded == nil {
+				t.Fatalf("Expected record, got %v, %v", loaded, err)
+			}
+			if loaded.UserID != "user-1" || loaded.Values["theme"] != "dark" {
+				t.Errorf("Unexpected record %+v", loaded)
+			}
+			if loaded, _ := store.Load(ctx, expiredID); loaded != nil {
+				t.Error("Expected expired record to be gone")
+			}
+
+			if err := store.Delete(ctx, id); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if loaded, _ := store.Load(ctx, id); loaded != nil {
+				t.Error("Expected deleted record to be gone")
+			}
+			if err := store.Delete(ctx, id); err != nil {
+				t.Errorf("Expected deleting a missing record to succeed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryStore_Close(t *testing.T) {
+	ctx := context.Background()
+	store := sessions.NewMemoryStore()
+
+	done := make(chan error, 1)
+	go func() {
+		_ = store.Close()
+		done <- store.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to stop the cleanup goroutine and return")
+	}
+
+	if err := store.Save(ctx, sessions.Record{ID: "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"}, time.Hour); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if loaded, _ := store.Load(ctx, "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"); loaded == nil {
+		t.Error("Expected the store to stay usable after Close")
+	}
+}
+
+func TestFileStore_RejectsPathsAndCleansUp(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := sessions.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := store.Save(ctx, sessions.Record{ID: "../escape"}, time.Hour); err == nil {
+		t.Error("Expected an error for an invalid session ID")
+	}
+	if loaded, err := store.Load(ctx, "../../etc/passwd"); loaded != nil || err != nil {
+		t.Errorf("Expected invalid IDs to be treated as missing, got %v, %v", loaded, err)
+	}
+
+	_ = store.Save(ctx, sessions.Record{ID: "CCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCCC"}, time.Nanosecond)
+	_ = store.Save(ctx, sessions.Record{ID: "DDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDD"}, 0)
+	time.Sleep(time.Millisecond)
+
+	if err := store.Cleanup(ctx); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != "DDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDDD.json" {
+		t.Errorf("Expected only the non-expiring session to remain, got %v", entries)
+	}
+}
@@ -0,0 +1,265 @@
+package rtr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSSEHeartbeat is how often an EventStream sends a heartbeat comment
+// while idle, unless changed with EventStream.SetHeartbeat.
+const DefaultSSEHeartbeat = 15 * time.Second
+
+// SSEHandler is a handler streaming server-sent events. It sends events with
+// stream.Send until it is done or ctx, derived from the request's context,
+// is canceled because the client disconnected or, when served by a Server,
+// the server drains after its ShutdownDelay. Clients then reconnect, to
+// another instance behind a load balancer.
+//
+// If it returns an error before sending anything, the error is rendered with
+// RenderError as a 500 Internal Server Error; afterwards the stream is
+// simply closed. Returning nil without sending anything answers
+// 204 No Content, which tells EventSource clients to stop reconnecting.
+// The response starts with the first event or heartbeat; send a Comment to
+// start it right away.
+type SSEHandler func(ctx context.Context, stream *EventStream) error
+
+// Event is a server-sent event.
+type Event struct {
+	// ID sets the client's last event ID, sent back in the Last-Event-ID
+	// header when it reconnects. Optional.
+	ID string
+	// Event is the event type. Optional; clients treat events without one
+	// as "message".
+	Event string
+	// Data is the event payload. Multi-line data is sent as several data
+	// lines and reassembled by the client.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	// Optional.
+	Retry time.Duration
+}
+
+// errInvalidEvent is returned by EventStream.Send for an ID or event type
+// containing a line break.
+var errInvalidEvent = errors.New("rtr: event ID and type must not contain line breaks")
+
+// EventStream writes server-sent events to a response, see SSEHandler. It is
+// safe for concurrent use.
+type EventStream struct {
+	w  http.ResponseWriter
+	r  *http.Request
+	rc *http.ResponseController
+	// ctx is the handler's context, also canceled when the server drains.
+	ctx context.Context
+
+	mu        sync.Mutex
+	open      bool
+	err       error
+	lastWrite time.Time
+	heartbeat time.Duration
+	// heartbeatChanged wakes the heartbeat loop after SetHeartbeat.
+	heartbeatChanged chan struct{}
+}
+
+// Request returns the request being served, e.g. for route parameters.
+func (s *EventStream) Request() *http.Request {
+	return s.r
+}
+
+// Header returns the response headers, which may be changed until the first
+// event is sent.
+func (s *EventStream) Header() http.Header {
+	return s.w.Header()
+}
+
+// LastEventID returns the ID of the last event the client received, from
+// the Last-Event-ID header it sends when reconnecting, so the handler can
+// resume after it. It is empty on the first connection.
+func (s *EventStream) LastEventID() string {
+	return s.r.Header.Get("Last-Event-ID")
+}
+
+// SetHeartbeat sets how often a comment is sent while no events are, to
+// keep proxies from closing the idle connection and to detect disconnected
+// clients. Zero or negative disables heartbeats.
+func (s *EventStream) SetHeartbeat(interval time.Duration) {
+	s.mu.Lock()
+	s.heartbeat = interval
+	s.mu.Unlock()
+
+	select {
+	case s.heartbeatChanged <- struct{}{}:
+	default:
+	}
+}
+
+// Send writes event to the client and flushes it. It fails once the client
+// has disconnected, or if the response cannot be flushed, e.g. behind a
+// middleware buffering the response.
+func (s *EventStream) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return errInvalidEvent
+	}
+
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\r", "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, ignored by clients.
+func (s *EventStream) Comment(text string) error {
+	var b strings.Builder
+	for line := range strings.SplitSeq(text, "\n") {
+		b.WriteString(": " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// write sends and flushes chunk, opening the stream if needed.
+func (s *EventStream) write(chunk string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.err = err
+		return err
+	}
+	if !s.open {
+		s.openLocked()
+	}
+	if _, err := s.w.Write([]byte(chunk)); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.rc.Flush(); err != nil {
+		s.err = err
+		return err
+	}
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// openLocked writes the response headers. The caller must hold s.mu.
+func (s *EventStream) openLocked() {
+	h := s.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering the stream.
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+	s.w.WriteHeader(http.StatusOK)
+	s.open = true
+}
+
+// keepAlive sends heartbeats while the stream is idle, until ctx is done.
+func (s *EventStream) keepAlive(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		interval, idle := s.heartbeat, time.Since(s.lastWrite)
+		s.mu.Unlock()
+
+		var timer *time.Timer
+		var tick <-chan time.Time
+		if interval > 0 {
+			timer = time.NewTimer(interval - min(idle, interval))
+			tick = timer.C
+		}
+
+		beat := false
+		select {
+		case <-ctx.Done():
+		case <-s.heartbeatChanged:
+		case <-tick:
+			s.mu.Lock()
+			beat = s.heartbeat > 0 && time.Since(s.lastWrite) >= s.heartbeat
+			s.mu.Unlock()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil || (beat && s.Comment("heartbeat") != nil) {
+			return
+		}
+	}
+}
+
+// SSEHandlerToHandler converts an SSEHandler to a standard Handler. The
+// event stream is flushed through the middleware wrappers of the response
+// writer with http.ResponseController, so wrappers must implement Flush or
+// Unwrap.
+func SSEHandlerToHandler(handler SSEHandler) StdHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handlerCtx, cancelHandler := context.WithCancel(r.Context())
+		defer cancelHandler()
+		if draining := serverDraining(r.Context()); draining != nil {
+			go func() {
+				select {
+				case <-draining:
+					cancelHandler()
+				case <-handlerCtx.Done():
+				}
+			}()
+		}
+
+		stream := &EventStream{
+			w:                w,
+			r:                r,
+			rc:               http.NewResponseController(w),
+			ctx:              handlerCtx,
+			lastWrite:        time.Now(),
+			heartbeat:        DefaultSSEHeartbeat,
+			heartbeatChanged: make(chan struct{}, 1),
+		}
+
+		ctx, cancel := context.WithCancel(handlerCtx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			stream.keepAlive(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		err := handler(handlerCtx, stream)
+
+		stream.mu.Lock()
+		defer stream.mu.Unlock()
+		switch {
+		case stream.open || r.Context().Err() != nil:
+		case handlerCtx.Err() != nil:
+			// Ended by the server draining: an empty stream makes the
+			// client reconnect, where 204 or an error would stop it
+			stream.openLocked()
+		case err != nil:
+			RenderError(w, r, http.StatusInternalServerError, err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		stream.err = errors.New("rtr: event stream closed")
+	}
+}
@@ -0,0 +1,149 @@
+package rtr_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// readSSE reads lines from the event stream until n blank-line terminated
+// blocks have been read.
+func readSSE(t *testing.T, reader *bufio.Reader, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for n > 0 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Unexpected error reading stream: %v (read %q)", err, b.String())
+		}
+		b.WriteString(line)
+		if line == "\n" {
+			n--
+		}
+	}
+	return b.String()
+}
+
+func TestGetSSE_StreamsThroughMiddlewares(t *testing.T) {
+	disconnected := make(chan struct{})
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{
+		middlewares.RecoveryMiddleware(),
+		middlewares.LoggerMiddleware(),
+		middlewares.CompressMiddleware(5, "text/event-stream"),
+	})
+	router.AddRoute(rtr.GetSSE("/events", func(ctx context.Context, stream *rtr.EventStream) error {
+		defer close(disconnected)
+		start := 0
+		if id := stream.LastEventID(); id != "" {
+			start, _ = strconv.Atoi(id)
+		}
+		for i := start + 1; ; i++ {
+			err := stream.Send(rtr.Event{ID: strconv.Itoa(i), Event: "tick", Data: "line 1\nline 2"})
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/events", nil)
+	req.Header.Set("Last-Event-ID", "41")
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Header.Get("Content-Type") != "text/event-stream" || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("Expected event stream headers, got %v", resp.Header)
+	}
+
+	got := readSSE(t, bufio.NewReader(resp.Body), 2)
+	want := "id: 42\nevent: tick\ndata: line 1\ndata: line 2\n\nid: 43\nevent: tick\ndata: line 1\ndata: line 2\n\n"
+	if got != want {
+		t.Errorf("Expected events resuming after Last-Event-ID, got %q", got)
+	}
+
+	_ = resp.Body.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the handler to stop when the client disconnects")
+	}
+}
+
+func TestGetSSE_Heartbeat(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.GetSSE("/events", func(ctx context.Context, stream *rtr.EventStream) error {
+		stream.SetHeartbeat(20 * time.Millisecond)
+		<-ctx.Done()
+		return nil
+	}))
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL + "/events")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if got := readSSE(t, bufio.NewReader(resp.Body), 2); got != ": heartbeat\n\n: heartbeat\n\n" {
+		t.Errorf("Expected heartbeat comments, got %q", got)
+	}
+}
+
+func TestGetSSE_Errors(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.GetSSE("/fail", func(ctx context.Context, stream *rtr.EventStream) error {
+		return errors.New("no feed")
+	}))
+	router.AddRoute(rtr.GetSSE("/done", func(ctx context.Context, stream *rtr.EventStream) error {
+		return nil
+	}))
+	router.AddRoute(rtr.GetSSE("/invalid", func(ctx context.Context, stream *rtr.EventStream) error {
+		if err := stream.Send(rtr.Event{Event: "bad\nevent"}); err == nil {
+			t.Error("Expected an event type with a line break to be rejected")
+		}
+		return stream.Send(rtr.Event{Data: "ok"})
+	}))
+	router.AddRoute(rtr.GetSSE("/buffered", func(ctx context.Context, stream *rtr.EventStream) error {
+		if err := stream.Send(rtr.Event{Data: "lost"}); err == nil {
+			t.Error("Expected Send to fail when the response cannot be flushed")
+		}
+		return nil
+	}).AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.TimeoutMiddlewareWithConfig(middlewares.TimeoutConfig{Timeout: time.Second})}))
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/fail", http.StatusInternalServerError, "Internal Server Error\n"},
+		{"/done", http.StatusNoContent, ""},
+		{"/invalid", http.StatusOK, "data: ok\n\n"},
+	}
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rr.Code != tt.status || rr.Body.String() != tt.body {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.path, tt.status, tt.body, rr.Code, rr.Body.String())
+		}
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/buffered", nil))
+}
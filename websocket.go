@@ -0,0 +1,339 @@
+package rtr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// webSocketGUID is appended to the client's key to compute
+// Sec-WebSocket-Accept, see RFC 6455 section 1.3.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocketHandler handles an accepted WebSocket connection. ctx is canceled
+// once the connection is closed. When the handler returns, the connection
+// is closed with WebSocketCloseNormal, or the code of a returned
+// *WebSocketCloseError, or WebSocketCloseInternalError for other errors.
+type WebSocketHandler func(ctx context.Context, conn *WebSocketConn) error
+
+// WebSocketConfig configures the WebSocket routes created with WebSocket,
+// see RouteInterface.SetWebSocketConfig. The zero value is usable.
+type WebSocketConfig struct {
+	// Subprotocols lists the supported subprotocols in order of preference.
+	// The first one also requested by the client is selected, see
+	// WebSocketConn.Subprotocol.
+	Subprotocols []string
+
+	// AllowedOrigins lists the origins, e.g. "https://app.example.com",
+	// allowed to connect besides the server's own. "*" allows any origin.
+	// Requests without an Origin header, i.e. from non-browser clients, are
+	// always allowed.
+	AllowedOrigins []string
+	// CheckOrigin replaces the AllowedOrigins check when set.
+	CheckOrigin func(r *http.Request) bool
+
+	// MaxMessageSize is the largest message accepted. Larger ones close the
+	// connection with WebSocketCloseMessageTooBig. Optional; defaults to
+	// 1MB, negative means no limit.
+	MaxMessageSize int64
+
+	// PingInterval is how often the peer is pinged. A connection from which
+	// nothing is received within twice the interval is closed. Optional;
+	// defaults to 30 seconds, negative disables pings.
+	PingInterval time.Duration
+
+	// WriteTimeout limits how long writing a frame may take. Optional;
+	// defaults to 10 seconds, negative means no limit.
+	WriteTimeout time.Duration
+}
+
+// withDefaults returns config with defaults applied and disabled values
+// set to zero.
+func (config WebSocketConfig) withDefaults() WebSocketConfig {
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = 1 << 20
+	}
+	if config.PingInterval == 0 {
+		config.PingInterval = 30 * time.Second
+	}
+	if config.PingInterval < 0 {
+		config.PingInterval = 0
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+	if config.WriteTimeout < 0 {
+		config.WriteTimeout = 0
+	}
+	return config
+}
+
+// WebSocketHandlerToHandler converts a WebSocketHandler to a standard
+// Handler performing the RFC 6455 opening handshake. Invalid handshakes are
+// answered with RenderError: 426 Upgrade Required for plain requests,
+// 400 Bad Request for malformed ones and 403 Forbidden for disallowed
+// origins.
+//
+// The connection is taken over with http.ResponseController, so middleware
+// wrappers of the response writer must implement Hijack or Unwrap, and
+// middlewares buffering the response, such as TimeoutMiddlewareWithConfig,
+// must exempt WebSocket routes.
+func WebSocketHandlerToHandler(config WebSocketConfig, handler WebSocketHandler) StdHandler {
+	config = config.withDefaults()
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, status, err := acceptWebSocket(w, r, config)
+		if err != nil {
+			if status != 0 {
+				RenderError(w, r, status, err)
+			}
+			return
+		}
+		defer conn.shutdown()
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-conn.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err = handler(ctx, conn)
+
+		var closeErr *WebSocketCloseError
+		switch {
+		case err == nil:
+			_ = conn.Close(WebSocketCloseNormal, "")
+		case errors.As(err, &closeErr):
+			_ = conn.Close(closeErr.Code, closeErr.Reason)
+		default:
+			_ = conn.Close(WebSocketCloseInternalError, "")
+		}
+	}
+}
+
+// acceptWebSocket validates the opening handshake of r and upgrades the
+// connection. On failure it returns the status to answer with, or zero if
+// the connection was already taken over.
+func acceptWebSocket(w http.ResponseWriter, r *http.Request, config WebSocketConfig) (*WebSocketConn, int, error) {
+	if r.Method != http.MethodGet {
+		return nil, http.StatusMethodNotAllowed, errors.New("websocket: handshake requires GET")
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return nil, http.StatusUpgradeRequired, errors.New("websocket: upgrade required")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, http.StatusUpgradeRequired, errors.New("websocket: unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, http.StatusBadRequest, errors.New("websocket: invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := config.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = func(r *http.Request) bool { return webSocketOriginAllowed(r, config.AllowedOrigins) }
+	}
+	if !checkOrigin(r) {
+		return nil, http.StatusForbidden, errors.New("websocket: origin not allowed")
+	}
+
+	subprotocol := ""
+	requested := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	for _, protocol := range config.Subprotocols {
+		if slices.Contains(requested, protocol) {
+			subprotocol = protocol
+			break
+		}
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, http.StatusInternalServerError, errors.New("websocket: connection cannot be taken over: " + err.Error())
+	}
+	if brw.Reader.Buffered() > 0 {
+		_ = netConn.Close()
+		return nil, 0, errors.New("websocket: client sent data before the handshake completed")
+	}
+	// Clear the deadlines the server set for reading the request
+	_ = netConn.SetDeadline(time.Time{})
+
+	header := w.Header().Clone()
+	for _, name := range []string{"Content-Length", "Content-Type", "Transfer-Encoding"} {
+		header.Del(name)
+	}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", webSocketAccept(key))
+	if subprotocol != "" {
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	var response bytes.Buffer
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(&response)
+	response.WriteString("\r\n")
+	if config.WriteTimeout > 0 {
+		_ = netConn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	}
+	if _, err := netConn.Write(response.Bytes()); err != nil {
+		_ = netConn.Close()
+		return nil, 0, err
+	}
+
+	conn := newWebSocketConn(netConn, brw.Reader, false, config)
+	conn.request = r
+	conn.subprotocol = subprotocol
+	conn.start()
+	return conn, 0, nil
+}
+
+// webSocketOriginAllowed reports whether the Origin of r is the server's
+// own or one of allowed.
+func webSocketOriginAllowed(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// webSocketAccept returns the Sec-WebSocket-Accept value for key.
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerTokens returns the comma-separated tokens of the named header.
+func headerTokens(header http.Header, name string) []string {
+	var tokens []string
+	for _, value := range header.Values(name) {
+		for token := range strings.SplitSeq(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// headerHasToken reports whether the named header lists token, ignoring
+// case.
+func headerHasToken(header http.Header, name, token string) bool {
+	return slices.ContainsFunc(headerTokens(header, name), func(t string) bool {
+		return strings.EqualFold(t, token)
+	})
+}
+
+// DialWebSocket opens a WebSocket connection to a ws://, wss://, http:// or
+// https:// URL, sending header with the handshake, e.g. Origin or
+// Sec-WebSocket-Protocol. It is meant for tests and simple clients: it
+// answers pings but does not ping itself, and uses the WebSocketConfig
+// defaults otherwise.
+//
+// If the server refuses the upgrade, the error is returned with its
+// response, whose body can still be read.
+func DialWebSocket(ctx context.Context, rawURL string, header http.Header) (*WebSocketConn, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+		u.Scheme = "http"
+	case "wss", "https":
+		u.Scheme = "https"
+		secure = true
+	default:
+		return nil, nil, errors.New("websocket: unsupported URL scheme " + u.Scheme)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if secure {
+		tlsConn := tls.Client(netConn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = netConn.Close()
+			return nil, nil, err
+		}
+		netConn = tlsConn
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = netConn.SetDeadline(deadline)
+	}
+
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{Method: http.MethodGet, URL: u, Host: u.Host, Header: header.Clone()}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(netConn); err != nil {
+		_ = netConn.Close()
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = netConn.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerHasToken(resp.Header, "Upgrade", "websocket") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		_ = netConn.Close()
+		return nil, resp, errors.New("websocket: bad handshake: " + resp.Status)
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	conn := newWebSocketConn(netConn, br, true, WebSocketConfig{PingInterval: -1}.withDefaults())
+	conn.subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+	conn.start()
+	return conn, resp, nil
+}
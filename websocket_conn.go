@@ -0,0 +1,484 @@
+package rtr
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// WebSocketMessageType is the type of a WebSocket data message.
+type WebSocketMessageType int
+
+// WebSocket message types.
+const (
+	WebSocketTextMessage   WebSocketMessageType = 1
+	WebSocketBinaryMessage WebSocketMessageType = 2
+)
+
+// WebSocket close codes, see RFC 6455 section 7.4.1.
+const (
+	WebSocketCloseNormal          = 1000
+	WebSocketCloseGoingAway       = 1001
+	WebSocketCloseProtocolError   = 1002
+	WebSocketCloseUnsupportedData = 1003
+	// WebSocketCloseNoStatus is reported when the peer closed without a
+	// code. It is never sent.
+	WebSocketCloseNoStatus = 1005
+	// WebSocketCloseAbnormal is reported when the connection was lost
+	// without a close handshake. It is never sent.
+	WebSocketCloseAbnormal        = 1006
+	WebSocketCloseInvalidPayload  = 1007
+	WebSocketClosePolicyViolation = 1008
+	WebSocketCloseMessageTooBig   = 1009
+	WebSocketCloseInternalError   = 1011
+)
+
+// WebSocket frame opcodes.
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xa
+)
+
+// wsCloseTimeout is how long Close waits for the peer's close frame.
+const wsCloseTimeout = 5 * time.Second
+
+// errWebSocketClosed is returned when writing to a closing connection.
+var errWebSocketClosed = errors.New("websocket: connection closed")
+
+// WebSocketCloseError reports how a WebSocket connection was closed. It is
+// returned by ReadMessage once the connection is closed. A WebSocketHandler
+// may return one to close the connection with a specific code.
+type WebSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WebSocketCloseError) Error() string {
+	msg := "websocket: closed with code " + strconv.Itoa(e.Code)
+	if e.Reason != "" {
+		msg += ": " + e.Reason
+	}
+	return msg
+}
+
+// webSocketMessage is a data message received by the read loop.
+type webSocketMessage struct {
+	messageType WebSocketMessageType
+	data        []byte
+}
+
+// WebSocketConn is an established WebSocket connection. Control frames are
+// handled in the background: pings are answered, and a close frame from the
+// peer is echoed and ends the connection. ReadMessage and WriteMessage may
+// be called concurrently with each other, and WriteMessage and Close from
+// several goroutines.
+type WebSocketConn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	request        *http.Request
+	subprotocol    string
+	maxMessageSize int64
+	writeTimeout   time.Duration
+	pingInterval   time.Duration
+
+	writeMu   sync.Mutex
+	closeSent bool
+	// closing is closed once a close frame has been sent.
+	closing chan struct{}
+
+	messages chan webSocketMessage
+	// done is closed when the read loop has ended, after closeErr is set.
+	done     chan struct{}
+	closeErr error
+	// closed is closed when the connection is shut down.
+	closed       chan struct{}
+	shutdownOnce sync.Once
+}
+
+// newWebSocketConn returns a connection after the handshake. Call start to
+// serve it.
+func newWebSocketConn(conn net.Conn, br *bufio.Reader, client bool, config WebSocketConfig) *WebSocketConn {
+	return &WebSocketConn{
+		conn:           conn,
+		br:             br,
+		client:         client,
+		maxMessageSize: config.MaxMessageSize,
+		writeTimeout:   config.WriteTimeout,
+		pingInterval:   config.PingInterval,
+		messages:       make(chan webSocketMessage),
+		closing:        make(chan struct{}),
+		done:           make(chan struct{}),
+		closed:         make(chan struct{}),
+	}
+}
+
+// start starts handling incoming frames and pinging the peer.
+func (c *WebSocketConn) start() {
+	go c.readLoop()
+	if c.pingInterval > 0 {
+		go c.pingLoop()
+	}
+}
+
+// Request returns the handshake request of a server connection, e.g. for
+// route parameters and the authenticated user. It is nil for connections
+// made with DialWebSocket.
+func (c *WebSocketConn) Request() *http.Request {
+	return c.request
+}
+
+// Subprotocol returns the negotiated subprotocol, or "" if none.
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *WebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// ReadMessage waits for the next data message. Once the connection is
+// closed it returns a *WebSocketCloseError: the peer's close code, or
+// WebSocketCloseAbnormal if the connection was lost.
+//
+// Messages are only received while ReadMessage is called; a connection that
+// is only written to should still read in a goroutine to notice when the
+// peer closes it.
+func (c *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	select {
+	case message := <-c.messages:
+		return message.messageType, message.data, nil
+	case <-c.done:
+		return 0, nil, c.closeErr
+	}
+}
+
+// WriteMessage sends a data message. Text messages must be valid UTF-8.
+func (c *WebSocketConn) WriteMessage(messageType WebSocketMessageType, data []byte) error {
+	switch messageType {
+	case WebSocketTextMessage:
+		if !utf8.Valid(data) {
+			return errors.New("websocket: text message is not valid UTF-8")
+		}
+		return c.writeFrame(wsOpText, data)
+	case WebSocketBinaryMessage:
+		return c.writeFrame(wsOpBinary, data)
+	}
+	return errors.New("websocket: invalid message type " + strconv.Itoa(int(messageType)))
+}
+
+// Close starts the close handshake with code and reason, waits briefly for
+// the peer to acknowledge it and closes the connection. Calling Close on a
+// closed connection only makes sure it is shut down.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	err := c.writeClose(code, reason)
+	if errors.Is(err, errWebSocketClosed) {
+		err = nil
+	}
+
+	timer := time.NewTimer(wsCloseTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+	case <-timer.C:
+	}
+	c.shutdown()
+	return err
+}
+
+// shutdown closes the underlying connection.
+func (c *WebSocketConn) shutdown() {
+	c.shutdownOnce.Do(func() {
+		close(c.closed)
+		_ = c.conn.Close()
+	})
+}
+
+// readLoop reads frames until the connection is closed.
+func (c *WebSocketConn) readLoop() {
+	err := c.readMessages()
+	var closeErr *WebSocketCloseError
+	if !errors.As(err, &closeErr) {
+		err = &WebSocketCloseError{Code: WebSocketCloseAbnormal}
+	}
+	c.closeErr = err
+	close(c.done)
+	c.shutdown()
+}
+
+// readMessages assembles data messages from frames and handles control
+// frames, until the connection is closed.
+func (c *WebSocketConn) readMessages() error {
+	var messageType WebSocketMessageType
+	var message []byte
+	fragmented := false
+
+	for {
+		if c.pingInterval > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.pingInterval))
+		}
+
+		var head [2]byte
+		if _, err := io.ReadFull(c.br, head[:]); err != nil {
+			return err
+		}
+		fin := head[0]&0x80 != 0
+		opcode := head[0] & 0x0f
+		masked := head[1]&0x80 != 0
+		if head[0]&0x70 != 0 {
+			return c.fail(WebSocketCloseProtocolError, "reserved bits set")
+		}
+		if masked == c.client {
+			return c.fail(WebSocketCloseProtocolError, "invalid frame masking")
+		}
+
+		length := uint64(head[1] & 0x7f)
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = binary.BigEndian.Uint64(ext[:])
+			if length > 1<<63-1 {
+				return c.fail(WebSocketCloseProtocolError, "invalid frame length")
+			}
+		}
+
+		isControl := opcode >= wsOpClose
+		if isControl && (length > 125 || !fin) {
+			return c.fail(WebSocketCloseProtocolError, "invalid control frame")
+		}
+		if !isControl && c.maxMessageSize >= 0 && int64(length) > c.maxMessageSize-int64(len(message)) {
+			return c.fail(WebSocketCloseMessageTooBig, "message too big")
+		}
+
+		var key [4]byte
+		if masked {
+			if _, err := io.ReadFull(c.br, key[:]); err != nil {
+				return err
+			}
+		}
+		payload, err := readWebSocketPayload(c.br, length)
+		if err != nil {
+			return err
+		}
+		if masked {
+			maskWebSocketPayload(payload, key)
+		}
+
+		switch opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil && !errors.Is(err, errWebSocketClosed) {
+				return err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return c.receiveClose(payload)
+		case wsOpText, wsOpBinary:
+			if fragmented {
+				return c.fail(WebSocketCloseProtocolError, "expected continuation frame")
+			}
+			messageType = WebSocketMessageType(opcode)
+			message = payload
+		case wsOpContinuation:
+			if !fragmented {
+				return c.fail(WebSocketCloseProtocolError, "unexpected continuation frame")
+			}
+			message = append(message, payload...)
+		default:
+			return c.fail(WebSocketCloseProtocolError, "unknown opcode")
+		}
+
+		fragmented = !fin
+		if fragmented {
+			continue
+		}
+		if messageType == WebSocketTextMessage && !utf8.Valid(message) {
+			return c.fail(WebSocketCloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+
+		select {
+		case c.messages <- webSocketMessage{messageType: messageType, data: message}:
+		case <-c.closing:
+			// Data received after sending a close frame is discarded
+		case <-c.closed:
+			return errWebSocketClosed
+		}
+		message = nil
+	}
+}
+
+// webSocketPayloadChunk is the largest payload allocated before it is read.
+const webSocketPayloadChunk = 64 << 10
+
+// readWebSocketPayload reads a frame payload of length bytes. Large payloads
+// are read in chunks, growing the buffer as the data arrives, so a frame
+// claiming an enormous length cannot exhaust memory before sending it.
+func readWebSocketPayload(r io.Reader, length uint64) ([]byte, error) {
+	if length <= webSocketPayloadChunk {
+		payload := make([]byte, length)
+		_, err := io.ReadFull(r, payload)
+		return payload, err
+	}
+
+	var payload []byte
+	for remaining := length; remaining > 0; {
+		n := min(remaining, webSocketPayloadChunk)
+		payload = slices.Grow(payload, int(n))
+		chunk := payload[len(payload) : len(payload)+int(n)]
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		payload = payload[:len(payload)+int(n)]
+		remaining -= n
+	}
+	return payload, nil
+}
+
+// receiveClose handles a close frame from the peer, echoing it unless the
+// close handshake was started locally.
+func (c *WebSocketConn) receiveClose(payload []byte) error {
+	closeErr := &WebSocketCloseError{Code: WebSocketCloseNoStatus}
+	if len(payload) == 1 {
+		return c.fail(WebSocketCloseProtocolError, "invalid close frame")
+	}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validWebSocketCloseCode(closeErr.Code) {
+			return c.fail(WebSocketCloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(WebSocketCloseInvalidPayload, "invalid UTF-8 in close reason")
+		}
+	}
+	_ = c.writeClose(closeErr.Code, "")
+	return closeErr
+}
+
+// fail closes the connection after a protocol error, returning the error.
+func (c *WebSocketConn) fail(code int, reason string) error {
+	_ = c.writeClose(code, reason)
+	return &WebSocketCloseError{Code: code, Reason: reason}
+}
+
+// pingLoop pings the peer every pingInterval until the connection is
+// closed. The read deadline closes connections that stop answering.
+func (c *WebSocketConn) pingLoop() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			if c.writeFrame(wsOpPing, nil) != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeClose sends a close frame, unless one was already sent.
+func (c *WebSocketConn) writeClose(code int, reason string) error {
+	var payload []byte
+	if code != WebSocketCloseNoStatus && code != WebSocketCloseAbnormal {
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+	return c.writeFrame(wsOpClose, payload)
+}
+
+// writeFrame sends a single frame, masked on client connections.
+func (c *WebSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return errWebSocketClosed
+	}
+	if opcode == wsOpClose {
+		c.closeSent = true
+		close(c.closing)
+	}
+
+	frame := make([]byte, 0, len(payload)+14)
+	frame = append(frame, 0x80|opcode)
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, maskBit|byte(length))
+	case length <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	if c.client {
+		var key [4]byte
+		_, _ = rand.Read(key[:])
+		frame = append(frame, key[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskWebSocketPayload(frame[start:], key)
+	} else {
+		frame = append(frame, payload...)
+	}
+
+	if c.writeTimeout > 0 {
+		_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// maskWebSocketPayload masks or unmasks payload in place.
+func maskWebSocketPayload(payload []byte, key [4]byte) {
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+}
+
+// validWebSocketCloseCode reports whether code may be received in a close
+// frame.
+func validWebSocketCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
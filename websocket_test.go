@@ -0,0 +1,312 @@
+package rtr_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dracory/rtr"
+	"github.com/dracory/rtr/middlewares"
+)
+
+// echoWebSocket echoes messages until the connection is closed.
+func echoWebSocket(ctx context.Context, conn *rtr.WebSocketConn) error {
+	for {
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := conn.WriteMessage(messageType, data); err != nil {
+			return err
+		}
+	}
+}
+
+// newWebSocketServer serves router, returning its ws:// base URL.
+func newWebSocketServer(t *testing.T, router rtr.RouterInterface) string {
+	t.Helper()
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func dialWebSocket(t *testing.T, url string, header http.Header) *rtr.WebSocketConn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	conn, _, err := rtr.DialWebSocket(ctx, url, header)
+	if err != nil {
+		t.Fatalf("Unexpected error dialing %s: %v", url, err)
+	}
+	t.Cleanup(func() { _ = conn.Close(rtr.WebSocketCloseNormal, "") })
+	return conn
+}
+
+func TestWebSocket_EchoThroughGroupMiddlewares(t *testing.T) {
+	auth := rtr.NewMiddleware().SetName("auth").SetHandler(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	router := rtr.NewRouter()
+	router.AddBeforeMiddlewares([]rtr.MiddlewareInterface{middlewares.RecoveryMiddleware(), middlewares.LoggerMiddleware()})
+	router.AddGroup(rtr.NewGroup().SetPrefix("/live").
+		AddBeforeMiddlewares([]rtr.MiddlewareInterface{auth}).
+		AddRoute(rtr.WebSocket("/chat", func(ctx context.Context, conn *rtr.WebSocketConn) error {
+			if err := conn.WriteMessage(rtr.WebSocketTextMessage, []byte("protocol="+conn.Subprotocol())); err != nil {
+				return err
+			}
+			return echoWebSocket(ctx, conn)
+		}).SetWebSocketConfig(rtr.WebSocketConfig{Subprotocols: []string{"chat.v2", "chat.v1"}})))
+	url := newWebSocketServer(t, router)
+
+	if _, resp, err := rtr.DialWebSocket(context.Background(), url+"/live/chat", nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected the auth middleware to refuse the handshake, got %v, %v", resp, err)
+	}
+
+	conn := dialWebSocket(t, url+"/live/chat", http.Header{
+		"Authorization":          {"Bearer secret"},
+		"Sec-Websocket-Protocol": {"chat.v1, chat.v2"},
+	})
+	if conn.Subprotocol() != "chat.v2" {
+		t.Errorf("Expected the server's preferred subprotocol, got %q", conn.Subprotocol())
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "protocol=chat.v2" {
+		t.Fatalf("Expected the greeting, got %q, %v", data, err)
+	}
+
+	large := strings.Repeat("x", 70000)
+	for _, msg := range []struct {
+		messageType rtr.WebSocketMessageType
+		data        string
+	}{
+		{rtr.WebSocketTextMessage, "hello"},
+		{rtr.WebSocketBinaryMessage, "\x00\x01\x02"},
+		{rtr.WebSocketTextMessage, large},
+	} {
+		if err := conn.WriteMessage(msg.messageType, []byte(msg.data)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		messageType, data, err := conn.ReadMessage()
+		if err != nil || messageType != msg.messageType || string(data) != msg.data {
+			t.Errorf("Expected the message to be echoed, got %d %d bytes, %v", messageType, len(data), err)
+		}
+	}
+}
+
+func TestWebSocket_Handshake(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.WebSocket("/ws", echoWebSocket).
+		SetWebSocketConfig(rtr.WebSocketConfig{AllowedOrigins: []string{"https://app.example.com"}}))
+	url := newWebSocketServer(t, router)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rr.Code != http.StatusUpgradeRequired || rr.Header().Get("Upgrade") != "websocket" {
+		t.Errorf("Expected a plain request to get 426, got %d %v", rr.Code, rr.Header())
+	}
+
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://app.example.com", true},
+		{strings.Replace(url, "ws://", "http://", 1), true},
+		{"https://evil.example.com", false},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.origin != "" {
+			header.Set("Origin", tt.origin)
+		}
+		conn, resp, err := rtr.DialWebSocket(context.Background(), url+"/ws", header)
+		if tt.ok {
+			if err != nil {
+				t.Errorf("Expected origin %q to be allowed, got %v", tt.origin, err)
+				continue
+			}
+			_ = conn.Close(rtr.WebSocketCloseNormal, "")
+		} else if err == nil || resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected origin %q to be refused with 403, got %v", tt.origin, err)
+		}
+	}
+}
+
+func TestWebSocket_Close(t *testing.T) {
+	canceled := make(chan struct{})
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.WebSocket("/limited", echoWebSocket).
+		SetWebSocketConfig(rtr.WebSocketConfig{MaxMessageSize: 16}))
+	router.AddRoute(rtr.WebSocket("/policy", func(ctx context.Context, conn *rtr.WebSocketConn) error {
+		return &rtr.WebSocketCloseError{Code: rtr.WebSocketClosePolicyViolation, Reason: "not allowed"}
+	}))
+	router.AddRoute(rtr.WebSocket("/wait", func(ctx context.Context, conn *rtr.WebSocketConn) error {
+		go func() { _, _, _ = conn.ReadMessage() }()
+		<-ctx.Done()
+		close(canceled)
+		return nil
+	}))
+	url := newWebSocketServer(t, router)
+
+	conn := dialWebSocket(t, url+"/limited", nil)
+	_ = conn.WriteMessage(rtr.WebSocketTextMessage, []byte(strings.Repeat("x", 17)))
+	var closeErr *rtr.WebSocketCloseError
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != rtr.WebSocketCloseMessageTooBig {
+		t.Errorf("Expected a message over the limit to close with 1009, got %v", err)
+	}
+
+	conn = dialWebSocket(t, url+"/policy", nil)
+	if _, _, err := conn.ReadMessage(); !errors.As(err, &closeErr) || closeErr.Code != rtr.WebSocketClosePolicyViolation || closeErr.Reason != "not allowed" {
+		t.Errorf("Expected the handler's close code, got %v", err)
+	}
+
+	conn = dialWebSocket(t, url+"/wait", nil)
+	if err := conn.Close(rtr.WebSocketCloseGoingAway, "bye"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the handler's context to be canceled when the client closes")
+	}
+	if err := conn.WriteMessage(rtr.WebSocketTextMessage, []byte("late")); err == nil {
+		t.Error("Expected writing to a closed connection to fail")
+	}
+}
+
+// rawWebSocket performs the handshake on a plain TCP connection, for
+// exercising the framing directly.
+func rawWebSocket(t *testing.T, url string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	host, _, _ := strings.Cut(strings.TrimPrefix(url, "ws://"), "/")
+	conn, err := net.Dial("tcp", host)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, strings.Replace(url, "ws://", "http://", 1), nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	_ = req.Write(conn)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v, %v", resp, err)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Expected the RFC 6455 accept value, got %q", resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return conn, br
+}
+
+// writeRawFrame writes a frame with a zero mask key when masked.
+func writeRawFrame(conn net.Conn, fin bool, opcode byte, masked bool, payload string) {
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	second := byte(len(payload))
+	frame := []byte{first, second}
+	if masked {
+		frame[1] |= 0x80
+		frame = append(frame, 0, 0, 0, 0)
+	}
+	_, _ = conn.Write(append(frame, payload...))
+}
+
+// readRawFrame reads an unmasked frame of up to 125 bytes.
+func readRawFrame(t *testing.T, br *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		t.Fatalf("Unexpected error reading frame: %v", err)
+	}
+	payload := make([]byte, head[1]&0x7f)
+	_, _ = io.ReadFull(br, payload)
+	return head[0], payload
+}
+
+func TestWebSocket_OversizedFrameLength(t *testing.T) {
+	result := make(chan error, 1)
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.WebSocket("/unlimited", func(ctx context.Context, conn *rtr.WebSocketConn) error {
+		_, _, err := conn.ReadMessage()
+		result <- err
+		return err
+	}).SetWebSocketConfig(rtr.WebSocketConfig{MaxMessageSize: -1}))
+	url := newWebSocketServer(t, router)
+
+	// A masked binary frame claiming 2^62 bytes, followed by a few only
+	conn, _ := rawWebSocket(t, url+"/unlimited")
+	frame := []byte{0x82, 0x80 | 127}
+	frame = binary.BigEndian.AppendUint64(frame, 1<<62)
+	frame = append(frame, 0, 0, 0, 0)
+	_, _ = conn.Write(append(frame, "short"...))
+	_ = conn.(*net.TCPConn).CloseWrite()
+
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("Expected the truncated frame to fail the read")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the connection to fail once the peer stopped sending")
+	}
+}
+
+func TestWebSocket_Framing(t *testing.T) {
+	router := rtr.NewRouter()
+	router.AddRoute(rtr.WebSocket("/ws", echoWebSocket))
+	router.AddRoute(rtr.WebSocket("/ping", func(ctx context.Context, conn *rtr.WebSocketConn) error {
+		<-ctx.Done()
+		return nil
+	}).SetWebSocketConfig(rtr.WebSocketConfig{PingInterval: 20 * time.Millisecond}))
+	url := newWebSocketServer(t, router)
+
+	conn, br := rawWebSocket(t, url+"/ws")
+	writeRawFrame(conn, false, 0x1, true, "frag")
+	writeRawFrame(conn, true, 0x9, true, "are you there")
+	writeRawFrame(conn, true, 0x0, true, "mented")
+	if head, payload := readRawFrame(t, br); head != 0x8a || string(payload) != "are you there" {
+		t.Errorf("Expected a pong echoing the ping, got %#x %q", head, payload)
+	}
+	if head, payload := readRawFrame(t, br); head != 0x81 || string(payload) != "fragmented" {
+		t.Errorf("Expected the reassembled message, got %#x %q", head, payload)
+	}
+	writeRawFrame(conn, true, 0x1, false, "unmasked")
+	head, payload := readRawFrame(t, br)
+	if head != 0x88 || len(payload) < 2 || binary.BigEndian.Uint16(payload) != rtr.WebSocketCloseProtocolError {
+		t.Errorf("Expected an unmasked client frame to close with 1002, got %#x %q", head, payload)
+	}
+
+	conn, br = rawWebSocket(t, url+"/ping")
+	if head, _ := readRawFrame(t, br); head != 0x89 {
+		t.Errorf("Expected the server to ping, got %#x", head)
+	}
+	for {
+		if _, err := br.ReadByte(); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			t.Fatalf("Expected a client not answering pings to be disconnected, got %v", err)
+		}
+	}
+}